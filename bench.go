@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var benchBlockRegex = regexp.MustCompile(`(?m)^\s*bench\s+"`)
+
+// findBlockFiles returns the files given, or every file below the current directory
+// with blocks matching blockRegex, like bench "name" ( ... )
+func findBlockFiles(args []string, blockRegex *regexp.Regexp) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	var files []string
+	err := filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != "." && skipBuildDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".osl") {
+			return nil
+		}
+		if data, err := os.ReadFile(path); err == nil && blockRegex.Match(data) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// blocksAst turns a program into one that runs its bench or fuzz blocks: def main and
+// the mainloop are left out, the rest of the top level code runs first to set up, then run
+func blocksAst(ast [][]*Token, run string) [][]*Token {
+	var out [][]*Token
+	for _, line := range ast {
+		if _, ok := mainloopRate(line); ok {
+			break
+		}
+		if len(line) > 1 && line[0].Type == TKN_CMD && line[0].Data == "def" && line[1].Data == "main" {
+			continue
+		}
+		out = append(out, line)
+	}
+	return append(out, scriptToAst(run)...)
+}
+
+// compileBlocks compiles the program that runs the bench or fuzz blocks of the file at path
+// into main.go in a new temporary directory, next to the go.mod and embedded files it needs
+func compileBlocks(path string, options CompileOptions, run string) (tmpDir string, err error) {
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chdir(filepath.Dir(path)); err != nil {
+		return "", err
+	}
+	defer os.Chdir(originalDir)
+
+	script := openFile(filepath.Base(path))
+	if script == "" {
+		return "", fmt.Errorf("nothing to run in %s", path)
+	}
+
+	compileOptions = options
+	source, err := func() (source string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		return "package main\n\n" + Compile(blocksAst(scriptToAst(script), run)), nil
+	}()
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err = os.MkdirTemp("", "osl-blocks-*")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte(source), 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	if err := copyGoModFiles(cwd, tmpDir); err != nil {
+		fmt.Println("Warning: failed to copy go.mod/go.sum:", err)
+	}
+	if err := copyEmbeddedFiles(cwd, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return tmpDir, nil
+}
+
+// runBlocks builds the program compileBlocks wrote to tmpDir and runs it with args
+func runBlocks(tmpDir string, opts buildOptions, args []string) error {
+	binary := filepath.Join(tmpDir, "blocks")
+	buildCmd := exec.Command("go", opts.goBuildArgs(binary, filepath.Join(tmpDir, "main.go"))...)
+	buildCmd.Dir = tmpDir
+	buildCmd.Env = opts.goBuildEnv()
+	if output, err := buildCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("build failed:\n%s", output)
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// benchFile compiles and runs the bench blocks of one file, passing the harness its flags
+func benchFile(path string, opts buildOptions, harnessArgs []string) error {
+	options := opts.toCompileOptions()
+	options.Bench = true
+	tmpDir, err := compileBlocks(path, options, "bench.Run()")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	return runBlocks(tmpDir, opts, harnessArgs)
+}
+
+func benchmark(args []string) {
+	// flags for the harness in osl/bench, the rest are build options
+	harnessFlags := map[string]bool{"--run": true, "--time": true, "--warmup": true, "--count": true}
+	var harnessArgs, rest []string
+	save, compare := "", ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case harnessFlags[arg]:
+			if i+1 >= len(args) {
+				fmt.Printf("Error: %s flag requires a value\n", arg)
+				return
+			}
+			harnessArgs = append(harnessArgs, strings.TrimPrefix(arg, "-"), args[i+1])
+			i++
+		case arg == "--save" || arg == "--compare":
+			// the baseline file is optional, it defaults to <name>.bench.json next to the file
+			file := "default"
+			if i+1 < len(args) && strings.HasSuffix(args[i+1], ".json") {
+				file = args[i+1]
+				i++
+			}
+			if arg == "--save" {
+				save = file
+			} else {
+				compare = file
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	opts, err := parseBuildArgs(rest, false)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if opts.interp || opts.target != "" {
+		fmt.Println("Error: osl bench builds a native binary, --interp and --target are not supported")
+		return
+	}
+	files, err := findBlockFiles(opts.inputs, benchBlockRegex)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Println(`No bench blocks found, add some with: bench "name" ( ... )`)
+		fmt.Println("Usage: osl bench [file.osl...] [--run <regexp>] [--time <duration>] [--warmup <duration>] [--count <n>] [--save [file.json]] [--compare [file.json]]")
+		return
+	}
+
+	failed := false
+	for _, file := range files {
+		baseline, err := filepath.Abs(strings.TrimSuffix(file, ".osl") + ".bench.json")
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fileArgs := append([]string{}, harnessArgs...)
+		baselineArg := func(flag string, value string) {
+			switch value {
+			case "":
+			case "default":
+				fileArgs = append(fileArgs, flag, baseline)
+			default:
+				abs, _ := filepath.Abs(value)
+				fileArgs = append(fileArgs, flag, abs)
+			}
+		}
+		baselineArg("-compare", compare)
+		baselineArg("-save", save)
+		if len(files) > 1 {
+			fmt.Println(file + ":")
+		}
+		if err := benchFile(file, opts, fileArgs); err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildTarget is one program osl build compiles
+type buildTarget struct {
+	path   string // the .osl file, relative to where osl build runs
+	name   string // the binary name in the output directory
+	output string
+	tmpDir string
+	err    string
+	took   time.Duration
+}
+
+var entrypointRegex = regexp.MustCompile(`(?m)^\s*(def\s+main\s*\(|mainloop(\s+[0-9.]+)?\s*:)`)
+
+// skipBuildDir reports whether osl build ./... should leave a directory out
+func skipBuildDir(name string) bool {
+	switch name {
+	case "bin", "frames", "node_modules", "osl_packages", "testdata", "vendor":
+		return true
+	}
+	return strings.HasPrefix(name, ".") && name != "." || strings.HasPrefix(name, "_")
+}
+
+// isEntrypoint reports whether an osl file is a program rather than code other files import
+func isEntrypoint(path string) bool {
+	if strings.HasSuffix(path, "_test.osl") {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	return err == nil && entrypointRegex.Match(data)
+}
+
+// dirEntrypoints returns the entrypoints in one directory: the files listed in its
+// osl.json and the files with a def main or mainloop
+func dirEntrypoints(dir string) ([]string, error) {
+	found := make(map[string]bool)
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		for _, entry := range m.Entrypoints {
+			path := filepath.Join(dir, filepath.FromSlash(entry))
+			if _, err := os.Stat(path); err != nil {
+				return nil, fmt.Errorf("%s lists entrypoint %s: %v", filepath.Join(dir, "osl.json"), entry, err)
+			}
+			found[path] = true
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".osl") && isEntrypoint(path) {
+			found[path] = true
+		}
+	}
+	return sortedKeys(found), nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findEntrypoints expands build patterns like go build does: dir/... is every
+// directory below dir, a directory is the entrypoints in it and a .osl file is itself
+func findEntrypoints(patterns []string) ([]string, error) {
+	found := make(map[string]bool)
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, ".osl") {
+			if _, err := os.Stat(pattern); err != nil {
+				return nil, err
+			}
+			found[filepath.Clean(pattern)] = true
+			continue
+		}
+
+		root, recursive := strings.CutSuffix(pattern, "...")
+		root = filepath.Clean(strings.TrimSuffix(root, "/"))
+		if root == "" {
+			root = "."
+		}
+		if !recursive {
+			paths, err := dirEntrypoints(root)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				found[path] = true
+			}
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if path != root && skipBuildDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			paths, err := dirEntrypoints(path)
+			for _, p := range paths {
+				found[p] = true
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortedKeys(found), nil
+}
+
+// targetName picks the binary name for an entrypoint: the file name, or for a
+// main.osl the project name from osl.json or else the directory name
+func targetName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), ".osl")
+	if name != "main" {
+		return name
+	}
+	dir := filepath.Dir(path)
+	if m, err := readManifest(dir); err == nil && m != nil && m.Name != "" {
+		return m.Name
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return filepath.Base(abs)
+	}
+	return name
+}
+
+// nameTargets gives every target a binary name, using the whole path for names
+// that would otherwise clash
+func nameTargets(paths []string) []*buildTarget {
+	counts := make(map[string]int)
+	for _, path := range paths {
+		counts[targetName(path)]++
+	}
+	targets := make([]*buildTarget, len(paths))
+	for i, path := range paths {
+		name := targetName(path)
+		if counts[name] > 1 {
+			name = strings.ReplaceAll(filepath.ToSlash(strings.TrimSuffix(path, ".osl")), "/", "-")
+		}
+		targets[i] = &buildTarget{path: path, name: name}
+	}
+	return targets
+}
+
+// findGoMod returns the nearest directory from dir up to root with a go.mod,
+// so entrypoints in a project's subdirectories use the project's Go dependencies
+func findGoMod(dir string, root string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if dir == root || parent == dir || !strings.HasPrefix(parent, root) {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// parsedImport is an imported .osl file, parsed once and shared by every target of a build
+type parsedImport struct {
+	source        string
+	ast           [][]*Token
+	functionTypes map[string]FunctionSignature
+}
+
+// importCache holds the imports parsed so far during osl build, it is nil otherwise
+var importCache map[string]*parsedImport
+
+// importAst parses an imported .osl file. During osl build each file is parsed once
+// and every target compiles its own copy of the tokens, since compiling changes them.
+func importAst(path string, source string) [][]*Token {
+	if importCache == nil {
+		return scriptToAst(source)
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return scriptToAst(source)
+	}
+	if cached, ok := importCache[key]; ok && cached.source == source {
+		maps.Copy(parser.functionReturnTypes, cached.functionTypes)
+		maps.Copy(allFunctionTypes, cached.functionTypes)
+		return cloneAst(cached.ast)
+	}
+
+	before := maps.Clone(parser.functionReturnTypes)
+	ast := scriptToAst(source)
+	added := make(map[string]FunctionSignature)
+	for name, sig := range parser.functionReturnTypes {
+		if old, ok := before[name]; !ok || !sameSignature(old, sig) {
+			added[name] = sig
+		}
+	}
+	importCache[key] = &parsedImport{source: source, ast: cloneAst(ast), functionTypes: added}
+	return ast
+}
+
+func sameSignature(a, b FunctionSignature) bool {
+	return a.Returns == b.Returns && strings.Join(a.Accepts, ",") == strings.Join(b.Accepts, ",")
+}
+
+func cloneAst(ast [][]*Token) [][]*Token {
+	out := make([][]*Token, len(ast))
+	for i, line := range ast {
+		out[i] = cloneTokens(line)
+	}
+	return out
+}
+
+func cloneTokens(tokens []*Token) []*Token {
+	if tokens == nil {
+		return nil
+	}
+	out := make([]*Token, len(tokens))
+	for i, t := range tokens {
+		out[i] = t.clone()
+	}
+	return out
+}
+
+func (t *Token) clone() *Token {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.Data = cloneTokenData(t.Data)
+	c.Static = cloneTokenData(t.Static)
+	c.Cases = cloneTokenData(t.Cases)
+	c.Left = t.Left.clone()
+	c.Right = t.Right.clone()
+	c.Right2 = t.Right2.clone()
+	c.Final = t.Final.clone()
+	c.Parameters = cloneTokens(t.Parameters)
+	c.ObjPath = cloneTokens(t.ObjPath)
+	return &c
+}
+
+// cloneTokenData copies the values the parser keeps in a token's Data, Static and Cases
+func cloneTokenData(data any) any {
+	switch v := data.(type) {
+	case *Token:
+		return v.clone()
+	case []*Token:
+		return cloneTokens(v)
+	case [][]*Token:
+		return cloneAst(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = cloneTokenData(item)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = cloneTokenData(item)
+		}
+		return out
+	}
+	return data
+}
+
+// transpile writes the Go code for a target and the files it needs into its temp dir
+func (t *buildTarget) transpile(root string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	dir := filepath.Dir(t.path)
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(root)
+
+	script, err := os.ReadFile(filepath.Base(t.path))
+	if err != nil {
+		return err
+	}
+
+	// every target starts from a clean parser, only the imports it shares are reused
+	parser = NewOSLUtils()
+	allFunctionTypes = map[string]FunctionSignature{}
+	compileOptions.File = t.path
+
+	ast := scriptToAst(string(script))
+	if compileOptions.Target != "" {
+		if err := checkTargetImports(ast, compileOptions.Target); err != nil {
+			return err
+		}
+	}
+	source := "package main\n\n" + Compile(ast)
+	if err := os.WriteFile(filepath.Join(t.tmpDir, "main.go"), []byte(source), 0644); err != nil {
+		return err
+	}
+
+	absDir := filepath.Join(root, dir)
+	if modDir := findGoMod(absDir, root); modDir != "" {
+		if err := copyGoModFiles(modDir, t.tmpDir); err != nil {
+			return err
+		}
+	}
+	return copyEmbeddedFiles(absDir, t.tmpDir)
+}
+
+func (t *buildTarget) goBuild(opts buildOptions) error {
+	cmd := exec.Command("go", opts.goBuildArgs(t.output, "main.go")...)
+	cmd.Dir = t.tmpDir
+	cmd.Env = opts.goBuildEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	if opts.target == "wasm" {
+		_, err = writeWasmLoader(t.output)
+	}
+	return err
+}
+
+func build(args []string) {
+	jobs := runtime.NumCPU()
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-j" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			fmt.Println("Error: -j flag requires a number of parallel builds")
+			return
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 1 {
+			fmt.Println("Error: -j flag requires a number of parallel builds, got", args[i+1])
+			return
+		}
+		jobs = n
+		i++
+	}
+
+	opts, err := parseBuildArgs(rest, true)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if opts.interp {
+		fmt.Println("Error: --interp only works with osl run")
+		return
+	}
+	if opts.shared {
+		fmt.Println("Error: --shared only works with osl compile")
+		return
+	}
+	patterns := opts.inputs
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	outDir := opts.output
+	if outDir == "" {
+		outDir = "bin"
+	}
+
+	paths, err := findEntrypoints(patterns)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if len(paths) == 0 {
+		fmt.Println("No entrypoints found in", strings.Join(patterns, " ")+", looked for files with def main or mainloop: and osl.json entrypoints")
+		os.Exit(1)
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		fmt.Println("Failed to get current directory:", err)
+		return
+	}
+	if outDir, err = filepath.Abs(outDir); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fmt.Println("Failed to create output directory:", err)
+		return
+	}
+	tmpRoot, err := os.MkdirTemp("", "osl-build-*")
+	if err != nil {
+		fmt.Println("Failed to create temp dir:", err)
+		return
+	}
+	defer os.RemoveAll(tmpRoot)
+
+	targets := nameTargets(paths)
+	fmt.Printf("Building %d target(s) into %s with %d parallel build(s)\n", len(targets), outDir, jobs)
+
+	// the osl compiler keeps global state, so targets are transpiled one at a time
+	// while go build runs for the ones already transpiled
+	compileOptions = opts.toCompileOptions()
+	importCache = make(map[string]*parsedImport)
+	defer func() { importCache = nil }()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, jobs)
+	for i, t := range targets {
+		start := time.Now()
+		t.tmpDir = filepath.Join(tmpRoot, strconv.Itoa(i))
+		t.output = filepath.Join(outDir, t.name)
+		if opts.target != "" {
+			t.output += ".wasm"
+		}
+		if err := os.MkdirAll(t.tmpDir, 0755); err != nil {
+			t.err = err.Error()
+			continue
+		}
+		if err := t.transpile(root); err != nil {
+			mu.Lock()
+			t.err = err.Error()
+			fmt.Printf("  FAIL  %s\n", t.path)
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := t.goBuild(opts)
+			mu.Lock()
+			defer mu.Unlock()
+			t.took = time.Since(start)
+			if err != nil {
+				t.err = err.Error()
+				fmt.Printf("  FAIL  %s\n", t.path)
+				return
+			}
+			rel, _ := filepath.Rel(root, t.output)
+			fmt.Printf("  ok    %s -> %s (%.1fs)\n", t.path, rel, t.took.Seconds())
+		}()
+	}
+	wg.Wait()
+
+	var failed []*buildTarget
+	for _, t := range targets {
+		if t.err != "" {
+			failed = append(failed, t)
+		}
+	}
+	fmt.Println()
+	if len(failed) == 0 {
+		fmt.Printf("Built %d target(s)\n", len(targets))
+		return
+	}
+	fmt.Printf("Built %d of %d target(s), %d failed:\n", len(targets)-len(failed), len(targets), len(failed))
+	for _, t := range failed {
+		fmt.Println()
+		fmt.Println(t.path + ":")
+		for _, line := range strings.Split(t.err, "\n") {
+			fmt.Println("    " + line)
+		}
+	}
+	os.Exit(1)
+}
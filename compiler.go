@@ -2,14 +2,14 @@ package main
 
 import (
 	"embed"
-	"encoding/json"
 	"fmt"
-	"io"
 	"maps"
-	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -38,8 +38,45 @@ type VariableContext struct {
 	GlobalVars          strings.Builder
 	HoistedVars         []string
 	OSLPackagePrefixes  []string
+	SharedVars          map[string]string
+	TopLevelVars        map[string]string // top level variables, to the collectionKind of what they were set to
+	GoDepth             int
+	Line                int
+	Constants           map[string]any
+	InGenerator         bool
+	Nullable            map[string]string // variables declared T null, with their T
+	Narrowed            map[string]bool   // nullable variables checked for null at this point
+	warned              map[string]bool
+	workerHandlers      map[*Token]bool   // functions in a worker's object, which get the worker as self
+	SourceFile          string            // the absolute path of the osl file being compiled, for line directives
+	ReturnType          string            // the Go type the function being compiled returns, "" when it is not typed
+	exports             []*Token          // the export def functions, compiled to C functions under --shared
+	exported            map[string]bool   // names of the export def functions, which are renamed in Go
+	packageVars         map[string]string // imported osl packages whose var has another Go name, see oslPackage.goVar
 }
 
+// CompileOptions holds the settings the CLI passes through to the code generator
+type CompileOptions struct {
+	ProfileDir  string
+	SafeGlobals bool
+	Target      string
+	Tags        []string
+	Defines     map[string]string
+	Headless    bool
+	Frames      int
+	FramesDir   string
+	Input       string
+	File        string // the file being compiled, named in warnings when osl build compiles many
+	Debug       bool   // map the Go code back to the osl source with line directives, for osl debug
+	Bench       bool   // compile bench blocks, for osl bench
+	Fuzz        bool   // compile fuzz blocks, for osl fuzz
+	Comments    bool   // carry osl comments over to the Go code, for osl transpile
+	Split       bool   // mark which file each part of the Go code goes in, for osl transpile -o
+	Shared      bool   // export export def functions to C, for osl compile --shared
+}
+
+var compileOptions = CompileOptions{}
+
 type MethodDefinition struct {
 	TypeName   string
 	MethodName string
@@ -55,6 +92,14 @@ func include(path string) string {
 	return string(data) + "\n"
 }
 
+func mainPrologue(ctx *VariableContext) string {
+	var out strings.Builder
+	if compileOptions.ProfileDir != "" {
+		fmt.Fprintf(&out, "\tprofile.start(%v)\n\tdefer profile.stop()\n", JsonStringify(compileOptions.ProfileDir))
+	}
+	return out.String()
+}
+
 var oslTypes = map[string]string{
 	"string":  "string",
 	"int":     "int",
@@ -73,8 +118,13 @@ func mapOSLTypeToGo(oslType string) string {
 	if oslType == "null" {
 		return ""
 	}
-	if strings.HasSuffix(oslType, " null") {
-		return strings.TrimSuffix(oslType, " null")
+	if base, ok := nullableBase(oslType); ok {
+		// value types are boxed in any so they can hold null
+		goType := mapOSLTypeToGo(base)
+		if isNilable(goType) {
+			return goType
+		}
+		return "any"
 	}
 	if before, ok := strings.CutSuffix(oslType, "[]"); ok {
 		return "[]" + mapOSLTypeToGo(before)
@@ -161,7 +211,11 @@ func processImports(ctx *VariableContext) (compiled string, goImports []string)
 				panic(err)
 			}
 			if strings.HasSuffix(importPath, ".osl") {
-				ast := scriptToAst(string(data))
+				ast := importAst(importPath, string(data))
+				savedSource := ctx.SourceFile
+				if compileOptions.Debug {
+					ctx.SourceFile, _ = filepath.Abs(strings.TrimPrefix(importPath, "./"))
+				}
 				for _, line := range ast {
 					if len(line) > 0 {
 						if line[0].Type == TKN_ASI {
@@ -180,52 +234,44 @@ func processImports(ctx *VariableContext) (compiled string, goImports []string)
 					}
 				}
 				compiledBlock := CompileBlock(ast, ctx)
-				compiled += "\n" + compiledBlock
+				ctx.SourceFile = savedSource
+				compiled += "\n" + fileDirective(importFileName(importPath)) + compiledBlock
 			} else if strings.HasSuffix(importPath, ".go") {
-				compiled += "\n" + string(data)
+				compiled += "\n" + fileDirective(importFileName(importPath)) + string(data)
 			}
 
 		case strings.HasPrefix(importPath, "osl/"):
 			packageName := strings.TrimPrefix(importPath, "osl/")
 			ctx.OSLPackagePrefixes = append(ctx.OSLPackagePrefixes, packageName)
-			data, err := packagesFS.ReadFile("packages/" + packageName + ".go")
-			if err != nil {
-				panic(err)
-			}
-			file := strings.TrimSpace(string(data))
-
-			for _, line := range strings.Split(file, "\n") {
-				if requires, ok := strings.CutPrefix(line, "// requires: "); ok {
-					for _, part := range strings.Split(requires, ", ") {
-						part = strings.TrimSpace(part)
-						goImports = append(goImports, part)
-					}
-				}
-			}
+			files := []string{packageName}
 			if importPath == "osl/window" {
-				fontUrl := "https://raw.githubusercontent.com/Mistium/Origin-OS/main/Fonts/origin.ojff"
-
-				resp, err := http.Get(fontUrl)
+				files = append(files, windowBackend())
+			}
+			for _, name := range files {
+				p, err := findPackage(name)
 				if err != nil {
 					panic(err)
 				}
-				defer resp.Body.Close()
-
-				data, err := io.ReadAll(resp.Body)
-				if err != nil {
-					panic(err)
+				if warning := p.overrideWarning(); warning != "" {
+					ctx.Line = 0
+					compileWarning(ctx, "%s", warning)
 				}
-
-				var fontMap map[string]any
-				err = json.Unmarshal(data, &fontMap)
+				goImports = append(goImports, p.requires...)
+				compiled = "\n" + fileDirective("osl_"+name+".go") + generatedDirective() + strings.TrimSpace(p.source) + compiled
+			}
+			if importPath == "osl/window" {
+				font, err := windowFont()
 				if err != nil {
-					panic(err)
+					// without the font the window draws everything but text
+					ctx.Line = 0
+					compileWarning(ctx, "could not download the window font, text will not be drawn: %v", err)
+					font = "\n\nvar OSLfont map[string]string\n\n"
 				}
-				delete(fontMap, "origin")
-
-				compiled = "\n\nvar OSLfont = map[string]string" + JsonStringify(fontMap) + "\n\n" + compiled
+				if headlessWindow {
+					font += headlessSettings()
+				}
+				compiled = fileDirective("osl_window.go") + generatedDirective() + font + compiled
 			}
-			compiled = "\n" + file + compiled
 
 		default:
 			goImports = append(goImports, importPath)
@@ -258,6 +304,8 @@ func Compile(ast [][]*Token) string {
 			"sort":          true,
 			"unsafe":        true,
 			"sync":          true,
+			"io/fs":         true,
+			"iter":          true,
 		},
 		ImportOrder: []string{
 			"fmt",
@@ -276,10 +324,13 @@ func Compile(ast [][]*Token) string {
 			"sort",
 			"unsafe",
 			"sync",
+			"io/fs",
+			"iter",
 		},
 		ImportAliases: map[string]string{
 			"io":        "OSLio",
 			"math/rand": "OSLrand",
+			"io/fs":     "OSLiofs",
 		},
 		DeclaredVars:        make(map[string]bool),
 		VariableTypes:       make(map[string]string),
@@ -290,17 +341,73 @@ func Compile(ast [][]*Token) string {
 		selfUsed:            false,
 		CustomCommands:      make(map[string]bool),
 		builtinTypeMethods:  make(map[string]map[string]MethodDefinition),
+		packageVars:         make(map[string]string),
 		IsInit:              false,
 		OSLPackagePrefixes:  []string{},
+		SharedVars:          make(map[string]string),
+		TopLevelVars:        make(map[string]string),
+		Constants:           make(map[string]any),
+		Nullable:            make(map[string]string),
+		Narrowed:            make(map[string]bool),
+		warned:              make(map[string]bool),
+		workerHandlers:      make(map[*Token]bool),
+	}
+	if compileOptions.Debug {
+		ctx.SourceFile, _ = filepath.Abs(compileOptions.File)
 	}
 
+	if compileOptions.ProfileDir != "" {
+		ctx.Imports["osl/profile"] = true
+		ctx.ImportOrder = append(ctx.ImportOrder, "osl/profile")
+		ctx.OSLPackagePrefixes = append(ctx.OSLPackagePrefixes, "profile")
+	}
+
+	overloadedOperators = collectOperatorHooks(ast)
+	headlessWindow = compileOptions.Headless || callsWindowMethod(ast, "headless")
+
+	embeds := collectEmbeds(ast)
+	embeddedFiles = nil
+	for _, embed := range embeds {
+		embeddedFiles = append(embeddedFiles, embed.path)
+		if embed.name != "" {
+			ctx.DeclaredVars[embed.name] = true
+			ctx.VariableTypes[embed.name] = "string"
+		}
+	}
+	if len(embeds) > 0 {
+		ctx.Imports["embed"] = true
+	}
+	collectExports(ast, ctx)
+
+	// top level constants are declared before everything else so functions can use them
+	var constsCompiled strings.Builder
+	var rest [][]*Token
+	for _, line := range ast {
+		if len(line) > 0 && line[0].Type == TKN_ASI && line[0].SetType == "const" {
+			constsCompiled.WriteString(compileCommented(line, ctx))
+			continue
+		}
+		// types are compiled with the functions, but code before them can already construct them
+		if len(line) > 2 && line[0].Type == TKN_CMD && line[0].Data == "type" {
+			if name, ok := line[1].Data.(string); ok {
+				oslTypes[name] = "*OSL_" + name
+			}
+		}
+		rest = append(rest, line)
+	}
+	ast = rest
+
 	var init [][]*Token
 	var main [][]*Token
 
 	pivot := -1
+	tickRate := 60.0
 	for i, line := range ast {
-		if line[0].Type == TKN_CMD && line[0].Data == "mainloop:" {
+		if rate, ok := mainloopRate(line); ok {
 			pivot = i
+			if rate > 0 {
+				tickRate = rate
+			}
 			break
 		}
 	}
@@ -316,11 +423,18 @@ func Compile(ast [][]*Token) string {
 		main = make([][]*Token, len(ast)-pivot-1)
 		copy(main, ast[pivot+1:])
 
-		hasDrawingCommands := HasDrawingCommands(append(init, main...))
+		hasDrawingCommands := HasDrawingCommands(slices.Concat(init, main))
 
 		if hasDrawingCommands {
 			ctx.Imports["osl/window"] = true
 			ctx.ImportOrder = append([]string{"osl/window"}, ctx.ImportOrder...)
+			if len(ast[pivot]) > 1 {
+				ctx.Line = ast[pivot][0].Line
+				compileWarning(ctx, "windows draw a frame per screen refresh, the mainloop tick rate is ignored")
+			}
+		} else {
+			ctx.Imports["osl/mainloop"] = true
+			ctx.ImportOrder = append(ctx.ImportOrder, "osl/mainloop")
 		}
 
 		for _, line := range init {
@@ -332,7 +446,7 @@ func Compile(ast [][]*Token) string {
 						continue
 					}
 				}
-				if line[0].Type == TKN_CMD && line[0].Data == "def" {
+				if line[0].Type == TKN_CMD && (line[0].Data == "def" || line[0].Data == "type") {
 					topLevelFuncs = append(topLevelFuncs, line)
 					continue
 				}
@@ -363,9 +477,9 @@ func Compile(ast [][]*Token) string {
 		mainCompiled = CompileBlock(main, ctx)
 
 		if hasDrawingCommands {
-			mainCompiled = funcsCompiled + "\nfunc main() {\n\twindow.Create(OSLsetup)\n}\n\nfunc OSLsetup(window *OSLWindow) {\n" + initCompiled + "\twindow.loop = func(window *OSLWindow) {\n" + AddIndent(mainCompiled, 2) + "\n\t}\n}\n"
+			mainCompiled = funcsCompiled + "\nfunc main() {\n" + mainPrologue(ctx) + "\twindow.Create(OSLsetup)\n}\n\nfunc OSLsetup(window *OSLWindow) {\n" + initCompiled + "\twindow.loop = func(window *OSLWindow) {\n" + AddIndent(mainCompiled, 2) + "\n\t}\n}\n"
 		} else {
-			mainCompiled = funcsCompiled + initCompiled + "func main() {\n" + mainCompiled + "}\n\n"
+			mainCompiled = funcsCompiled + "\nfunc main() {\n" + mainPrologue(ctx) + "\tOSLupdateTimer()\n" + initCompiled + fmt.Sprintf("\tmainloop.run(%v, func() {\n", tickRate) + AddIndent(mainCompiled, 1) + "\n\t})\n}\n\n"
 		}
 	} else {
 		var hasDefMain bool
@@ -373,6 +487,10 @@ func Compile(ast [][]*Token) string {
 
 		for _, line := range ast {
 			if len(line) > 0 {
+				if line[0].Type == TKN_CMD && line[0].Data == "type" {
+					topLevelFuncs = append(topLevelFuncs, line)
+					continue
+				}
 				if line[0].Type == TKN_CMD && line[0].Data == "def" {
 					if len(line) > 1 && line[1].Type == TKN_VAR {
 						if line[1].Data.(string) == "main" {
@@ -405,6 +523,7 @@ func Compile(ast [][]*Token) string {
 			constantAssignments := [][]*Token{}
 			runtimeCode := [][]*Token{}
 
+			nullableGlobals := map[string]bool{}
 			for _, line := range initNoFuncs {
 				if len(line) > 0 {
 					if line[0].Type == TKN_CMD {
@@ -424,7 +543,16 @@ func Compile(ast [][]*Token) string {
 							}
 						}
 
-						if isCompoundAssignment || line[0].SetType != "" {
+						name := ""
+						if line[0].Left != nil {
+							name, _ = line[0].Left.Data.(string)
+						}
+						if _, nullable := nullableBase(line[0].SetType); nullable {
+							// later assignments narrow the variable, so they stay in order
+							nullableGlobals[name] = true
+						}
+
+						if isCompoundAssignment || line[0].SetType != "" || nullableGlobals[name] {
 							runtimeCode = append(runtimeCode, line)
 						} else if isConstantExpression(line[0].Right) {
 							constantAssignments = append(constantAssignments, line)
@@ -525,7 +653,7 @@ func Compile(ast [][]*Token) string {
 			mainBody = mainHoistDecls.String() + mainBody
 		}
 
-		mainCompiled = output + "func main() {\n" + mainBody + "}\n\n"
+		mainCompiled = output + generatedDirective() + "func main() {\n" + mainPrologue(ctx) + mainBody + "}\n\n"
 
 		init = [][]*Token{}
 		main = [][]*Token{}
@@ -569,15 +697,20 @@ func Compile(ast [][]*Token) string {
 			prepend.WriteString("\t" + imp + "\n")
 		}
 		prepend.WriteString(")\n\n")
+		prepend.WriteString(cgoPreamble(ctx))
+		prepend.WriteString(fileDirective("osl_runtime.go"))
 		prepend.WriteString("var OSLwincreatetime float64 = OSLcastNumber(time.Now().UnixMilli())\n")
 		prepend.WriteString("var OSLsystem_os = runtime.GOOS\n")
-		prepend.WriteString("var OSLtimer func() float64 = func() float64 { return float64(time.Now().Unix()) - (OSLwincreatetime / 1000) }\n")
+		prepend.WriteString("var OSLtimer func() float64 = func() float64 { return (OSLcastNumber(time.Now().UnixMilli()) - OSLwincreatetime) / 1000 }\n")
 		prepend.WriteString("var origin = map[string]any{\"version\": \"" + OSL_VERSION + "\", \"isGosl\": true}\n")
 		prepend.WriteString("var OSLtimestamp func() int64 = func() int64 { return time.Now().UnixMilli() }\n")
 		prepend.WriteString("var timer float64\n")
 		prepend.WriteString("var timestamp int64\n")
 		prepend.WriteString("func OSLupdateTimer() {\n\ttimer = OSLtimer()\n\ttimestamp = OSLtimestamp()\n}\n\n")
 		prepend.WriteString(include("packages/std.go"))
+		prepend.WriteString(fileDirective("main.go"))
+		prepend.WriteString(compileEmbeds(embeds))
+		prepend.WriteString(constsCompiled.String() + "\n")
 	}
 
 	var methodsCompiled strings.Builder
@@ -643,11 +776,31 @@ func Compile(ast [][]*Token) string {
 			if params_string != "" {
 				funcSignature += ", " + params_string
 			}
-			fmt.Fprintf(&methodsCompiled, "%v) %v{\n%v}\n\n", funcSignature, returnType, funcBody)
+			fmt.Fprintf(&methodsCompiled, "%v%v) %v{\n%v}\n\n", generatedDirective(), funcSignature, returnType, funcBody)
 		}
 	}
 
-	return prepend.String() + methodsCompiled.String() + importsCompiled + "\n" + mainCompiled
+	return prepend.String() + methodsCompiled.String() + importsCompiled + "\n" + fileDirective("main.go") + mainCompiled + compileExports(ctx)
+}
+
+// mainloopRate reads the line that starts the mainloop, either mainloop: or
+// mainloop <ticks per second>:. The rate is 0 when none is given.
+func mainloopRate(line []*Token) (float64, bool) {
+	if len(line) == 0 || line[0].Type != TKN_CMD {
+		return 0, false
+	}
+	if line[0].Data == "mainloop:" {
+		return 0, true
+	}
+	if line[0].Data != "mainloop" || len(line) != 2 {
+		return 0, false
+	}
+	text := fmt.Sprint(line[1].Data)
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(text, ":"), 64)
+	if !strings.HasSuffix(text, ":") || err != nil || rate <= 0 {
+		panic("Invalid mainloop, expected mainloop: or mainloop <ticks per second>:, got: " + line[0].Source)
+	}
+	return rate, true
 }
 
 func HasDrawingCommands(ast [][]*Token) bool {
@@ -676,7 +829,8 @@ func HasDrawingCommands(ast [][]*Token) bool {
 			}
 		}
 	}
-	return false
+	// a headless window is still a window, even before it draws anything
+	return callsWindowMethod(ast, "headless")
 }
 
 func hasReturnStatement(block [][]*Token) bool {
@@ -713,6 +867,79 @@ func OSLcastToString(expr string, typeHint string) string {
 	return fmt.Sprintf("OSLtoString(%v)", expr)
 }
 
+func compileWarning(ctx *VariableContext, format string, args ...any) {
+	lineInfo := ""
+	if ctx.Line > 0 {
+		lineInfo = fmt.Sprintf("Line %d: ", ctx.Line)
+	}
+	if compileOptions.File != "" {
+		lineInfo = compileOptions.File + ": " + lineInfo
+	}
+	// top level code can be compiled more than once, only warn about it once
+	warning := fmt.Sprintf("Warning: %s%s\n", lineInfo, fmt.Sprintf(format, args...))
+	if ctx.warned[warning] {
+		return
+	}
+	ctx.warned[warning] = true
+	fmt.Fprint(os.Stderr, warning)
+}
+
+// sharedKind reports whether a top level assignment should produce a
+// thread-safe object or array, returning "object", "array" or ""
+func sharedKind(token *Token, ctx *VariableContext) string {
+	if ctx.Indent != 0 || (!token.Shared && !compileOptions.SafeGlobals) {
+		return ""
+	}
+	kind := collectionKind(token)
+	if kind == "" && token.Shared {
+		panic("shared can only be used with objects and arrays")
+	}
+	return kind
+}
+
+// collectionKind is "object" or "array" when an assignment sets its variable to one, or ""
+func collectionKind(token *Token) string {
+	// x ++ and x -- have nothing on the right
+	rightType := ""
+	if token.Right != nil {
+		rightType = token.Right.ReturnedType
+	}
+	switch {
+	case token.SetType == "object", token.SetType == "" && rightType == TYPE_OBJ:
+		return "object"
+	case token.SetType == "array", token.SetType == "" && rightType == TYPE_ARR:
+		return "array"
+	}
+	return ""
+}
+
+func wrapShared(varName string, kind string, compiledRight string, ctx *VariableContext) (goType string, wrapped string) {
+	ctx.SharedVars[varName] = kind
+	if kind == "object" {
+		ctx.VariableTypes[varName] = "*SafeMap[string, any]"
+		return "*SafeMap[string, any]", fmt.Sprintf("NewSafeMap(%v)", compiledRight)
+	}
+	ctx.VariableTypes[varName] = "*SafeSlice[any]"
+	return "*SafeSlice[any]", fmt.Sprintf("NewSafeSlice(%v)", compiledRight)
+}
+
+func warnUnsafeGlobalWrite(varName string, ctx *VariableContext) {
+	if ctx.GoDepth == 0 || ctx.SharedVars[varName] != "" {
+		return
+	}
+	kind, topLevel := ctx.TopLevelVars[varName]
+	if !topLevel && !ctx.GlobalDeclaredVars[varName] {
+		return
+	}
+	// shared only makes objects and arrays thread-safe, anything else needs a lock
+	switch kind {
+	case "object", "array":
+		compileWarning(ctx, "global '%v' is written inside a go block without synchronisation. Declare it with 'shared' or compile with --safe-globals", varName)
+	default:
+		compileWarning(ctx, "global '%v' is written inside a go block without synchronisation. Wrap the writes and reads in lock.Lock(\"%v\") and lock.Unlock(\"%v\") from osl/lock", varName, varName, varName)
+	}
+}
+
 func collectVariableDeclarations(block [][]*Token, ctx *VariableContext) map[string]string {
 	varDecls := make(map[string]string)
 	savedDeclaredVars := make(map[string]bool)
@@ -793,7 +1020,7 @@ func CompileBlock(block [][]*Token, ctx *VariableContext) string {
 	var out strings.Builder
 
 	for _, line := range block {
-		out.WriteString(AddIndent(CompileLine(line, ctx), ctx.Indent*2))
+		out.WriteString(AddIndent(compileCommented(line, ctx), ctx.Indent*2))
 	}
 
 	return out.String()
@@ -874,6 +1101,57 @@ func CompileModifier(mod *Token, ctx *VariableContext) string {
 	return CompileCmd(cmdTokens, ctx)
 }
 
+// functionReturnType works out the Go return type of a def() -> ( ... ) function,
+// which is empty when the function never returns a value
+func functionReturnType(token *Token) string {
+	if token.Returns == "null" {
+		// Explicit null return type means no return
+		return ""
+	}
+	if len(token.Parameters) <= 1 {
+		return "any"
+	}
+	blk := token.Parameters[1]
+	blkData, ok := blk.Data.([][]*Token)
+	if blk != nil && ok && containsYield(blkData) {
+		return "iter.Seq[any]"
+	}
+	if token.Returns != "" {
+		return mapOSLTypeToGo(token.Returns)
+	}
+	if blk == nil || blk.Type != TKN_BLK || !ok {
+		return "any"
+	}
+	for _, line := range blkData {
+		if len(line) > 0 && line[0].Type == TKN_CMD && line[0].Data == "return" {
+			return "any"
+		}
+	}
+	// No explicit return, so don't add a return type
+	return ""
+}
+
+// castFromAny converts an expression holding an any to the given Go type
+func castFromAny(expr string, goType string) string {
+	switch goType {
+	case "any", "":
+		return expr
+	case "string":
+		return fmt.Sprintf("OSLtoString(%v)", expr)
+	case "int":
+		return fmt.Sprintf("OSLcastInt(%v)", expr)
+	case "float64":
+		return fmt.Sprintf("OSLcastNumber(%v)", expr)
+	case "bool":
+		return fmt.Sprintf("OSLcastBool(%v)", expr)
+	case "[]any":
+		return fmt.Sprintf("OSLcastArray(%v)", expr)
+	case "map[string]any":
+		return fmt.Sprintf("OSLcastObject(%v)", expr)
+	}
+	return fmt.Sprintf("%v.(%v)", expr, goType)
+}
+
 func CompileLine(line []*Token, ctx *VariableContext) string {
 	var out string
 
@@ -881,12 +1159,17 @@ func CompileLine(line []*Token, ctx *VariableContext) string {
 		if r := recover(); r != nil {
 			lineInfo := ""
 			if len(line) > 0 && line[0].Line > 0 {
-				lineInfo = fmt.Sprintf("Line %d: ", line[0].Line)
+				lineInfo = line[0].position() + ": "
 			}
 			panic(fmt.Sprintf("%s%v", lineInfo, r))
 		}
 	}()
 
+	if len(line) > 0 && line[0].Line > 0 {
+		ctx.Line = line[0].Line
+		out = lineDirective(ctx, line[0])
+	}
+
 	var modifiers []*Token
 	var mainLine []*Token
 
@@ -925,7 +1208,7 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 		if r := recover(); r != nil {
 			lineInfo := ""
 			if token.Line > 0 {
-				lineInfo = fmt.Sprintf("Line %d: ", token.Line)
+				lineInfo = token.position() + ": "
 			}
 			tokenInfo := ""
 			if token.Source != "" {
@@ -937,6 +1220,9 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 
 	switch token.Type {
 	case TKN_ASI:
+		if token.SetType == "const" {
+			return compileConst(token, ctx)
+		}
 		if token.Right != nil && token.Left != nil &&
 			token.Right.Type == TKN_FNC && token.Left.Type == TKN_VAR &&
 			token.Right.Data == "function" && ctx.Indent == 0 {
@@ -968,7 +1254,11 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 							// First part is type, last part is variable name
 							typePart := strings.Join(parts[:len(parts)-1], " ")
 							varName = parts[len(parts)-1]
-							if _, isType := oslTypes[typePart]; isType {
+							if base, nullable := nullableBase(typePart); nullable {
+								typeName = mapOSLTypeToGo(typePart)
+								ctx.Nullable[varName] = base
+								delete(ctx.Narrowed, varName)
+							} else if _, isType := oslTypes[typePart]; isType {
 								typeName = mapOSLTypeToGo(typePart)
 							} else if typePart != "" {
 								typeName = stripOSLPackagePrefix(typePart, ctx)
@@ -995,9 +1285,23 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 				}
 			}
 
+			generator := containsYield(blockData)
+			if generator {
+				returns = "iter.Seq[any] "
+			}
+			savedReturnType := ctx.ReturnType
+			ctx.ReturnType = strings.TrimSpace(returns)
+
 			funcBody := ""
 			if blockData != nil {
-				funcBody = CompileBlock(blockData, ctx)
+				savedGenerator := ctx.InGenerator
+				if generator {
+					funcBody = compileGenerator(blockData, ctx)
+				} else {
+					ctx.InGenerator = false
+					funcBody = CompileBlock(blockData, ctx)
+				}
+				ctx.InGenerator = savedGenerator
 				if ctx.selfUsed {
 					params_string = "OSLself any, " + params_string
 					ctx.selfUsed = false
@@ -1023,11 +1327,12 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 				returns = "any "
 			}
 
+			funcName := exportedName(token.Left.Data.(string), ctx)
 			var funcSignature string
 			if returns != "" {
-				funcSignature = "func " + token.Left.Data.(string) + "(" + params_string + ") " + returns + "{\n"
+				funcSignature = "func " + funcName + "(" + params_string + ") " + returns + "{\n"
 			} else {
-				funcSignature = "func " + token.Left.Data.(string) + "(" + params_string + ") {\n"
+				funcSignature = "func " + funcName + "(" + params_string + ") {\n"
 			}
 			out := funcSignature + hoistDecls.String() + funcBody
 
@@ -1037,6 +1342,7 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 
 			out += "}"
 
+			ctx.ReturnType = savedReturnType
 			ctx.DeclaredVars = savedDeclaredVars
 			ctx.HoistedVars = savedHoistedVars
 			ctx.Indent--
@@ -1087,9 +1393,17 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 				varName = leftData
 			}
 		}
+		if _, isConst := ctx.Constants[varName]; isConst {
+			panic("Cannot assign to constant " + varName)
+		}
 		if token.Left.Type == TKN_RMT {
 			var objPath string
 			path := token.Left.ObjPath
+			if len(path) > 0 && path[0].Type == TKN_VAR {
+				if name, ok := path[0].Data.(string); ok {
+					warnUnsafeGlobalWrite(name, ctx)
+				}
+			}
 			if len(path) > 0 {
 				tkn := &Token{
 					Type: TKN_MTD,
@@ -1144,6 +1458,18 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 		}
 
 		if varName != "" {
+			warnUnsafeGlobalWrite(varName, ctx)
+			if ctx.Indent == 0 {
+				ctx.TopLevelVars[varName] = collectionKind(token)
+			}
+
+			if kind := ctx.SharedVars[varName]; kind != "" && op == "=" && token.SetType == "" {
+				if kind == "object" {
+					return fmt.Sprintf("%v.Store(OSLcastObject(%v))", varName, compiledRight)
+				}
+				return fmt.Sprintf("%v.Store(OSLcastArray(%v))", varName, compiledRight)
+			}
+
 			shouldHoist := ctx.Indent > 0 &&
 				!contains(ctx.HoistedVars, varName) &&
 				token.SetType != "auto" &&
@@ -1158,11 +1484,16 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 			if !declared {
 				ctx.DeclaredVars[varName] = true
 			}
+			shared := ""
+			if op == "=" && !ctx.GlobalDeclaredVars[varName] {
+				shared = sharedKind(token, ctx)
+			}
 			if token.SetType != "" {
 				tokenType := token.SetType
 				goType := mapOSLTypeToGo(tokenType)
 
 				ctx.VariableTypes[varName] = goType
+				declareNullable(varName, tokenType, token.Right, ctx)
 
 				switch tokenType {
 				case "string":
@@ -1198,6 +1529,12 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 						compiledRight = fmt.Sprintf("OSLcastObject(%v)", compiledRight)
 					}
 				}
+				if shared != "" {
+					goType, compiledRight = wrapShared(varName, shared, compiledRight, ctx)
+					if !ctx.IsInit {
+						return fmt.Sprintf("var %v %v = %v", varName, goType, compiledRight)
+					}
+				}
 				if ctx.IsInit && ctx.Indent == 0 && op == "=" {
 					fmt.Fprintf(&ctx.GlobalVars, "var %v %v = %v\n", varName, goType, compiledRight)
 					ctx.GlobalDeclaredVars[varName] = true
@@ -1209,8 +1546,19 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					}
 					return fmt.Sprintf("var %v = %v", varName, compiledRight)
 				}
+				if !declared && !ctx.GlobalDeclaredVars[varName] && !contains(ctx.HoistedVars, varName) {
+					return fmt.Sprintf("var %v %v = %v", varName, goType, compiledRight)
+				}
 				return fmt.Sprintf("%v = %v", varName, compiledRight)
 			}
+			if _, nullable := ctx.Nullable[varName]; nullable && op == "=" {
+				// assigning a literal proves the variable is not null, anything else might be
+				if isLiteralValue(token.Right) {
+					ctx.Narrowed[varName] = true
+				} else {
+					delete(ctx.Narrowed, varName)
+				}
+			}
 			if contains(ctx.HoistedVars, varName) && op == ":=" {
 				op = "="
 			}
@@ -1258,10 +1606,20 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					varOut = fmt.Sprintf("%v = %v", varName, compiledRight)
 				} else {
 					varOut = fmt.Sprintf("var %v = %v", varName, compiledRight)
+					if compiledRight == "nil" {
+						// null has no type of its own
+						varOut = fmt.Sprintf("var %v any = nil", varName)
+					}
 					ctx.DeclaredVars[varName] = true
 				}
 			} else if op == "=" && !declared && !ctx.GlobalDeclaredVars[varName] && token.SetType == "" && !contains(ctx.HoistedVars, varName) {
+				if shared != "" {
+					_, compiledRight = wrapShared(varName, shared, compiledRight, ctx)
+				}
 				varOut = fmt.Sprintf("var %v = %v", varName, compiledRight)
+				if compiledRight == "nil" {
+					varOut = fmt.Sprintf("var %v any = nil", varName)
+				}
 				if ctx.IsInit && ctx.Indent == 0 {
 					ctx.GlobalVars.WriteString(varOut + "\n")
 					ctx.GlobalDeclaredVars[varName] = true
@@ -1307,6 +1665,15 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 		LT := token.Left.ReturnedType
 		RT := token.Right.ReturnedType
 
+		if token.Data != "??" {
+			checkNullableUse(token.Left, ctx)
+			checkNullableUse(token.Right, ctx)
+		}
+
+		if op, ok := token.Data.(string); ok && overloadedOperators[op] && mayBeUserType(LT) {
+			return fmt.Sprintf("OSLoperator(%q, %v, %v)", op, compiledLeft, compiledRight)
+		}
+
 		switch token.Data {
 		case "??":
 			return fmt.Sprintf("OSLnullishCoaless(%v, %v)", compiledLeft, compiledRight)
@@ -1334,10 +1701,17 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 			}
 			return fmt.Sprintf("OSLsub(%v, %v)", compiledLeft, compiledRight)
 		case "*":
+			// untyped values, like the parameters of an untyped def, are only known at run time
+			if LT == "" || RT == "" {
+				return fmt.Sprintf("OSLoperator(\"*\", %v, %v)", compiledLeft, compiledRight)
+			}
 			return fmt.Sprintf("OSLmultiply(%v, %v)", compiledLeft, compiledRight)
 		case "/":
 			return fmt.Sprintf("OSLdivide(%v, %v)", compiledLeft, compiledRight)
 		case "%":
+			if LT == "" || RT == "" {
+				return fmt.Sprintf("OSLoperator(\"%%\", %v, %v)", compiledLeft, compiledRight)
+			}
 			return fmt.Sprintf("OSLmod(%v, %v)", compiledLeft, compiledRight)
 		case "^":
 			token.ReturnedType = TYPE_NUM
@@ -1349,7 +1723,7 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 			}
 			return fmt.Sprintf("OSLconcat(%v, %v)", compiledLeft, compiledRight)
 		case "to":
-			return fmt.Sprintf("OSLrangeBetween(%v, %v)", compiledLeft, compiledRight)
+			return fmt.Sprintf("OSLnewRange(%v, %v)", compiledLeft, compiledRight)
 		}
 		return fmt.Sprintf("%v %v %v", compiledLeft, token.Data, compiledRight)
 	case TKN_EVL:
@@ -1367,12 +1741,25 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 		case "!==":
 			return fmt.Sprintf("%v != %v", compiledLeft, compiledRight)
 		case ">", "<", "<=", ">=":
+			if overloadedOperators[token.Data.(string)] && (mayBeUserType(token.Left.ReturnedType) || mayBeUserType(token.Right.ReturnedType)) {
+				return fmt.Sprintf("OSLcompare(%q, %v, %v)", token.Data, compiledLeft, compiledRight)
+			}
 			return fmt.Sprintf("OSLcastNumber(%v) %v OSLcastNumber(%v)", compiledLeft, token.Data, compiledRight)
 		}
 		return fmt.Sprintf("%v %v %v", compiledLeft, token.Data, compiledRight)
 	case TKN_LOG:
 		left := CompileToken(token.Left, ctx)
+		// the right side only runs when the left side let it, so it sees the left side's null checks
+		leftTrue, leftFalse := nullChecks(token.Left)
+		restore := func() {}
+		switch token.Data {
+		case "and":
+			restore = narrow(leftTrue, ctx)
+		case "or":
+			restore = narrow(leftFalse, ctx)
+		}
 		right := CompileToken(token.Right, ctx)
+		restore()
 		op := ""
 		token.ReturnedType = TYPE_BOOL
 		switch token.Data {
@@ -1436,7 +1823,13 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 			token.ReturnedType = TYPE_NUM
 			return "float64(time.Now().UnixMicro())"
 		}
-		return varName
+		if goVar, ok := ctx.packageVars[varName]; ok {
+			return goVar
+		}
+		if token.NonNull {
+			return fmt.Sprintf("OSLnonNull(%v, %q)", exportedName(varName, ctx), varName)
+		}
+		return exportedName(varName, ctx)
 	case TKN_RAW:
 		switch v := token.Data.(type) {
 		case bool:
@@ -1569,7 +1962,11 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 								// First part is type, last part is variable name
 								typePart := strings.Join(parts[:len(parts)-1], " ")
 								varName = parts[len(parts)-1]
-								if _, isType := oslTypes[typePart]; isType {
+								if base, nullable := nullableBase(typePart); nullable {
+									typeName = mapOSLTypeToGo(typePart)
+									ctx.Nullable[varName] = base
+									delete(ctx.Narrowed, varName)
+								} else if _, isType := oslTypes[typePart]; isType {
 									typeName = mapOSLTypeToGo(typePart)
 								} else if typePart != "" {
 									// External type like *gin.Context
@@ -1585,54 +1982,39 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 				}
 			}
 
-			returns := mapOSLTypeToGo(token.Returns)
-			if token.Returns == "null" {
-				// Explicit null return type means no return
-				returns = ""
-			} else if len(params) > 1 && token.Returns == "" {
-				// Check if the block contains only statements (no return)
-				blk := params[1]
-				if blk != nil && blk.Type == TKN_BLK {
-					if blkData, ok := blk.Data.([][]*Token); ok {
-						// Check if any statement is an explicit return
-						hasExplicitReturn := false
-						for _, line := range blkData {
-							if len(line) > 0 && line[0].Type == TKN_CMD && line[0].Data == "return" {
-								hasExplicitReturn = true
-								break
-							}
-						}
-						if !hasExplicitReturn {
-							// No explicit return, so don't add a return type
-							returns = ""
-						} else {
-							returns = "any"
-						}
-					} else {
-						returns = "any"
-					}
-				} else {
-					returns = "any"
-				}
-			} else if len(params) <= 1 {
-				returns = "any"
+			workerHandler := ctx.workerHandlers[token]
+			selfParam := ""
+			if workerHandler {
+				selfParam = "OSLself *OSLWorker, "
 			}
+
+			returns := functionReturnType(token)
 			var funcSig string
 			if returns != "" {
-				funcSig = fmt.Sprintf("(func(%v) %v{\n", strings.TrimSuffix(paramString.String(), ", "), returns)
+				funcSig = fmt.Sprintf("(func(%v) %v{\n", strings.TrimSuffix(selfParam+paramString.String(), ", "), returns)
 			} else {
-				funcSig = fmt.Sprintf("(func(%v) {\n", strings.TrimSuffix(paramString.String(), ", "))
+				funcSig = fmt.Sprintf("(func(%v) {\n", strings.TrimSuffix(selfParam+paramString.String(), ", "))
 			}
 			out := funcSig
+			savedReturnType := ctx.ReturnType
+			ctx.ReturnType = strings.TrimSpace(returns)
 
 			if len(params) > 1 {
 				blk := params[1]
 				if blk != nil {
-					inner := CompileBlock(blk.Data.([][]*Token), ctx)
-					if ctx.selfUsed {
+					savedGenerator := ctx.InGenerator
+					var inner string
+					if returns == "iter.Seq[any]" {
+						inner = compileGenerator(blk.Data.([][]*Token), ctx)
+					} else {
+						ctx.InGenerator = false
+						inner = CompileBlock(blk.Data.([][]*Token), ctx)
+					}
+					ctx.InGenerator = savedGenerator
+					if ctx.selfUsed && !workerHandler {
 						inner = AddIndent("OSLself := OSLself\n", ctx.Indent*2) + inner
-						ctx.selfUsed = false
 					}
+					ctx.selfUsed = false
 
 					hasReturn := hasReturnStatement(blk.Data.([][]*Token))
 					if returns == "any " && !hasReturn {
@@ -1643,15 +2025,23 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 			}
 			out += AddIndent("})", ctx.Indent*2-2)
 
+			ctx.ReturnType = savedReturnType
 			ctx.DeclaredVars = savedDeclaredVars
 			ctx.Indent--
 			ctx.ScopeLevel--
 			return out
 		case "worker":
 			if len(token.Parameters) > 0 {
-				return fmt.Sprintf("OSLworker(%v)", CompileToken(token.Parameters[0], ctx))
+				markWorkerHandlers(token.Parameters[0], ctx)
+				return fmt.Sprintf("OSLworker(OSLcastObject(%v))", CompileToken(token.Parameters[0], ctx))
 			}
 			panic("worker osl function needs 1 parameter")
+		case "workerPool":
+			if len(token.Parameters) > 1 {
+				markWorkerHandlers(token.Parameters[1], ctx)
+				return fmt.Sprintf("OSLworkerPool(OSLcastInt(%v), OSLcastObject(%v))", CompileToken(token.Parameters[0], ctx), CompileToken(token.Parameters[1], ctx))
+			}
+			panic("workerPool osl function needs 2 parameters, the number of workers and the worker object")
 		case "typeof":
 			if len(token.Parameters) > 0 {
 				token.ReturnedType = TYPE_STR
@@ -1720,7 +2110,11 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					token.ReturnedType = TYPE_ARR
 					return "OSLcastArray(" + CompileToken(params[0], ctx) + ")"
 				default:
-					return "OSL_new_" + nameStr + "()"
+					args := make([]string, len(params))
+					for i, p := range params {
+						args[i] = CompileToken(p, ctx)
+					}
+					return "OSL_new_" + nameStr + "(" + strings.Join(args, ", ") + ")"
 				}
 			}
 			var paramString strings.Builder
@@ -1736,7 +2130,7 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 			if ok {
 				token.ReturnedType = functionReturnType.Returns
 			}
-			return fmt.Sprintf("%v(%v)", token.Data, paramString.String())
+			return fmt.Sprintf("%v(%v)", exportedName(token.Data.(string), ctx), paramString.String())
 		}
 	case TKN_URY:
 		op := token.Data
@@ -1772,10 +2166,55 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 				panic("Cannot use reserved variable name: " + first.Data.(string))
 			}
 		}
+		if len(parts) > 1 && !parts[1].Optional {
+			checkNullableUse(first, ctx)
+		}
 		out = CompileToken(first, ctx)
+		if name, ok := first.Data.(string); ok && first.Type == TKN_VAR {
+			// a nullable value uses the methods of the type it wraps, unchecked uses
+			// have already been warned about and fail with a clear error on null
+			if base, nullable := ctx.Nullable[name]; nullable && oslTypes[base] != "" && !isNilable(oslTypes[base]) {
+				first.ReturnedType = base
+				if len(parts) > 1 && !parts[1].Optional {
+					if !ctx.Narrowed[name] && !first.NonNull {
+						out = fmt.Sprintf("OSLnonNull(%v, %q)", out, name)
+					}
+					out = castFromAny(out, oslTypes[base])
+				}
+			}
+		}
 		previous := first
+		sharedArray := false
+		// window.pop() restores drawing state rather than removing an array item
+		windowMethod := false
+		if name, ok := first.Data.(string); ok && first.Type == TKN_VAR {
+			sharedArray = ctx.SharedVars[name] == "array"
+			windowMethod = name == "window" && ctx.Imports["osl/window"]
+			if len(parts) > 1 && parts[1].Type == TKN_MTV && !windowMethod {
+				switch parts[1].Data {
+				case "append", "prepend", "pop", "shift", "delete":
+					warnUnsafeGlobalWrite(name, ctx)
+				}
+			}
+		}
 		parts = parts[1:]
-		for _, part := range parts {
+		// a ?. before a method call skips the rest of the chain when the value is null,
+		// property access is already null safe
+		var optionalBases []string
+		for i, part := range parts {
+			if part.Optional && slices.ContainsFunc(parts[i:], func(p *Token) bool { return p.Type == TKN_MTV }) {
+				optionalBases = append(optionalBases, out)
+				out = fmt.Sprintf("OSLvalue%d", len(optionalBases))
+				known := previous.ReturnedType
+				previous = &Token{Type: TKN_UNK}
+				if goType := oslTypes[known]; goType != "" && !isNilable(goType) {
+					out = castFromAny(out, goType)
+					previous.ReturnedType = known
+				}
+			}
+			if i > 0 && parts[i-1].NonNull {
+				out = fmt.Sprintf("OSLnonNull(%v, %q)", out, parts[i-1].Data)
+			}
 			name := part.Data.(string)
 			switch part.Type {
 			case TKN_VAR:
@@ -1863,6 +2302,14 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					}
 				}
 
+				// methods of an imported package are its own Go methods, not the builtins
+				// that share their names, like math.abs
+				if i == 0 && first.Type == TKN_VAR && contains(ctx.OSLPackagePrefixes, first.Data.(string)) {
+					out = fmt.Sprintf("%v.%v(%v)", out, packageMethod(name), strings.Join(params, ", "))
+					previous = part
+					continue
+				}
+
 				switch name {
 				case "call":
 					var param_list strings.Builder
@@ -1892,22 +2339,36 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					out = fmt.Sprintf("OSLcastObject(%v)", out)
 				case "pop":
 					part.ReturnedType = TYPE_UNK
-					out = fmt.Sprintf("OSLpop(&(%v))", out)
+					if windowMethod && previous == first {
+						out = fmt.Sprintf("%v.pop()", out)
+					} else if sharedArray && previous == first {
+						out = fmt.Sprintf("%v.Pop()", out)
+					} else {
+						out = fmt.Sprintf("OSLpop(&(%v))", out)
+					}
 				case "shift":
 					part.ReturnedType = TYPE_UNK
-					out = fmt.Sprintf("OSLshift(&(%v))", out)
+					if sharedArray && previous == first {
+						out = fmt.Sprintf("%v.Shift()", out)
+					} else {
+						out = fmt.Sprintf("OSLshift(&(%v))", out)
+					}
 				case "to":
 					if len(params) > 1 {
 						part.ReturnedType = TYPE_ARR
 						out = fmt.Sprintf("OSLto(%v, %v)", out, params[0])
 					}
 				case "append":
-					if len(params) > 0 {
+					if len(params) > 0 && sharedArray && previous == first {
+						out = fmt.Sprintf("%v.Append(%v)", out, params[0])
+					} else if len(params) > 0 {
 						part.ReturnedType = TYPE_ARR
 						out = fmt.Sprintf("OSLappend(&(%v), %v)", out, params[0])
 					}
 				case "prepend":
-					if len(params) > 0 {
+					if len(params) > 0 && sharedArray && previous == first {
+						out = fmt.Sprintf("%v.Prepend(%v)", out, params[0])
+					} else if len(params) > 0 {
 						part.ReturnedType = TYPE_ARR
 						out = fmt.Sprintf("OSLprepend(&(%v), %v)", out, params[0])
 					}
@@ -1933,7 +2394,7 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					out = fmt.Sprintf("strings.ToUpper(%v)", out)
 				case "onKeyDown":
 					part.ReturnedType = TYPE_BOOL
-					out = "false"
+					out = fmt.Sprintf("window.KeyJustPressed(%v)", out)
 				case "isKeyDown":
 					part.ReturnedType = TYPE_BOOL
 					out = fmt.Sprintf("window.KeyPressed(%v)", out)
@@ -1989,6 +2450,9 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					if len(params) > 0 {
 						part.ReturnedType = TYPE_STR
 						out = fmt.Sprintf("OSLarrayJoin(%v, %v)", out, params[0])
+					} else if previous.ReturnedType != TYPE_ARR && previous.ReturnedType != TYPE_STR {
+						// join() with nothing to join with waits for a worker or a pool
+						out = fmt.Sprintf("OSLjoinWorker(%v)", out)
 					}
 				case "split":
 					if len(params) > 0 {
@@ -2091,16 +2555,25 @@ func CompileToken(token *Token, ctx *VariableContext) string {
 					part.ReturnedType = TYPE_NUM
 					out = fmt.Sprintf("math.Sqrt(OSLcastNumber(%v))", out)
 				default:
+					// values from packages, like a cache from cache.create, keep keyword methods as OSLmap too
 					if len(part.Parameters) == 0 {
-						out = fmt.Sprintf("%v.%v()", out, name)
+						out = fmt.Sprintf("%v.%v()", out, packageMethod(name))
 						break
 					}
-					out = fmt.Sprintf("%v.%v(%v)", out, name, strings.Join(params, ", "))
+					out = fmt.Sprintf("%v.%v(%v)", out, packageMethod(name), strings.Join(params, ", "))
 				}
 			}
 			previous = part
 		}
 		token.ReturnedType = previous.ReturnedType
+		if len(parts) > 0 && parts[len(parts)-1].NonNull {
+			last := parts[len(parts)-1]
+			out = fmt.Sprintf("OSLnonNull(%v, %q)", out, last.Data)
+		}
+		for i := len(optionalBases); i > 0; i-- {
+			token.ReturnedType = ""
+			out = fmt.Sprintf("OSLoptional(%v, func(OSLvalue%d any) any {\n%vreturn %v\n%v})", optionalBases[i-1], i, strings.Repeat("\t", ctx.Indent*2+2), out, strings.Repeat("\t", ctx.Indent*2))
+		}
 		return out
 	case TKN_QST:
 		if token.Left == nil || token.Right == nil || token.Right2 == nil {
@@ -2143,11 +2616,18 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 			condition = fmt.Sprintf("OSLcastBool(%v)", condition)
 		}
 
+		checkedTrue, checkedFalse := nullChecks(cmd[1])
 		out += fmt.Sprintf("if %v {\n", condition)
 		ctx.Indent++
+		restore := narrow(checkedTrue, ctx)
 		out += CompileBlock(blk.Data.([][]*Token), ctx)
+		restore()
 		ctx.Indent--
 		out += AddIndent("}", ctx.Indent*2)
+		if len(cmd) == 3 && blockExits(blk.Data.([][]*Token)) {
+			// if x == null ( return ) leaves x checked for the rest of the block
+			narrow(checkedFalse, ctx)
+		}
 
 		i := 3
 		for i < len(cmd) {
@@ -2173,9 +2653,14 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 				}
 				out += " else {\n"
 				ctx.Indent++
+				restore := func() {}
+				if i == 3 {
+					restore = narrow(checkedFalse, ctx)
+				}
 				if i+1 < len(cmd) {
 					out += CompileBlock(cmd[i+1].Data.([][]*Token), ctx)
 				}
+				restore()
 				ctx.Indent--
 				out += AddIndent("}", ctx.Indent*2)
 				i += 2
@@ -2189,10 +2674,38 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 			panic("Loop command requires at least 1 parameter")
 		}
 
-		if len(cmd) >= 5 && cmd[1].Type == TKN_VAR && cmd[2].Type == TKN_VAR {
+		// each item iterable ( ... ) leaves out the index
+		withoutIndex := len(cmd) == 4 && cmd[1].Type == TKN_VAR && cmd[3].Type == TKN_BLK
+		if withoutIndex || (len(cmd) >= 5 && cmd[1].Type == TKN_VAR && cmd[2].Type == TKN_VAR) {
+			if withoutIndex {
+				cmd = []*Token{cmd[0], {Type: TKN_VAR, Data: "_"}, cmd[1], cmd[2], cmd[3]}
+			}
 			indexVar := cmd[1].Data.(string)
 			itemVar := cmd[2].Data.(string)
-			array := CompileToken(cmd[3], ctx)
+			isRange := cmd[3].Type == TKN_OPR && cmd[3].Data == "to"
+			var array string
+			if isRange {
+				array = fmt.Sprintf("OSLrange(%v, %v)", CompileToken(cmd[3].Left, ctx), CompileToken(cmd[3].Right, ctx))
+			} else {
+				array = CompileToken(cmd[3], ctx)
+			}
+			isSlice := cmd[3].ReturnedType == TYPE_ARR
+			if name, ok := cmd[3].Data.(string); ok && cmd[3].Type == TKN_VAR {
+				switch ctx.SharedVars[name] {
+				case "array":
+					array += ".Values()"
+					isSlice = true
+				case "object":
+					array += ".Snapshot()"
+				}
+				if ctx.VariableTypes[name] == "[]any" {
+					isSlice = true
+				}
+			}
+			if !isSlice && !isRange {
+				// generators, objects and strings are iterated without building an array
+				array = fmt.Sprintf("OSLiterate(%v)", array)
+			}
 			blk := cmd[4]
 			var blockData [][]*Token
 			if blkData, ok := blk.Data.([][]*Token); ok {
@@ -2202,15 +2715,39 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 			} else if singleToken, ok := blk.Data.([]*Token); ok {
 				blockData = append(blockData, singleToken)
 			}
+			// the loop variables only exist inside the loop, like they do in go
+			savedVars := map[string]bool{indexVar: ctx.DeclaredVars[indexVar], itemVar: ctx.DeclaredVars[itemVar]}
+			savedTypes := map[string]string{indexVar: ctx.VariableTypes[indexVar], itemVar: ctx.VariableTypes[itemVar]}
 			ctx.DeclaredVars[indexVar] = true
 			ctx.DeclaredVars[itemVar] = true
 			ctx.VariableTypes[indexVar] = "int"
 			ctx.VariableTypes[itemVar] = "any"
 			ctx.Indent++
-			out += fmt.Sprintf("for _%v_idx, %v := range %v {\n\t%v := _%v_idx + 1\n", indexVar, itemVar, array, indexVar, indexVar)
+			if isSlice && indexVar == "_" {
+				out += fmt.Sprintf("for _, %v := range %v {\n", itemVar, array)
+			} else if isSlice {
+				out += fmt.Sprintf("for _%v_idx, %v := range %v {\n", indexVar, itemVar, array)
+				out += AddIndent(fmt.Sprintf("%v := _%v_idx + 1\n", indexVar, indexVar), ctx.Indent*2)
+			} else {
+				out += fmt.Sprintf("for %v, %v := range %v {\n", indexVar, itemVar, array)
+			}
+			// the loop variables do not have to be used
+			if indexVar != "_" {
+				out += AddIndent(fmt.Sprintf("_ = %v\n", indexVar), ctx.Indent*2)
+			}
+			out += AddIndent(fmt.Sprintf("_ = %v\n", itemVar), ctx.Indent*2)
 			out += CompileBlock(blockData, ctx)
 			ctx.Indent--
 			out += AddIndent("}", ctx.Indent*2)
+			for name, declared := range savedVars {
+				if declared {
+					ctx.DeclaredVars[name] = true
+					ctx.VariableTypes[name] = savedTypes[name]
+				} else {
+					delete(ctx.DeclaredVars, name)
+					delete(ctx.VariableTypes, name)
+				}
+			}
 		} else {
 			var iteratorVar string = "i_" + RandomString(5)
 			loopNumber := CompileToken(cmd[1], ctx)
@@ -2439,8 +2976,11 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 								paramParts = append(paramParts, part+" any")
 							}
 						}
-						returnType := mapOSLTypeToGo(val.Right.Returns)
+						returnType := functionReturnType(val.Right)
 						val.SetType = "func(" + strings.Join(paramParts, ", ") + ") " + returnType
+						if varName == "init" {
+							initParams = paramParts
+						}
 					} else {
 						defaults[varName] = val.Right
 					}
@@ -2469,9 +3009,22 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 			ctx.selfTypes = selfTypes
 			ctx.Indent--
 			out += "}\n"
+			if _, ok := inlines["__str"]; ok {
+				// arrays and objects are logged as json, so instances inside them use __str too
+				out += "func (OSLself *OSL_" + name + ") MarshalJSON() ([]byte, error) {\n"
+				out += AddIndent("return json.Marshal(OSLtoString(OSLself))\n", 2)
+				out += "}\n"
+			}
 
 			if len(initParams) > 0 {
-				out += fmt.Sprintf("func OSL_new_%v(", name) + strings.Join(initParams, ", ") + ") *OSL_" + name + " {\n"
+				// constructors take any so values of unknown type can be passed, init gets them cast
+				anyParams := make([]string, 0, len(initParams))
+				for _, param := range initParams {
+					if paramStr := strings.Fields(param); len(paramStr) > 0 {
+						anyParams = append(anyParams, paramStr[0]+" any")
+					}
+				}
+				out += fmt.Sprintf("func OSL_new_%v(", name) + strings.Join(anyParams, ", ") + ") *OSL_" + name + " {\n"
 			} else {
 				out += "func OSL_new_" + name + "() *OSL_" + name + " {\n"
 			}
@@ -2491,7 +3044,11 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 				for i, paramName := range initParams {
 					paramStr := strings.Fields(strings.TrimSpace(paramName))
 					if len(paramStr) > 0 {
-						out += paramStr[0]
+						if len(paramStr) > 1 {
+							out += castFromAny(paramStr[0], paramStr[1])
+						} else {
+							out += paramStr[0]
+						}
 						if i < len(initParams)-1 {
 							out += ", "
 						}
@@ -2508,9 +3065,22 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 			out += "return"
 			break
 		}
+		if ctx.InGenerator {
+			panic("Generators cannot return a value, yield it instead")
+		}
 		if len(cmd) > 1 {
-			out += fmt.Sprintf("return %v", CompileToken(cmd[1], ctx))
+			value := CompileToken(cmd[1], ctx)
+			// typed functions return what they say, even from an untyped expression
+			switch ctx.ReturnType {
+			case "string", "int", "float64", "bool", "[]any", "map[string]any":
+				if mapOSLTypeToGo(cmd[1].ReturnedType) != ctx.ReturnType {
+					value = castFromAny(value, ctx.ReturnType)
+				}
+			}
+			out += fmt.Sprintf("return %v", value)
 		}
+	case "yield":
+		out += compileYield(cmd, ctx)
 	case "wait":
 		if len(cmd) == 2 {
 			out += "OSLwait(" + CompileToken(cmd[1], ctx) + ")"
@@ -2612,15 +3182,25 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 			if cmdBody.Type == TKN_BLK {
 				if blk, ok := cmdBody.Data.([][]*Token); ok {
 					blockData = blk
-					funcBody = CompileBlock(blk, ctx)
 				}
 			}
 		} else if len(cmd) > 2 && cmd[2].Type == TKN_BLK {
 			if blk, ok := cmd[2].Data.([][]*Token); ok {
 				blockData = blk
-				funcBody = CompileBlock(blk, ctx)
 			}
 		}
+		generator := containsYield(blockData)
+		savedGenerator := ctx.InGenerator
+		savedReturnType := ctx.ReturnType
+		ctx.ReturnType = ""
+		if generator {
+			funcBody = compileGenerator(blockData, ctx)
+		} else if blockData != nil {
+			ctx.InGenerator = false
+			funcBody = CompileBlock(blockData, ctx)
+		}
+		ctx.InGenerator = savedGenerator
+		ctx.ReturnType = savedReturnType
 
 		var hoistDecls string
 		if len(ctx.HoistedVars) > 0 {
@@ -2637,11 +3217,13 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 
 		hasReturn := hasReturnStatement(blockData)
 		funcResult := ""
-		if hasReturn {
+		if generator {
+			funcResult = "iter.Seq[any]"
+		} else if hasReturn {
 			funcResult = "any"
 		}
 
-		if hasReturn && !strings.Contains(funcBody, "return") {
+		if hasReturn && !generator && !strings.Contains(funcBody, "return") {
 			funcBody += AddIndent("return nil\n", ctx.Indent*2)
 		}
 
@@ -2666,16 +3248,113 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 				if !contains(ctx.OSLPackagePrefixes, pkgName) {
 					ctx.OSLPackagePrefixes = append(ctx.OSLPackagePrefixes, pkgName)
 				}
+				if p, err := findPackage(pkgName); err == nil && p.goVar() != pkgName {
+					ctx.packageVars[pkgName] = p.goVar()
+				}
 			}
 		}
+	case "embed":
+		// the files are declared with the globals, see compileEmbeds
+		if ctx.Indent > 0 {
+			panic("Embed command must be used at the top level")
+		}
+		parseEmbed(cmd)
 	case "go", "defer":
 		if len(cmd) < 2 {
 			panic("Go and defer commands require at least 1 parameter")
 		}
+		if cmd[0].Data == "go" {
+			ctx.GoDepth++
+			defer func() { ctx.GoDepth-- }()
+		}
 		out += cmd[0].Data.(string) + " "
+		if len(cmd) == 2 && cmd[1].Type == TKN_BLK {
+			out += "func() {\n"
+			ctx.Indent++
+			out += CompileBlock(cmd[1].Data.([][]*Token), ctx)
+			ctx.Indent--
+			out += AddIndent("}()", ctx.Indent*2)
+			break
+		}
 		for i := 1; i < len(cmd); i++ {
 			out += CompileToken(cmd[i], ctx)
 		}
+	case "bench":
+		if len(cmd) != 3 || cmd[1].Type != TKN_STR || cmd[2].Type != TKN_BLK {
+			panic(`Bench command requires a name and a block, eg. bench "concat" ( ... )`)
+		}
+		// bench blocks only run under osl bench, like go benchmarks only run under go test
+		if !compileOptions.Bench {
+			return ""
+		}
+		if !ctx.Imports["osl/bench"] {
+			ctx.Imports["osl/bench"] = true
+			ctx.ImportOrder = append(ctx.ImportOrder, "osl/bench")
+		}
+		// the block is a closure, it sees the setup code's variables but keeps its own
+		ctx.Indent++
+		ctx.ScopeLevel++
+		savedDeclaredVars := ctx.DeclaredVars
+		ctx.DeclaredVars = maps.Clone(ctx.DeclaredVars)
+		savedHoistedVars := ctx.HoistedVars
+		ctx.HoistedVars = []string{}
+		body := CompileBlock(cmd[2].Data.([][]*Token), ctx)
+		var hoistDecls string
+		for _, varName := range ctx.HoistedVars {
+			goType := ctx.VariableTypes[varName]
+			if goType == "" {
+				goType = "any"
+			}
+			hoistDecls += AddIndent(fmt.Sprintf("var %v %v\n", varName, goType), ctx.Indent*2)
+		}
+		ctx.DeclaredVars = savedDeclaredVars
+		ctx.HoistedVars = savedHoistedVars
+		ctx.ScopeLevel--
+		ctx.Indent--
+		out += fmt.Sprintf("bench.Add(%q, func() {\n", cmd[1].Data) + hoistDecls + body + AddIndent("})", ctx.Indent*2)
+	case "fuzz":
+		if len(cmd) != 4 || cmd[1].Type != TKN_STR || cmd[3].Type != TKN_BLK {
+			panic(`Fuzz command requires a name, parameters and a block, eg. fuzz "parse" (string s, int n) ( ... )`)
+		}
+		names, types := fuzzParams(cmd[2])
+		// fuzz blocks only run under osl fuzz, like go fuzz targets only run under go test
+		if !compileOptions.Fuzz {
+			return ""
+		}
+		if !ctx.Imports["osl/fuzz"] {
+			ctx.Imports["osl/fuzz"] = true
+			ctx.ImportOrder = append(ctx.ImportOrder, "osl/fuzz")
+		}
+		ctx.Indent++
+		ctx.ScopeLevel++
+		savedDeclaredVars := ctx.DeclaredVars
+		ctx.DeclaredVars = maps.Clone(ctx.DeclaredVars)
+		savedHoistedVars := ctx.HoistedVars
+		ctx.HoistedVars = []string{}
+		savedTypes := maps.Clone(ctx.VariableTypes)
+		var params []string
+		for i, name := range names {
+			ctx.DeclaredVars[name] = true
+			ctx.VariableTypes[name] = types[i]
+			params = append(params, name+" "+types[i])
+		}
+		body := CompileBlock(cmd[3].Data.([][]*Token), ctx)
+		var hoistDecls string
+		for _, varName := range ctx.HoistedVars {
+			goType := ctx.VariableTypes[varName]
+			if goType == "" {
+				goType = "any"
+			}
+			hoistDecls += AddIndent(fmt.Sprintf("var %v %v\n", varName, goType), ctx.Indent*2)
+		}
+		ctx.DeclaredVars = savedDeclaredVars
+		ctx.HoistedVars = savedHoistedVars
+		ctx.VariableTypes = savedTypes
+		ctx.ScopeLevel--
+		ctx.Indent--
+		name := cmd[1].Data.(string)
+		out += fmt.Sprintf("fuzz.Add(%q, %q, %#v, func(%v) {\n", name, fuzzTargetName(name), names, strings.Join(params, ", ")) +
+			hoistDecls + body + AddIndent("})", ctx.Indent*2)
 	case "void":
 		if len(cmd) < 2 {
 			panic("Void command requires at least 1 parameter")
@@ -2813,13 +3492,23 @@ func CompileCmd(cmd []*Token, ctx *VariableContext) string {
 	return out + "\n"
 }
 
+// markWorkerHandlers finds the functions written in a worker's object, so they are
+// compiled to take the worker they run on as self
+func markWorkerHandlers(props *Token, ctx *VariableContext) {
+	if props == nil || props.Type != TKN_OBJ {
+		return
+	}
+	pairs, _ := props.Data.([][]*Token)
+	for _, pair := range pairs {
+		if len(pair) > 1 && pair[1].Type == TKN_FNC && pair[1].Data == "function" {
+			ctx.workerHandlers[pair[1]] = true
+		}
+	}
+}
+
 func CompileObject(obj [][]*Token, ctx *VariableContext) string {
 	var out strings.Builder
-	if false && (ctx.IsInit || ctx.Indent == 0) {
-		out.WriteString("NewSafeMap(map[string]any{\n")
-	} else {
-		out.WriteString("map[string]any{\n")
-	}
+	out.WriteString("map[string]any{\n")
 	for _, token := range obj {
 		keyStr := ""
 		switch token[0].Type {
@@ -2840,17 +3529,10 @@ func CompileObject(obj [][]*Token, ctx *VariableContext) string {
 
 func CompileArray(arr []*Token, ctx *VariableContext) string {
 	if len(arr) == 0 {
-		if false && (ctx.IsInit || ctx.Indent == 0) {
-			return "NewSafeSlice([]any{})"
-		}
 		return "[]any{}"
 	}
 	var out strings.Builder
-	if false && (ctx.IsInit || ctx.Indent == 0) {
-		out.WriteString("NewSafeSlice([]any{\n")
-	} else {
-		out.WriteString("[]any{\n")
-	}
+	out.WriteString("[]any{\n")
 	for _, token := range arr {
 		out.WriteString(AddIndent(fmt.Sprintf("%v,\n", CompileToken(token, ctx)), ctx.Indent*2))
 	}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// targetPlatform returns the os and arch the program is being built for
+func targetPlatform() (string, string) {
+	if goos, ok := wasmTargets[compileOptions.Target]; ok {
+		return goos, "wasm"
+	}
+	return runtime.GOOS, runtime.GOARCH
+}
+
+// parseDefine converts the value of a -D name=value flag to an OSL value
+func parseDefine(value string) any {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return n
+	}
+	return value
+}
+
+// conditionVars returns the variables #if conditions can use
+func conditionVars() map[string]any {
+	goos, arch := targetPlatform()
+	tags := make([]any, len(compileOptions.Tags))
+	for i, tag := range compileOptions.Tags {
+		tags[i] = tag
+	}
+	vars := map[string]any{
+		"os":   goos,
+		"arch": arch,
+		"tags": tags,
+	}
+	for name, value := range compileOptions.Defines {
+		vars[name] = parseDefine(value)
+	}
+	return vars
+}
+
+// evalCondition evaluates the condition of an #if or #elif line. Names that
+// were never defined are null, so #if debug is false without -D debug.
+func evalCondition(cond string, vars map[string]any) (result bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	tokens := parser.GenerateAST(cond, 0, false)
+	if len(tokens) != 1 {
+		return false, fmt.Errorf("invalid condition %q", cond)
+	}
+	in := NewInterpreter()
+	in.undefinedNull = true
+	for name, value := range vars {
+		in.globals.vars[name] = value
+	}
+	return OSLcastBool(in.eval(tokens[0], in.globals)), nil
+}
+
+// preprocess applies #if, #elif, #else and #end blocks. Lines that are compiled
+// out are blanked with spaces so the positions of everything else stay the same.
+func preprocess(script string) string {
+	if !strings.Contains(script, "#if") {
+		return script
+	}
+
+	type branch struct {
+		parentActive bool // whether the lines around the block are kept
+		taken        bool // whether an earlier branch was kept
+		active       bool // whether the current branch is kept
+		line         int
+	}
+	var stack []branch
+	active := true
+	vars := conditionVars()
+
+	lines := strings.Split(script, "\n")
+	for i, line := range lines {
+		directive, cond, _ := strings.Cut(strings.TrimSpace(line), " ")
+		cond = strings.TrimSpace(cond)
+
+		switch directive {
+		case "#if", "#elif":
+			if directive == "#if" {
+				stack = append(stack, branch{parentActive: active, line: i + 1})
+			} else if len(stack) == 0 {
+				panic(fmt.Sprintf("Line %d: #elif without #if", i+1))
+			}
+			top := &stack[len(stack)-1]
+			if cond == "" {
+				panic(fmt.Sprintf("Line %d: %v requires a condition", i+1, directive))
+			}
+			top.active = false
+			if top.parentActive && !top.taken {
+				result, err := evalCondition(cond, vars)
+				if err != nil {
+					panic(fmt.Sprintf("Line %d: %v %v: %v", i+1, directive, cond, err))
+				}
+				top.active = result
+				top.taken = result
+			}
+			active = top.active
+		case "#else":
+			if len(stack) == 0 {
+				panic(fmt.Sprintf("Line %d: #else without #if", i+1))
+			}
+			top := &stack[len(stack)-1]
+			top.active = top.parentActive && !top.taken
+			top.taken = true
+			active = top.active
+		case "#end":
+			if len(stack) == 0 {
+				panic(fmt.Sprintf("Line %d: #end without #if", i+1))
+			}
+			active = stack[len(stack)-1].parentActive
+			stack = stack[:len(stack)-1]
+		default:
+			if active {
+				continue
+			}
+		}
+		lines[i] = strings.Repeat(" ", len(line))
+	}
+	if len(stack) > 0 {
+		panic(fmt.Sprintf("Line %d: #if without #end", stack[len(stack)-1].line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// constantLiteral formats a constant's value as a Go literal
+func constantLiteral(name string, value any) string {
+	switch value := value.(type) {
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			break
+		}
+		out := strconv.FormatFloat(value, 'g', -1, 64)
+		if !strings.ContainsAny(out, ".e") {
+			out += ".0"
+		}
+		return out
+	case string:
+		return strconv.Quote(value)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	panic(fmt.Sprintf("Constant %v must be a number, string or boolean, got %v", name, OSLtypeof(value)))
+}
+
+// compileConst evaluates a const declaration at compile time and declares it as a Go constant
+func compileConst(token *Token, ctx *VariableContext) string {
+	if token.Left == nil || token.Left.Type != TKN_VAR || token.Data != "=" {
+		panic("Constants must be declared with const name = value")
+	}
+	name := token.Left.Data.(string)
+	if _, exists := ctx.Constants[name]; exists {
+		panic("Constant " + name + " is already declared")
+	}
+
+	in := NewInterpreter()
+	for constName, value := range ctx.Constants {
+		in.globals.vars[constName] = value
+	}
+	var value any
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panic(fmt.Sprintf("Constant %v must be known at compile time: %v", name, r))
+			}
+		}()
+		value = in.eval(token.Right, in.globals)
+	}()
+
+	literal := constantLiteral(name, value)
+	ctx.Constants[name] = value
+	ctx.DeclaredVars[name] = true
+	return fmt.Sprintf("const %v = %v", name, literal)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// generatedMarker stands in for a line directive back to the generated Go file.
+// The line it points to is only known once the whole file is put together, so
+// debugSource fills it in.
+const generatedMarker = "/*line OSLgenerated*/"
+
+// lineDirective points the Go code for a line back at the osl source it came from
+func lineDirective(ctx *VariableContext, tok *Token) string {
+	if !compileOptions.Debug || ctx.SourceFile == "" {
+		return ""
+	}
+	return fmt.Sprintf("/*line %s:%d:%d*/", ctx.SourceFile, tok.Line, max(tok.Column, 1))
+}
+
+// generatedDirective starts code that osl generates rather than translates, like
+// package sources, so its lines stay where they are in the Go file
+func generatedDirective() string {
+	if !compileOptions.Debug {
+		return ""
+	}
+	return "\n" + generatedMarker
+}
+
+var (
+	selfIdentRegex = regexp.MustCompile(`\bOSLself\b`)
+	selfAliasRegex = regexp.MustCompile(`(?m)^\s*OSLself := self\n`)
+)
+
+// debugSource finishes the Go code for a debug build written to goFile: it names
+// self as it is named in osl, so debuggers show it, and resolves generatedMarker
+func debugSource(source string, goFile string) string {
+	// methods on built in types already take self, they only alias it for the compiler
+	source = selfAliasRegex.ReplaceAllString(source, "")
+	source = selfIdentRegex.ReplaceAllString(source, "self")
+
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, generatedMarker) {
+			lines[i] = strings.ReplaceAll(line, generatedMarker, fmt.Sprintf("/*line %s:%d:1*/", goFile, i+1))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// findDelve returns the path to dlv, looking in $PATH and then where go install puts it
+func findDelve() string {
+	if path, err := exec.LookPath("dlv"); err == nil {
+		return path
+	}
+	out, err := exec.Command("go", "env", "GOPATH").Output()
+	if err != nil {
+		return ""
+	}
+	for _, dir := range filepath.SplitList(strings.TrimSpace(string(out))) {
+		path := filepath.Join(dir, "bin", "dlv")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func debug(args []string) {
+	var programArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, programArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	listen := "127.0.0.1:2345"
+	buildOnly := false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--listen":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --listen flag requires an address, eg. 127.0.0.1:2345")
+				return
+			}
+			listen = args[i+1]
+			i++
+		case "--build-only":
+			buildOnly = true
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	opts, err := parseBuildArgs(rest, false)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if opts.interp || opts.target != "" {
+		fmt.Println("Error: osl debug builds a native binary, --interp and --target are not supported")
+		return
+	}
+	if opts.inputFile == "" {
+		fmt.Println("Usage: osl debug <file.osl> [--listen <host:port>] [--build-only] [build options] [-- program args]")
+		return
+	}
+
+	scriptPath, err := filepath.Abs(opts.inputFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	scriptDir := filepath.Dir(scriptPath)
+	if err := os.Chdir(scriptDir); err != nil {
+		fmt.Println("Failed to change to script directory:", err)
+		return
+	}
+	script := openFile(filepath.Base(scriptPath))
+	if script == "" {
+		return
+	}
+
+	// the generated code is kept next to the script so the debugger can show it
+	// when stepping into osl's own functions
+	name := strings.TrimSuffix(filepath.Base(scriptPath), ".osl")
+	debugDir := filepath.Join(scriptDir, ".osl-debug", name)
+	if err := os.MkdirAll(debugDir, 0755); err != nil {
+		fmt.Println("Failed to create debug directory:", err)
+		return
+	}
+	goFile := filepath.Join(debugDir, "main.go")
+	binary := filepath.Join(debugDir, name)
+
+	compileOptions = opts.toCompileOptions()
+	compileOptions.Debug = true
+	compileOptions.File = scriptPath
+	source := debugSource("package main\n\n"+Compile(scriptToAst(script)), goFile)
+	if err := os.WriteFile(goFile, []byte(source), 0644); err != nil {
+		fmt.Println("Failed to write Go file:", err)
+		return
+	}
+	if err := copyGoModFiles(scriptDir, debugDir); err != nil {
+		fmt.Println("Warning: failed to copy go.mod/go.sum:", err)
+	}
+	if err := copyEmbeddedFiles(scriptDir, debugDir); err != nil {
+		fmt.Println("Failed to copy embedded files:", err)
+		return
+	}
+
+	// optimisations and inlining off, so every osl line and variable can be inspected
+	buildArgs := opts.goBuildArgs(binary, goFile)
+	buildArgs = append([]string{"build", "-gcflags=all=-N -l"}, buildArgs[1:]...)
+	buildCmd := exec.Command("go", buildArgs...)
+	buildCmd.Dir = debugDir
+	buildCmd.Env = opts.goBuildEnv()
+	if output, err := buildCmd.CombinedOutput(); err != nil {
+		fmt.Println("Build failed!")
+		fmt.Println(string(output))
+		return
+	}
+	fmt.Println("Built debug binary:", binary)
+	if buildOnly {
+		return
+	}
+
+	dlv := findDelve()
+	if dlv == "" {
+		fmt.Println("Delve was not found, install it with: go install github.com/go-delve/delve/cmd/dlv@latest")
+		fmt.Println("or debug the binary above with any Go debugger")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Delve is listening for DAP clients on %s. To attach from VS Code, add to .vscode/launch.json:\n", listen)
+	host, port, _ := strings.Cut(listen, ":")
+	fmt.Printf(`
+  {
+    "name": "Attach to osl debug",
+    "type": "go",
+    "request": "attach",
+    "mode": "remote",
+    "host": %q,
+    "port": %s
+  }
+
+and set "debug.allowBreakpointsEverywhere": true so breakpoints can go in .osl files.
+
+`, host, port)
+
+	dlvArgs := []string{"exec", binary, "--headless", "--listen=" + listen, "--api-version=2", "--accept-multiclient"}
+	if len(programArgs) > 0 {
+		dlvArgs = append(dlvArgs, "--")
+		dlvArgs = append(dlvArgs, programArgs...)
+	}
+	cmd := exec.Command(dlv, dlvArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Println("Delve exited:", err)
+		os.Exit(1)
+	}
+}
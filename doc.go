@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// docFunc is a method programs can call, with its types as OSL sees them
+type docFunc struct {
+	receiver string
+	name     string
+	params   []string
+	results  []string
+	doc      string
+}
+
+// docType is a type a package's methods hand back, like the *Cache from cache.create
+type docType struct {
+	name    string
+	doc     string
+	methods []docFunc
+}
+
+// docPackage is the API reference for one osl/* package
+type docPackage struct {
+	pkg     *oslPackage
+	methods []docFunc
+	types   []docType
+}
+
+// docHiddenMethods are methods Go calls for a type rather than programs
+var docHiddenMethods = map[string]bool{
+	"String": true, "Error": true, "MarshalJSON": true, "UnmarshalJSON": true,
+}
+
+// goTypeToOSL names a Go type the way OSL code writes it, the reverse of mapOSLTypeToGo
+func goTypeToOSL(expr goast.Expr) string {
+	switch t := expr.(type) {
+	case *goast.Ident:
+		switch t.Name {
+		case "float64", "float32", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "byte", "rune":
+			return "number"
+		case "bool":
+			return "boolean"
+		case "interface":
+			return "any"
+		}
+		for osl, goType := range oslTypes {
+			if goType == t.Name && osl != "auto" {
+				return osl
+			}
+		}
+		return t.Name
+	case *goast.StarExpr:
+		return goTypeToOSL(t.X)
+	case *goast.ArrayType:
+		if ident, ok := t.Elt.(*goast.Ident); ok && ident.Name == "any" {
+			return "array"
+		}
+		return goTypeToOSL(t.Elt) + "[]"
+	case *goast.MapType:
+		return "object"
+	case *goast.InterfaceType:
+		return "any"
+	case *goast.FuncType:
+		return "function"
+	case *goast.Ellipsis:
+		return goTypeToOSL(t.Elt)
+	case *goast.SelectorExpr:
+		return goTypeToOSL(t.X) + "." + t.Sel.Name
+	case *goast.ChanType:
+		return "channel"
+	}
+	return "any"
+}
+
+// typeName returns the name of the type a receiver or result refers to, without the *
+func typeName(expr goast.Expr) string {
+	switch t := expr.(type) {
+	case *goast.Ident:
+		return t.Name
+	case *goast.StarExpr:
+		return typeName(t.X)
+	case *goast.IndexExpr:
+		return typeName(t.X)
+	}
+	return ""
+}
+
+func docFields(fields *goast.FieldList, named bool) []string {
+	if fields == nil {
+		return nil
+	}
+	var out []string
+	for _, field := range fields.List {
+		osl := goTypeToOSL(field.Type)
+		prefix := ""
+		if _, ok := field.Type.(*goast.Ellipsis); ok {
+			prefix = "..."
+		}
+		if !named || len(field.Names) == 0 {
+			for range max(1, len(field.Names)) {
+				out = append(out, prefix+osl)
+			}
+			continue
+		}
+		for _, name := range field.Names {
+			out = append(out, prefix+osl+" "+name.Name)
+		}
+	}
+	return out
+}
+
+// signature formats a method like cache.getOrSetFunc(any key, any fn) any
+func (f docFunc) signature() string {
+	out := fmt.Sprintf("%s.%s(%s)", f.receiver, f.name, strings.Join(f.params, ", "))
+	switch len(f.results) {
+	case 0:
+	case 1:
+		out += " " + f.results[0]
+	default:
+		out += " (" + strings.Join(f.results, ", ") + ")"
+	}
+	return out
+}
+
+// summary is the first sentence of the doc comment
+func (f docFunc) summary() string {
+	paragraph, _, _ := strings.Cut(f.doc, "\n\n")
+	paragraph = strings.Join(strings.Fields(paragraph), " ")
+	if sentence, _, ok := strings.Cut(paragraph, ". "); ok {
+		return sentence + "."
+	}
+	return paragraph
+}
+
+// readDocs parses a package and collects the methods of its singleton, and of the
+// types those methods return. Packages with Go syntax errors are documented as far
+// as the parser gets.
+func readDocs(p *oslPackage) *docPackage {
+	file, _ := goparser.ParseFile(token.NewFileSet(), p.name+".go", "package main; "+p.source, goparser.ParseComments|goparser.AllErrors|goparser.SkipObjectResolution)
+	d := &docPackage{pkg: p}
+	if file == nil {
+		return d
+	}
+
+	typeDocs := make(map[string]string)
+	funcResults := make(map[string]string)
+	methods := make(map[string][]*goast.FuncDecl)
+	singleton := ""
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *goast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				recv := typeName(decl.Recv.List[0].Type)
+				methods[recv] = append(methods[recv], decl)
+			} else if decl.Type.Results != nil && len(decl.Type.Results.List) > 0 {
+				funcResults[decl.Name.Name] = typeName(decl.Type.Results.List[0].Type)
+			}
+		case *goast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *goast.TypeSpec:
+					doc := spec.Doc
+					if doc == nil {
+						doc = decl.Doc
+					}
+					typeDocs[spec.Name.Name] = strings.TrimSpace(doc.Text())
+				case *goast.ValueSpec:
+					for i, name := range spec.Names {
+						if name.Name != p.name {
+							continue
+						}
+						if spec.Type != nil {
+							singleton = typeName(spec.Type)
+						} else if i < len(spec.Values) {
+							singleton = valueType(spec.Values[i], funcResults)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	collect := func(recv string, as string) []docFunc {
+		var out []docFunc
+		for _, m := range methods[recv] {
+			name := m.Name.Name
+			if name == "_" || docHiddenMethods[name] || strings.HasPrefix(name, "OSL") {
+				continue
+			}
+			out = append(out, docFunc{
+				receiver: as,
+				name:     name,
+				params:   docFields(m.Type.Params, true),
+				results:  docFields(m.Type.Results, false),
+				doc:      strings.TrimSpace(m.Doc.Text()),
+			})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+		return out
+	}
+	d.methods = collect(singleton, p.name)
+
+	// follow the types the methods hand back, so instances like a cache are documented too
+	seen := map[string]bool{singleton: true}
+	queue := []docFunc{}
+	queue = append(queue, d.methods...)
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		for _, result := range f.results {
+			name := strings.TrimSuffix(result, "[]")
+			if seen[name] || len(methods[name]) == 0 {
+				continue
+			}
+			seen[name] = true
+			t := docType{name: name, doc: typeDocs[name], methods: collect(name, name)}
+			d.types = append(d.types, t)
+			queue = append(queue, t.methods...)
+		}
+	}
+	return d
+}
+
+// valueType works out the type of a singleton's value, like Cache{}, &HTTP{} or NewDiff()
+func valueType(expr goast.Expr, funcResults map[string]string) string {
+	switch v := expr.(type) {
+	case *goast.CompositeLit:
+		return typeName(v.Type)
+	case *goast.UnaryExpr:
+		return valueType(v.X, funcResults)
+	case *goast.CallExpr:
+		if ident, ok := v.Fun.(*goast.Ident); ok {
+			return funcResults[ident.Name]
+		}
+	}
+	return ""
+}
+
+// docHidden are built in packages the compiler imports for bench and fuzz blocks,
+// mainloop: and --profile. They are left out of the doc index but osl doc <name> shows them.
+var docHidden = map[string]bool{
+	"bench":    true,
+	"fuzz":     true,
+	"mainloop": true,
+	"profile":  true,
+}
+
+func docPackageNames() []*oslPackage {
+	var packages []*oslPackage
+	for _, p := range allPackages() {
+		if docHidden[p.name] && p.builtin() {
+			continue
+		}
+		packages = append(packages, p)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].name < packages[j].name })
+	return packages
+}
+
+func doc(args []string) {
+	format := ""
+	outDir := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--md", "--html":
+			format = strings.TrimPrefix(args[i], "--")
+			if i+1 >= len(args) {
+				fmt.Printf("Usage: osl doc %s <dir> [package]\n", args[i])
+				return
+			}
+			outDir = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	if format != "" {
+		packages := docPackageNames()
+		if len(rest) > 0 {
+			p, err := findPackage(rest[0])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			packages = []*oslPackage{p}
+		}
+		if err := writeDocs(packages, format, outDir); err != nil {
+			fmt.Println("Failed to write docs:", err)
+			return
+		}
+		fmt.Printf("Wrote docs for %d package(s) to %s\n", len(packages), outDir)
+		return
+	}
+
+	switch len(rest) {
+	case 0:
+		fmt.Println("Usage: osl doc [package] [method], or osl doc --md|--html <dir> [package]")
+		fmt.Println()
+		for _, p := range docPackageNames() {
+			fmt.Printf("  %-16s %s\n", p.name, p.description)
+		}
+	default:
+		p, err := findPackage(rest[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		d := readDocs(p)
+		if len(rest) == 1 {
+			fmt.Print(d.text())
+			return
+		}
+		for _, f := range d.allMethods() {
+			if f.name == rest[1] || f.receiver+"."+f.name == rest[1] {
+				fmt.Println(f.signature())
+				if f.doc != "" {
+					fmt.Println()
+					fmt.Println(indentDoc(f.doc, "    "))
+				}
+				return
+			}
+		}
+		fmt.Printf("osl/%s has no method %s\n", p.name, rest[1])
+	}
+}
+
+func (d *docPackage) allMethods() []docFunc {
+	all := append([]docFunc{}, d.methods...)
+	for _, t := range d.types {
+		all = append(all, t.methods...)
+	}
+	return all
+}
+
+func indentDoc(doc string, indent string) string {
+	return indent + strings.ReplaceAll(doc, "\n", "\n"+indent)
+}
+
+// text is the terminal listing for a package
+func (d *docPackage) text() string {
+	var out strings.Builder
+	p := d.pkg
+	fmt.Fprintf(&out, "import \"osl/%s\"\n\n", p.name)
+	if p.description != "" {
+		fmt.Fprintf(&out, "%s\n", p.description)
+	}
+	var about []string
+	if p.version != "" {
+		about = append(about, "version "+p.version)
+	}
+	if p.author != "" {
+		about = append(about, "by "+p.author)
+	}
+	about = append(about, "from "+p.location())
+	fmt.Fprintf(&out, "%s\n", strings.Join(about, ", "))
+
+	list := func(methods []docFunc) {
+		for _, f := range methods {
+			fmt.Fprintf(&out, "  %s\n", f.signature())
+			if summary := f.summary(); summary != "" {
+				fmt.Fprintf(&out, "      %s\n", summary)
+			}
+		}
+	}
+	if len(d.methods) > 0 {
+		out.WriteString("\n")
+		list(d.methods)
+	}
+	for _, t := range d.types {
+		fmt.Fprintf(&out, "\n%s\n", t.name)
+		if t.doc != "" {
+			fmt.Fprintf(&out, "%s\n", indentDoc(t.doc, "  "))
+		}
+		list(t.methods)
+	}
+	return out.String()
+}
+
+// markdown is the reference page for a package
+func (d *docPackage) markdown() string {
+	var out strings.Builder
+	p := d.pkg
+	fmt.Fprintf(&out, "# osl/%s\n\n", p.name)
+	if p.description != "" {
+		fmt.Fprintf(&out, "%s\n\n", p.description)
+	}
+	fmt.Fprintf(&out, "```osl\nimport \"osl/%s\"\n```\n\n", p.name)
+	if p.version != "" {
+		fmt.Fprintf(&out, "Version %s. ", p.version)
+	}
+	if p.author != "" {
+		fmt.Fprintf(&out, "By %s.", p.author)
+	}
+	out.WriteString("\n")
+
+	section := func(title string, doc string, methods []docFunc) {
+		fmt.Fprintf(&out, "\n## %s\n\n", title)
+		if doc != "" {
+			fmt.Fprintf(&out, "%s\n\n", doc)
+		}
+		for _, f := range methods {
+			fmt.Fprintf(&out, "### %s\n\n```osl\n%s\n```\n\n", f.name, f.signature())
+			if f.doc != "" {
+				fmt.Fprintf(&out, "%s\n\n", f.doc)
+			}
+		}
+	}
+	if len(d.methods) > 0 {
+		section("Methods", "", d.methods)
+	}
+	for _, t := range d.types {
+		section(t.name, t.doc, t.methods)
+	}
+	return out.String()
+}
+
+// html is the reference page for a package
+func (d *docPackage) html() string {
+	var out strings.Builder
+	p := d.pkg
+	esc := html.EscapeString
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>osl/%s</title>\n%s</head>\n<body>\n", esc(p.name), docStyle)
+	fmt.Fprintf(&out, "<p><a href=\"index.html\">Packages</a></p>\n<h1>osl/%s</h1>\n", esc(p.name))
+	if p.description != "" {
+		fmt.Fprintf(&out, "<p>%s</p>\n", esc(p.description))
+	}
+	fmt.Fprintf(&out, "<pre>import \"osl/%s\"</pre>\n", esc(p.name))
+	if p.version != "" || p.author != "" {
+		fmt.Fprintf(&out, "<p class=\"about\">%s %s</p>\n", esc(p.version), esc(p.author))
+	}
+
+	section := func(title string, doc string, methods []docFunc) {
+		fmt.Fprintf(&out, "<h2 id=\"%s\">%s</h2>\n", esc(title), esc(title))
+		if doc != "" {
+			fmt.Fprintf(&out, "<p>%s</p>\n", esc(doc))
+		}
+		for _, f := range methods {
+			fmt.Fprintf(&out, "<h3 id=\"%s.%s\">%s</h3>\n<pre>%s</pre>\n", esc(f.receiver), esc(f.name), esc(f.name), esc(f.signature()))
+			if f.doc != "" {
+				fmt.Fprintf(&out, "<p>%s</p>\n", esc(f.doc))
+			}
+		}
+	}
+	if len(d.methods) > 0 {
+		section("Methods", "", d.methods)
+	}
+	for _, t := range d.types {
+		section(t.name, t.doc, t.methods)
+	}
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
+
+const docStyle = `<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; line-height: 1.5; }
+pre { background: #f4f4f4; padding: .5em .75em; overflow-x: auto; }
+p { white-space: pre-line; }
+.about { color: #666; }
+</style>
+`
+
+// writeDocs writes one page per package and an index linking them
+func writeDocs(packages []*oslPackage, format string, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ext := "." + format
+	var index strings.Builder
+	if format == "html" {
+		fmt.Fprintf(&index, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>OSL packages</title>\n%s</head>\n<body>\n<h1>OSL packages</h1>\n<ul>\n", docStyle)
+	} else {
+		index.WriteString("# OSL packages\n\n")
+	}
+
+	for _, p := range packages {
+		d := readDocs(p)
+		page := d.markdown()
+		if format == "html" {
+			page = d.html()
+			fmt.Fprintf(&index, "<li><a href=\"%s.html\">osl/%s</a> %s</li>\n", html.EscapeString(p.name), html.EscapeString(p.name), html.EscapeString(p.description))
+		} else {
+			fmt.Fprintf(&index, "- [osl/%s](%s.md) %s\n", p.name, p.name, p.description)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p.name+ext), []byte(page), 0644); err != nil {
+			return err
+		}
+	}
+
+	name := "README.md"
+	if format == "html" {
+		index.WriteString("</ul>\n</body>\n</html>\n")
+		name = "index.html"
+	}
+	return os.WriteFile(filepath.Join(dir, name), []byte(index.String()), 0644)
+}
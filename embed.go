@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// embedDirective is one embed "path" [as name] line
+type embedDirective struct {
+	path string
+	name string
+}
+
+// embeddedFiles lists the paths the last compiled program embeds, relative to its script
+var embeddedFiles []string
+
+// parseEmbed reads an embed command, panicking on malformed ones like the other commands do
+func parseEmbed(cmd []*Token) embedDirective {
+	if len(cmd) != 2 && len(cmd) != 4 {
+		panic("Embed command requires a path, optionally followed by as <name>")
+	}
+	path, ok := cmd[1].Data.(string)
+	if !ok || cmd[1].Type != TKN_STR {
+		panic("Embed path must be a string")
+	}
+	path = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(path)), "/")
+	if filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, "../") {
+		panic("Embed path must be inside the script directory: " + path)
+	}
+
+	embed := embedDirective{path: path}
+	if len(cmd) == 4 {
+		if cmd[2].Data != "as" || cmd[3].Type != TKN_VAR {
+			panic("Embed command expects as <name> after the path")
+		}
+		embed.name = cmd[3].Data.(string)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			panic("Embed as <name> only works with files, " + path + " is a directory")
+		}
+	}
+	return embed
+}
+
+// collectEmbeds finds the embed commands at the top level of a program
+func collectEmbeds(ast [][]*Token) []embedDirective {
+	var embeds []embedDirective
+	for _, line := range ast {
+		if len(line) > 0 && line[0].Type == TKN_CMD && line[0].Data == "embed" {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						panic(fmt.Sprintf("Line %d: %v", line[0].Line, r))
+					}
+				}()
+				embeds = append(embeds, parseEmbed(line))
+			}()
+		}
+	}
+	return embeds
+}
+
+// compileEmbeds declares the go:embed variables. Every path goes into
+// OSLembedded so osl/fs and osl/serve can read it, and files embedded with
+// as <name> also get a string variable of their own.
+func compileEmbeds(embeds []embedDirective) string {
+	if len(embeds) == 0 {
+		return ""
+	}
+	var out strings.Builder
+	patterns := make([]string, len(embeds))
+	for i, embed := range embeds {
+		patterns[i] = strconv.Quote(embed.path)
+	}
+	fmt.Fprintf(&out, "\n//go:embed %v\nvar OSLembedFS embed.FS\n\n", strings.Join(patterns, " "))
+	for _, embed := range embeds {
+		if embed.name != "" {
+			fmt.Fprintf(&out, "//go:embed %v\nvar %v string\n\n", strconv.Quote(embed.path), embed.name)
+		}
+	}
+	out.WriteString("func init() {\n\tOSLembedded = OSLembedFS\n}\n\n")
+	return out.String()
+}
+
+// copyEmbeddedFiles copies the files the last compiled program embeds into its build dir
+func copyEmbeddedFiles(srcDir, dstDir string) error {
+	for _, path := range embeddedFiles {
+		src := filepath.Join(srcDir, filepath.FromSlash(path))
+		if _, err := os.Stat(src); err != nil {
+			return fmt.Errorf("embed %q: %w", path, err)
+		}
+		err := filepath.WalkDir(src, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(srcDir, p)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(dstDir, rel)
+			if entry.IsDir() {
+				return os.MkdirAll(dst, 0755)
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(dst, data, 0644)
+		})
+		if err != nil {
+			return fmt.Errorf("embed %q: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// cExportTypes are the C types osl types cross the C ABI as. Arrays, objects and
+// untyped values go as JSON strings.
+var cExportTypes = map[string]string{
+	"int":     "C.longlong",
+	"number":  "C.double",
+	"boolean": "C.bool",
+	"string":  "*C.char",
+	"array":   "*C.char",
+	"object":  "*C.char",
+	"any":     "*C.char",
+}
+
+// collectExports finds the export def functions of a program compiled with --shared
+func collectExports(ast [][]*Token, ctx *VariableContext) {
+	ctx.exported = make(map[string]bool)
+	if !compileOptions.Shared {
+		return
+	}
+	for _, line := range ast {
+		if len(line) == 0 || !line[0].Exported {
+			continue
+		}
+		name := line[0].Left.Data.(string)
+		if ctx.exported[name] {
+			panic(fmt.Sprintf("export def %s is defined twice", name))
+		}
+		ctx.exported[name] = true
+		ctx.exports = append(ctx.exports, line[0])
+	}
+}
+
+// checkSharedExports makes sure a program compiled with --shared exports something, a
+// library without an export def has nothing C can call
+func checkSharedExports(ast [][]*Token) error {
+	for _, line := range ast {
+		if len(line) > 0 && line[0].Exported {
+			return nil
+		}
+	}
+	return fmt.Errorf("--shared needs at least one export def, nothing would be callable from C")
+}
+
+// exportedName is the Go name of an osl function. Exported functions are renamed so
+// the C function cgo exports can take their name.
+func exportedName(name string, ctx *VariableContext) string {
+	if ctx.exported[name] {
+		return "OSLexported_" + name
+	}
+	return name
+}
+
+// exportParams reads the type and name of each parameter of an export def
+func exportParams(fn *Token) (types []string, names []string) {
+	spec, _ := fn.Parameters[0].Data.(string)
+	for _, param := range strings.Split(spec, ",") {
+		parts := strings.Fields(param)
+		switch len(parts) {
+		case 0:
+			continue
+		case 1:
+			types, names = append(types, "any"), append(names, parts[0])
+		default:
+			types = append(types, strings.Join(parts[:len(parts)-1], " "))
+			names = append(names, parts[len(parts)-1])
+		}
+	}
+	return types, names
+}
+
+// exportReturnType is the osl type an export def returns, "" when it returns nothing
+func exportReturnType(fn *Token) string {
+	if fn.Returns != "" {
+		if fn.Returns == "null" {
+			return ""
+		}
+		return fn.Returns
+	}
+	if len(fn.Parameters) > 1 && fn.Parameters[1] != nil {
+		if blk, ok := fn.Parameters[1].Data.([][]*Token); ok && hasReturnStatement(blk) {
+			return "any"
+		}
+	}
+	return ""
+}
+
+// goToC converts a Go value of an osl type to the C type it is exported as
+func goToC(expr string, oslType string) string {
+	switch oslType {
+	case "int", "number", "boolean":
+		return fmt.Sprintf("%s(%s)", cExportTypes[oslType], expr)
+	case "string":
+		return fmt.Sprintf("C.CString(%s)", expr)
+	}
+	return fmt.Sprintf("C.CString(JsonStringify(%s))", expr)
+}
+
+// cToGo converts a C argument to the Go type of its osl type
+func cToGo(expr string, oslType string) string {
+	switch oslType {
+	case "int":
+		return fmt.Sprintf("int(%s)", expr)
+	case "number":
+		return fmt.Sprintf("float64(%s)", expr)
+	case "boolean":
+		return fmt.Sprintf("bool(%s)", expr)
+	case "string":
+		return fmt.Sprintf("C.GoString(%s)", expr)
+	case "array":
+		return fmt.Sprintf("OSLcastArray(OSLexportValue(%s))", expr)
+	case "object":
+		return fmt.Sprintf("OSLcastObject(OSLexportValue(%s))", expr)
+	}
+	return fmt.Sprintf("OSLexportValue(%s)", expr)
+}
+
+// cgoPreamble imports C for the export wrappers, it goes right after the imports. cgo
+// copies the preamble into the header, so it documents how errors come back to C.
+func cgoPreamble(ctx *VariableContext) string {
+	if len(ctx.exports) == 0 {
+		return ""
+	}
+	return `/*
+#include <stdbool.h>
+#include <stdlib.h>
+
+// Every exported function takes a char **err after its own parameters. When the call
+// fails it returns the zero value and points *err at the error, which the caller owns
+// and frees with osl_free. *err is set to NULL when the call succeeds, and err itself
+// can be NULL to ignore errors. Strings returned are freed with osl_free too.
+*/
+import "C"
+
+`
+}
+
+// compileExports writes the C functions cgo exports for each export def. They convert
+// their arguments, call the osl function and convert what it returns. A panic returns
+// the zero value and the error through the err parameter each of them takes last, so
+// calls on different threads never share an error.
+func compileExports(ctx *VariableContext) string {
+	if len(ctx.exports) == 0 {
+		return ""
+	}
+	var out strings.Builder
+	out.WriteString(`// osl_free frees a string returned by an exported function or one of their errors
+//
+//export osl_free
+func osl_free(str *C.char) {
+	C.free(unsafe.Pointer(str))
+}
+
+// OSLexportBegin clears the error of a call, so it is NULL unless the call fails
+func OSLexportBegin(err **C.char) {
+	if err != nil {
+		*err = nil
+	}
+}
+
+func OSLexportRecover(err **C.char) {
+	if r := recover(); r != nil && err != nil {
+		*err = C.CString(fmt.Sprint(r))
+	}
+}
+
+// OSLexportValue decodes a JSON argument, numbers are float64 like every osl number
+func OSLexportValue(str *C.char) any {
+	var value any
+	if err := json.Unmarshal([]byte(C.GoString(str)), &value); err != nil {
+		panic(fmt.Sprintf("invalid JSON argument: %v", err))
+	}
+	return value
+}
+
+`)
+	for _, tok := range ctx.exports {
+		name := tok.Left.Data.(string)
+		fn := tok.Right
+		if len(fn.Parameters) > 1 && fn.Parameters[1] != nil {
+			if blk, ok := fn.Parameters[1].Data.([][]*Token); ok && containsYield(blk) {
+				panic(fmt.Sprintf("export def %s cannot be a generator", name))
+			}
+		}
+		types, names := exportParams(fn)
+		params := make([]string, len(types))
+		args := make([]string, len(types))
+		for i, oslType := range types {
+			cType, ok := cExportTypes[oslType]
+			if !ok {
+				panic(fmt.Sprintf("export def %s: parameter %s has type %s, which cannot be exported (use int, number, boolean, string, array, object or any)", name, names[i], oslType))
+			}
+			params[i] = names[i] + " " + cType
+			args[i] = cToGo(names[i], oslType)
+		}
+		call := fmt.Sprintf("%s(%s)", exportedName(name, ctx), strings.Join(args, ", "))
+
+		returns := exportReturnType(fn)
+		result := ""
+		body := "\t" + call + "\n"
+		if returns != "" {
+			cType, ok := cExportTypes[returns]
+			if !ok {
+				panic(fmt.Sprintf("export def %s returns %s, which cannot be exported (use int, number, boolean, string, array, object or any)", name, returns))
+			}
+			result = cType + " "
+			body = "\treturn " + goToC(call, returns) + "\n"
+		}
+		params = append(params, "OSLerr **C.char")
+		fmt.Fprintf(&out, "//export %s\nfunc %s(%s) %s{\n\tOSLexportBegin(OSLerr)\n\tdefer OSLexportRecover(OSLerr)\n%s}\n\n",
+			name, name, strings.Join(params, ", "), result, body)
+	}
+	return out.String()
+}
+
+// sharedLibraryName is the file osl compile --shared writes for a script by default
+func sharedLibraryName(script string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "lib" + script + ".dylib"
+	case "windows":
+		return script + ".dll"
+	}
+	return "lib" + script + ".so"
+}
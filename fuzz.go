@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var fuzzBlockRegex = regexp.MustCompile(`(?m)^\s*fuzz\s+"`)
+
+var fuzzParamRegex = regexp.MustCompile(`^(string|number|int|boolean|array|object)\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// fuzzParams reads the parameters of a fuzz block, eg. (string s, int n), as Go names and types
+func fuzzParams(tok *Token) (names []string, types []string) {
+	source := strings.TrimSpace(tok.Source)
+	inner, open := strings.CutPrefix(source, "(")
+	inner, closed := strings.CutSuffix(inner, ")")
+	if !open || !closed || strings.TrimSpace(inner) == "" {
+		panic("Fuzz parameters must be typed, eg. (string s, int n), got: " + source)
+	}
+	for _, param := range strings.Split(inner, ",") {
+		match := fuzzParamRegex.FindStringSubmatch(strings.TrimSpace(param))
+		if match == nil {
+			panic(fmt.Sprintf("Fuzz parameter %q must be a string, number, int, boolean, array or object followed by a name", strings.TrimSpace(param)))
+		}
+		names = append(names, match[2])
+		types = append(types, mapOSLTypeToGo(match[1]))
+	}
+	return names, types
+}
+
+// fuzzTargetName is the Go fuzz function a fuzz block runs as, "parse csv" runs as FuzzParseCsv
+func fuzzTargetName(name string) string {
+	var b strings.Builder
+	b.WriteString("Fuzz")
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type fuzzBlock struct {
+	name   string
+	target string
+}
+
+// fuzzBlocks lists the fuzz blocks at the top level of a program
+func fuzzBlocks(ast [][]*Token) ([]fuzzBlock, error) {
+	var blocks []fuzzBlock
+	seen := map[string]string{}
+	for _, line := range ast {
+		if len(line) < 2 || line[0].Type != TKN_CMD || line[0].Data != "fuzz" || line[1].Type != TKN_STR {
+			continue
+		}
+		name := line[1].Data.(string)
+		target := fuzzTargetName(name)
+		if other, ok := seen[target]; ok {
+			return nil, fmt.Errorf("fuzz blocks %q and %q both run as %s, rename one of them", other, name, target)
+		}
+		seen[target] = name
+		blocks = append(blocks, fuzzBlock{name: name, target: target})
+	}
+	return blocks, nil
+}
+
+// fuzzTestFile is the test file go test runs the fuzz blocks from, it has a FuzzX function
+// for each block and runs the program's top level code first, which registers them
+func fuzzTestFile(blocks []fuzzBlock) string {
+	var b strings.Builder
+	b.WriteString("package main\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\n")
+	b.WriteString("func TestMain(m *testing.M) {\n\tmain()\n\tos.Exit(m.Run())\n}\n")
+	for _, block := range blocks {
+		fmt.Fprintf(&b, "\nfunc %s(f *testing.F) {\n\tfuzz.Target(f, %q)\n}\n", block.target, block.name)
+	}
+	return b.String()
+}
+
+type fuzzOptions struct {
+	run      *regexp.Regexp
+	fuzzTime string
+	corpus   string
+	property bool
+	replay   bool
+	runs     string
+	seed     string
+}
+
+// fuzzFile runs the fuzz blocks of one file, with go test's fuzzing or as property tests
+func fuzzFile(path string, opts buildOptions, fopts fuzzOptions) error {
+	corpus := fopts.corpus
+	if corpus == "" {
+		corpus = filepath.Join(filepath.Dir(path), "testdata", "fuzz")
+	}
+	corpus, err := filepath.Abs(corpus)
+	if err != nil {
+		return err
+	}
+
+	ast := scriptToAst(openFile(path))
+	blocks, err := fuzzBlocks(ast)
+	if err != nil {
+		return err
+	}
+	var selected []fuzzBlock
+	for _, block := range blocks {
+		if fopts.run == nil || fopts.run.MatchString(block.name) {
+			selected = append(selected, block)
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Println("No fuzz blocks to run in", path)
+		return nil
+	}
+
+	options := opts.toCompileOptions()
+	options.Fuzz = true
+	tmpDir, err := compileBlocks(path, options, "fuzz.Run()")
+	if err != nil {
+		return err
+	}
+
+	if fopts.property {
+		args := []string{"-runs", fopts.runs, "-corpus", corpus}
+		if fopts.run != nil {
+			args = append(args, "-run", fopts.run.String())
+		}
+		if fopts.seed != "" {
+			args = append(args, "-seed", fopts.seed)
+		}
+		return runBlocks(tmpDir, opts, args)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "main_test.go"), []byte(fuzzTestFile(blocks)), 0644); err != nil {
+		return err
+	}
+	// go test fuzzes packages, not files, so the program needs a module of its own
+	if _, err := os.Stat(filepath.Join(tmpDir, "go.mod")); err != nil {
+		if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module oslfuzz\n\ngo 1.23\n"), 0644); err != nil {
+			return err
+		}
+	}
+	// go test reads the seed corpus from testdata/fuzz and saves failing inputs there
+	if err := os.MkdirAll(corpus, 0755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "testdata"), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(corpus, filepath.Join(tmpDir, "testdata", "fuzz")); err != nil {
+		return err
+	}
+
+	// goTest runs go test, showing its output as it goes. go test fails the same way for
+	// a failing input as for a program that does not build, so its output tells them apart.
+	goTest := func(args ...string) (failed bool, err error) {
+		testArgs := []string{"test"}
+		if opts.race {
+			testArgs = append(testArgs, "-race")
+		}
+		if len(opts.tags) > 0 {
+			testArgs = append(testArgs, "-tags", strings.Join(opts.tags, ","))
+		}
+		var output bytes.Buffer
+		cmd := exec.Command("go", append(testArgs, args...)...)
+		cmd.Dir = tmpDir
+		cmd.Env = opts.goBuildEnv()
+		cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+		if err := cmd.Run(); err != nil {
+			switch out := output.String(); {
+			case strings.Contains(out, "[build failed]"):
+				return false, fmt.Errorf("build failed, the errors are above")
+			case strings.Contains(out, "--- FAIL") || strings.Contains(out, "testdata/fuzz/"):
+				return true, nil
+			default:
+				return false, fmt.Errorf("go test failed: %v", err)
+			}
+		}
+		return false, nil
+	}
+
+	if fopts.replay {
+		var targets []string
+		for _, block := range selected {
+			targets = append(targets, block.target)
+		}
+		failed, err := goTest("-run", "^("+strings.Join(targets, "|")+")$", ".")
+		if failed {
+			return fmt.Errorf("inputs saved in %s still fail", corpus)
+		}
+		return err
+	}
+	anyFailed := false
+	for _, block := range selected {
+		fmt.Printf("fuzz %q (%s), corpus in %s\n", block.name, block.target, filepath.Join(corpus, block.target))
+		failed, err := goTest("-run", "^$", "-fuzz", "^"+block.target+"$", "-fuzztime", fopts.fuzzTime, "-timeout", "0", ".")
+		if err != nil {
+			return err
+		}
+		anyFailed = anyFailed || failed
+	}
+	if anyFailed {
+		return fmt.Errorf("fuzzing found failing inputs, they are saved in %s, replay them with osl fuzz --replay", corpus)
+	}
+	return nil
+}
+
+func fuzzCommand(args []string) {
+	fopts := fuzzOptions{fuzzTime: "10s", runs: "1000"}
+	var rest []string
+	valueFlags := map[string]*string{"--time": &fopts.fuzzTime, "--corpus": &fopts.corpus, "--runs": &fopts.runs, "--seed": &fopts.seed}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if value, ok := valueFlags[arg]; ok {
+			if i+1 >= len(args) {
+				fmt.Printf("Error: %s flag requires a value\n", arg)
+				return
+			}
+			*value = args[i+1]
+			i++
+			continue
+		}
+		switch arg {
+		case "--run":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --run flag requires a regexp")
+				return
+			}
+			run, err := regexp.Compile(args[i+1])
+			if err != nil {
+				fmt.Println("Error: invalid --run:", err)
+				return
+			}
+			fopts.run = run
+			i++
+		case "--property":
+			fopts.property = true
+		case "--replay":
+			fopts.replay = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	opts, err := parseBuildArgs(rest, false)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if opts.interp || opts.target != "" {
+		fmt.Println("Error: osl fuzz builds a native binary, --interp and --target are not supported")
+		return
+	}
+	files, err := findBlockFiles(opts.inputs, fuzzBlockRegex)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Println(`No fuzz blocks found, add some with: fuzz "name" (string s, int n) ( ... )`)
+		fmt.Println("Usage: osl fuzz [file.osl...] [--time <duration>] [--run <regexp>] [--corpus <dir>] [--property [--runs <n>] [--seed <n>]] [--replay]")
+		return
+	}
+
+	failed := false
+	for _, file := range files {
+		if len(files) > 1 {
+			fmt.Println(file + ":")
+		}
+		if err := fuzzFile(file, opts, fopts); err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
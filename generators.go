@@ -0,0 +1,56 @@
+package main
+
+import "fmt"
+
+// containsYield reports whether a function body yields, which makes the function a
+// generator. Functions defined inside the body are generators of their own.
+func containsYield(block [][]*Token) bool {
+	for _, line := range block {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0].Type == TKN_CMD && line[0].Data == "yield" {
+			return true
+		}
+		if line[0].Type == TKN_CMD && line[0].Data == "def" {
+			continue
+		}
+		for _, token := range line {
+			if token.Type != TKN_BLK {
+				continue
+			}
+			if sub, ok := token.Data.([][]*Token); ok && containsYield(sub) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileGenerator compiles the body of a generator function into the iter.Seq it
+// returns. The sequence runs the body each time it is ranged over, and yield stops
+// the body early when the loop consuming it breaks.
+func compileGenerator(block [][]*Token, ctx *VariableContext) string {
+	saved := ctx.InGenerator
+	ctx.InGenerator = true
+	ctx.Indent++
+	body := CompileBlock(block, ctx)
+	ctx.Indent--
+	ctx.InGenerator = saved
+	return AddIndent("return func(OSLyield func(any) bool) {\n", ctx.Indent*2) + body + AddIndent("}\n", ctx.Indent*2)
+}
+
+// compileYield compiles a yield command, which hands one value to the loop consuming a generator
+func compileYield(cmd []*Token, ctx *VariableContext) string {
+	if !ctx.InGenerator {
+		panic("yield can only be used inside a function")
+	}
+	if len(cmd) > 2 {
+		panic("yield takes a single value")
+	}
+	value := "nil"
+	if len(cmd) > 1 {
+		value = CompileToken(cmd[1], ctx)
+	}
+	return fmt.Sprintf("if !OSLyield(%v) {\n%v%v", value, AddIndent("return\n", (ctx.Indent+1)*2), AddIndent("}", ctx.Indent*2))
+}
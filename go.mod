@@ -5,7 +5,6 @@ go 1.23.0
 toolchain go1.24.5
 
 require (
-	github.com/dlclark/regexp2 v1.11.5
 	github.com/pkg/browser v0.0.0-20240102092130-5ac0b6a4141c
 )
 
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// headlessWindow is set when the program being compiled draws with the headless
+// backend, either from osl run --headless or because it calls window.headless()
+var headlessWindow = false
+
+// windowBackend names the package file that shows the canvas osl/window draws on.
+// Headless builds never import pixelgl, so they build and run without OpenGL or a display.
+func windowBackend() string {
+	if headlessWindow {
+		return "window-headless"
+	}
+	return "window-gl"
+}
+
+// windowFont downloads the font the text command draws with
+func windowFont() (string, error) {
+	resp, err := http.Get("https://raw.githubusercontent.com/Mistium/Origin-OS/main/Fonts/origin.ojff")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var fontMap map[string]any
+	if err := json.Unmarshal(data, &fontMap); err != nil {
+		return "", err
+	}
+	delete(fontMap, "origin")
+
+	return "\n\nvar OSLfont = map[string]string" + JsonStringify(fontMap) + "\n\n", nil
+}
+
+// headlessSettings is the go code for the settings the headless backend runs with
+func headlessSettings() string {
+	frames := compileOptions.Frames
+	if frames <= 0 {
+		frames = 1
+	}
+	return fmt.Sprintf("\nvar OSLheadlessFrames = %d\nvar OSLheadlessDir = %q\nvar OSLheadlessInput = %q\n",
+		frames, compileOptions.FramesDir, compileOptions.Input)
+}
+
+// callsWindowMethod reports whether a program calls window.name(...) anywhere
+func callsWindowMethod(ast [][]*Token, name string) bool {
+	for _, line := range ast {
+		for _, token := range line {
+			if tokenCallsWindowMethod(token, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func tokenCallsWindowMethod(token *Token, name string) bool {
+	if token == nil {
+		return false
+	}
+	switch data := token.Data.(type) {
+	case []*Token:
+		if token.Type == TKN_MTD && len(data) > 1 && data[0].Type == TKN_VAR && data[0].Data == "window" &&
+			data[1].Type == TKN_MTV && data[1].Data == name {
+			return true
+		}
+		for _, item := range data {
+			if tokenCallsWindowMethod(item, name) {
+				return true
+			}
+		}
+	case [][]*Token:
+		if callsWindowMethod(data, name) {
+			return true
+		}
+	case *Token:
+		if tokenCallsWindowMethod(data, name) {
+			return true
+		}
+	}
+	for _, child := range append([]*Token{token.Left, token.Right, token.Right2, token.Final}, token.Parameters...) {
+		if tokenCallsWindowMethod(child, name) {
+			return true
+		}
+	}
+	return false
+}
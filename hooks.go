@@ -0,0 +1,49 @@
+package main
+
+// hookOperators maps each operator hook a type can define to the operators it overloads
+var hookOperators = map[string][]string{
+	"__add": {"+"},
+	"__sub": {"-"},
+	"__mul": {"*"},
+	"__div": {"/"},
+	"__mod": {"%"},
+	"__lt":  {"<", ">", "<=", ">="},
+}
+
+// overloadedOperators holds the operators some type in the program being compiled
+// overloads. Only those operators pay for the runtime hook lookup.
+var overloadedOperators = map[string]bool{}
+
+// collectOperatorHooks finds the operators the types of a program overload
+func collectOperatorHooks(ast [][]*Token) map[string]bool {
+	ops := map[string]bool{}
+	for _, line := range ast {
+		if len(line) < 3 || line[0].Type != TKN_CMD || line[0].Data != "type" || line[2].Type != TKN_BLK {
+			continue
+		}
+		block, ok := line[2].Data.([][]*Token)
+		if !ok {
+			continue
+		}
+		for _, field := range block {
+			if len(field) == 0 || field[0].Type != TKN_ASI || field[0].Left == nil {
+				continue
+			}
+			name, _ := field[0].Left.Data.(string)
+			for _, op := range hookOperators[name] {
+				ops[op] = true
+			}
+		}
+	}
+	return ops
+}
+
+// mayBeUserType reports whether a value of the given compile time type could be
+// an instance of a type, and so could have operator hooks
+func mayBeUserType(typeVal string) bool {
+	switch typeVal {
+	case TYPE_STR, TYPE_INT, TYPE_NUM, TYPE_BOOL, TYPE_OBJ, TYPE_ARR:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,1506 @@
+package main
+
+//go:generate go run ./tools/genstd
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// interpFlow is how a statement left the block it ran in
+type interpFlow int
+
+const (
+	flowNext interpFlow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+// interpError is a runtime error with the line it happened on
+type interpError struct {
+	line int
+	msg  string
+}
+
+func (e *interpError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("Line %d: %v", e.line, e.msg)
+	}
+	return e.msg
+}
+
+// needsCompilation stops the interpreter on constructs only the Go backend supports
+func needsCompilation(what string) {
+	panic(fmt.Sprintf("%v needs compilation, run without --interp", what))
+}
+
+// interpScope holds the variables of one function call. Blocks share the scope
+// of the function they are in, the same way the compiler hoists variables.
+type interpScope struct {
+	vars   map[string]any
+	parent *interpScope
+	yield  func(any) bool // set in the scope of a running generator
+}
+
+func newInterpScope(parent *interpScope) *interpScope {
+	return &interpScope{vars: map[string]any{}, parent: parent}
+}
+
+func (s *interpScope) lookup(name string) (*interpScope, bool) {
+	for cur := s; cur != nil; cur = cur.parent {
+		if _, ok := cur.vars[name]; ok {
+			return cur, true
+		}
+	}
+	return nil, false
+}
+
+func (s *interpScope) get(name string) (any, bool) {
+	if owner, ok := s.lookup(name); ok {
+		return owner.vars[name], true
+	}
+	return nil, false
+}
+
+// set assigns to an existing variable, or declares it in this scope
+func (s *interpScope) set(name string, value any) {
+	if owner, ok := s.lookup(name); ok {
+		owner.vars[name] = value
+		return
+	}
+	s.vars[name] = value
+}
+
+// interpParam is one declared function parameter
+type interpParam struct {
+	name     string
+	typeName string
+}
+
+// Interpreter runs an OSL AST directly, using the same std.go helpers compiled
+// programs do, so it needs no Go toolchain.
+type Interpreter struct {
+	globals     *interpScope
+	commands    map[string]any
+	typeMethods map[string]map[string]any
+	constants   map[string]bool
+	returnValue any
+	self        any
+	hasSelf     bool
+	line        int
+	start       time.Time
+
+	// undefinedNull makes unknown variables null instead of an error, for #if conditions
+	undefinedNull bool
+}
+
+func NewInterpreter() *Interpreter {
+	return &Interpreter{
+		globals:     newInterpScope(nil),
+		commands:    map[string]any{},
+		typeMethods: map[string]map[string]any{},
+		constants:   map[string]bool{},
+		start:       time.Now(),
+	}
+}
+
+// Run executes a program, returning the first runtime error
+func (in *Interpreter) Run(ast [][]*Token) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = in.wrapError(r)
+		}
+	}()
+
+	var mainFunc []*Token
+	var body [][]*Token
+	for _, line := range ast {
+		if len(line) == 0 {
+			continue
+		}
+		// fail before anything runs rather than halfway through the program
+		if line[0].Type == TKN_CMD && (line[0].Data == "mainloop:" || line[0].Data == "mainloop" || line[0].Data == "import") {
+			in.execLine(line, in.globals)
+		}
+		if isMainDefinition(line) {
+			mainFunc = line
+			continue
+		}
+		body = append(body, line)
+	}
+
+	// functions can be called before the line that defines them
+	for _, line := range body {
+		if isFunctionDefinition(line) {
+			in.execLine(line, in.globals)
+		}
+	}
+
+	for _, line := range body {
+		if isFunctionDefinition(line) {
+			continue
+		}
+		if in.execLine(line, in.globals) == flowReturn {
+			return nil
+		}
+	}
+
+	if mainFunc != nil {
+		in.execLine(mainFunc, in.globals)
+		if fn, ok := in.globals.get("main"); ok {
+			in.call(fn, nil, false, nil)
+		}
+	}
+	return nil
+}
+
+// isMainDefinition reports whether a line defines main, which runs after the top level
+// code. def main is parsed into main = function, older asts kept the def command.
+func isMainDefinition(line []*Token) bool {
+	first := line[0]
+	if first.Type == TKN_CMD && first.Data == "def" {
+		return len(line) > 1 && line[1].Type == TKN_VAR && line[1].Data == "main"
+	}
+	return isFunctionDefinition(line) && first.Left.Data == "main"
+}
+
+func isFunctionDefinition(line []*Token) bool {
+	first := line[0]
+	if first.Type == TKN_CMD && first.Data == "def" {
+		return true
+	}
+	return first.Type == TKN_ASI && first.Data == "=" && first.Left != nil && first.Left.Type == TKN_VAR &&
+		first.Right != nil && first.Right.Type == TKN_FNC && first.Right.Data == "function"
+}
+
+func (in *Interpreter) wrapError(r any) error {
+	switch r := r.(type) {
+	case *interpError:
+		return r
+	case error:
+		return &interpError{line: in.line, msg: r.Error()}
+	default:
+		return &interpError{line: in.line, msg: fmt.Sprint(r)}
+	}
+}
+
+func (in *Interpreter) setLine(line []*Token) {
+	if len(line) > 0 && line[0].Line > 0 {
+		in.line = line[0].Line
+	}
+}
+
+func (in *Interpreter) execBlock(block [][]*Token, scope *interpScope) interpFlow {
+	for _, line := range block {
+		if flow := in.execLine(line, scope); flow != flowNext {
+			return flow
+		}
+	}
+	return flowNext
+}
+
+func (in *Interpreter) execLine(line []*Token, scope *interpScope) interpFlow {
+	if len(line) == 0 {
+		return flowNext
+	}
+	in.setLine(line)
+	defer func() {
+		if r := recover(); r != nil {
+			panic(in.wrapError(r))
+		}
+	}()
+
+	for _, token := range line {
+		if token.Type == TKN_MOD {
+			needsCompilation("drawing modifiers")
+		}
+	}
+
+	first := line[0]
+	switch first.Type {
+	case TKN_CMD:
+		return in.execCmd(line, scope)
+	case TKN_ASI:
+		in.assign(first, scope)
+		return flowNext
+	}
+	for _, token := range line {
+		in.eval(token, scope)
+	}
+	return flowNext
+}
+
+func blockOf(token *Token) [][]*Token {
+	if token == nil {
+		return nil
+	}
+	switch data := token.Data.(type) {
+	case [][]*Token:
+		return data
+	case []*Token:
+		return [][]*Token{data}
+	}
+	return nil
+}
+
+func (in *Interpreter) execCmd(cmd []*Token, scope *interpScope) interpFlow {
+	name, _ := cmd[0].Data.(string)
+	switch name {
+	case "//":
+		return flowNext
+	case "if":
+		if len(cmd) < 3 || cmd[2].Type != TKN_BLK {
+			panic("If command requires a block")
+		}
+		if OSLcastBool(in.eval(cmd[1], scope)) {
+			return in.execBlock(blockOf(cmd[2]), scope)
+		}
+		i := 3
+		for i < len(cmd) {
+			if i+3 < len(cmd) && cmd[i].Data == "else" && cmd[i+1].Data == "if" {
+				if OSLcastBool(in.eval(cmd[i+2], scope)) {
+					return in.execBlock(blockOf(cmd[i+3]), scope)
+				}
+				i += 4
+			} else if i+1 < len(cmd) && cmd[i].Data == "else" {
+				return in.execBlock(blockOf(cmd[i+1]), scope)
+			} else {
+				break
+			}
+		}
+	case "loop":
+		if len(cmd) < 3 {
+			panic("Loop command requires at least 1 parameter")
+		}
+		// each item iterable ( ... ) leaves out the index
+		if len(cmd) == 4 && cmd[1].Type == TKN_VAR && cmd[3].Type == TKN_BLK {
+			cmd = []*Token{cmd[0], {Type: TKN_VAR, Data: "_"}, cmd[1], cmd[2], cmd[3]}
+		}
+		if len(cmd) >= 5 && cmd[1].Type == TKN_VAR && cmd[2].Type == TKN_VAR {
+			indexVar := cmd[1].Data.(string)
+			itemVar := cmd[2].Data.(string)
+			var items iter.Seq2[int, any]
+			if cmd[3].Type == TKN_OPR && cmd[3].Data == "to" {
+				items = OSLrange(in.eval(cmd[3].Left, scope), in.eval(cmd[3].Right, scope))
+			} else {
+				items = OSLiterate(in.eval(cmd[3], scope))
+			}
+			for i, item := range items {
+				if indexVar != "_" {
+					scope.set(indexVar, i)
+				}
+				scope.set(itemVar, item)
+				if stop, flow := loopFlow(in.execBlock(blockOf(cmd[4]), scope)); stop {
+					return flow
+				}
+			}
+			break
+		}
+		count := OSLcastNumber(in.eval(cmd[1], scope))
+		for i := 1; float64(i) <= count; i++ {
+			if stop, flow := loopFlow(in.execBlock(blockOf(cmd[2]), scope)); stop {
+				return flow
+			}
+		}
+	case "for":
+		if len(cmd) < 4 {
+			panic("For command requires at least 2 parameters")
+		}
+		iteratorVar, _ := cmd[1].Data.(string)
+		count := OSLcastNumber(in.eval(cmd[2], scope))
+		for i := 1; float64(i) <= count; i++ {
+			scope.set(iteratorVar, i)
+			if stop, flow := loopFlow(in.execBlock(blockOf(cmd[3]), scope)); stop {
+				return flow
+			}
+		}
+	case "while":
+		if len(cmd) < 3 || cmd[2].Type != TKN_BLK {
+			panic("While command requires a block")
+		}
+		for OSLcastBool(in.eval(cmd[1], scope)) {
+			if stop, flow := loopFlow(in.execBlock(blockOf(cmd[2]), scope)); stop {
+				return flow
+			}
+		}
+	case "break":
+		return flowBreak
+	case "continue":
+		return flowContinue
+	case "return":
+		in.returnValue = nil
+		if len(cmd) > 1 && scope.yield != nil {
+			panic("Generators cannot return a value, yield it instead")
+		}
+		if len(cmd) > 1 {
+			in.returnValue = in.eval(cmd[1], scope)
+		}
+		return flowReturn
+	case "yield":
+		if scope.yield == nil {
+			panic("yield can only be used inside a function")
+		}
+		var value any
+		if len(cmd) > 1 {
+			value = in.eval(cmd[1], scope)
+		}
+		// the loop consuming the generator stopped, so the generator stops too
+		if !scope.yield(value) {
+			return flowReturn
+		}
+	case "log", "say":
+		if len(cmd) < 2 {
+			panic("Log command requires at least 1 parameter")
+		}
+		values := make([]any, 0, len(cmd)-1)
+		for _, param := range cmd[1:] {
+			values = append(values, in.eval(param, scope))
+		}
+		OSLlogValues(values...)
+	case "wait":
+		if len(cmd) == 2 {
+			OSLwait(OSLcastNumber(in.eval(cmd[1], scope)))
+		}
+	case "switch":
+		if len(cmd) < 3 {
+			panic("Switch command requires at least 2 parameters")
+		}
+		return in.execSwitch(in.eval(cmd[1], scope), blockOf(cmd[2]), scope)
+	case "def":
+		in.define(cmd, scope)
+	case "void":
+		for _, param := range cmd[1:] {
+			in.eval(param, scope)
+		}
+	case "mainloop:", "mainloop":
+		needsCompilation("mainloop")
+	case "embed":
+		// embedded files are read straight from disk, only as <name> needs doing
+		if embed := parseEmbed(cmd); embed.name != "" {
+			data, err := os.ReadFile(embed.path)
+			if err != nil {
+				panic(fmt.Sprintf("embed %q: %v", embed.path, err))
+			}
+			scope.set(embed.name, string(data))
+		}
+	case "import":
+		if len(cmd) > 1 {
+			needsCompilation(fmt.Sprintf("import %v", cmd[1].Data))
+		}
+		needsCompilation("import")
+	case "bench":
+		// bench blocks only run under osl bench
+	case "fuzz":
+		// fuzz blocks only run under osl fuzz
+	case "type":
+		needsCompilation("type")
+	case "go":
+		needsCompilation("go")
+	case "defer":
+		needsCompilation("defer")
+	case "window", "c", "color", "colour", "goto", "change_x", "change_y", "change", "loc",
+		"square", "icon", "text", "direction", "turnright", "turnleft", "pointat":
+		needsCompilation("drawing command " + name)
+	default:
+		args := make([]any, 0, len(cmd)-1)
+		for _, param := range cmd[1:] {
+			args = append(args, in.eval(param, scope))
+		}
+		if fn, ok := in.commands[name]; ok {
+			in.call(fn, nil, false, args)
+			break
+		}
+		if fn, ok := scope.get(name); ok {
+			in.call(fn, nil, false, args)
+			break
+		}
+		panic("Unknown command: " + name)
+	}
+	return flowNext
+}
+
+// loopFlow reports whether a loop should stop after its body left with flow,
+// and the flow the loop itself leaves with
+func loopFlow(flow interpFlow) (bool, interpFlow) {
+	switch flow {
+	case flowNext, flowContinue:
+		return false, flowNext
+	case flowBreak:
+		return true, flowNext
+	}
+	return true, flow
+}
+
+func (in *Interpreter) execSwitch(value any, block [][]*Token, scope *interpScope) interpFlow {
+	start := -1
+	for i, line := range block {
+		if len(line) == 0 || line[0].Type != TKN_CMD {
+			continue
+		}
+		if line[0].Data == "case" && len(line) > 1 && interpStrictEqual(value, in.eval(line[1], scope)) {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		for i, line := range block {
+			if len(line) > 0 && line[0].Type == TKN_CMD && line[0].Data == "default" {
+				start = i
+				break
+			}
+		}
+	}
+	if start == -1 {
+		return flowNext
+	}
+	for _, line := range block[start+1:] {
+		if len(line) > 0 && line[0].Type == TKN_CMD && (line[0].Data == "case" || line[0].Data == "default") {
+			break
+		}
+		switch flow := in.execLine(line, scope); flow {
+		case flowNext:
+		case flowBreak:
+			return flowNext
+		default:
+			return flow
+		}
+	}
+	return flowNext
+}
+
+// define handles def name(params) ( ... ) and def "command" params ( ... )
+func (in *Interpreter) define(cmd []*Token, scope *interpScope) {
+	if len(cmd) < 2 {
+		panic("Def command requires at least 1 parameter")
+	}
+	var params []interpParam
+	var body [][]*Token
+	if len(cmd) > 3 {
+		params = parseInterpParams(cmd[2])
+		body = blockOf(cmd[3])
+	} else if len(cmd) > 2 {
+		body = blockOf(cmd[2])
+	}
+	fn := in.function(params, body, scope)
+
+	name, _ := cmd[1].Data.(string)
+	if cmd[1].Type == TKN_STR {
+		in.commands[name] = fn
+		return
+	}
+	scope.set(name, fn)
+}
+
+func parseInterpParams(token *Token) []interpParam {
+	var raw []string
+	switch token.Type {
+	case TKN_STR, TKN_VAR:
+		raw = strings.Split(token.Data.(string), ",")
+	case TKN_MTV:
+		for _, field := range token.Data.([]*Token) {
+			if s, ok := field.Data.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	}
+	var params []interpParam
+	for _, arg := range raw {
+		parts := strings.Fields(arg)
+		switch len(parts) {
+		case 0:
+			continue
+		case 1:
+			params = append(params, interpParam{name: parts[0]})
+		default:
+			params = append(params, interpParam{
+				name:     parts[len(parts)-1],
+				typeName: strings.Join(parts[:len(parts)-1], " "),
+			})
+		}
+	}
+	return params
+}
+
+// lambdaParams reads the parameters of a def(...) -> ( ... ) or x -> ( ... ) function
+func lambdaParams(token *Token) []interpParam {
+	if len(token.Parameters) == 0 || token.Parameters[0] == nil {
+		return nil
+	}
+	params := parseInterpParams(token.Parameters[0])
+	if len(params) > 0 || token.Returns == "" {
+		return params
+	}
+	// (x, y) -> ( ... ) leaves its parameters where the return type goes
+	returns := strings.TrimSuffix(strings.TrimPrefix(token.Returns, "("), ")")
+	if _, isType := oslTypes[returns]; isType {
+		return nil
+	}
+	return parseInterpParams(&Token{Type: TKN_STR, Data: returns})
+}
+
+// function builds a callable Go value for an OSL function, so std.go helpers
+// like OSLsortBy and OSLcallFunc can call it like a compiled one
+func (in *Interpreter) function(params []interpParam, body [][]*Token, closure *interpScope) func(args ...any) any {
+	for _, param := range params {
+		base, _ := nullableBase(param.typeName)
+		switch base {
+		case "", "any", "auto", "string", "int", "number", "boolean", "array", "object":
+		default:
+			needsCompilation("parameter type " + param.typeName)
+		}
+	}
+	bind := func(args []any, self any, hasSelf bool) *interpScope {
+		scope := newInterpScope(closure)
+		if hasSelf {
+			scope.vars["self"] = self
+		}
+		for i, param := range params {
+			var arg any
+			if i < len(args) {
+				arg = args[i]
+			}
+			scope.vars[param.name] = interpCast(param.typeName, arg)
+		}
+		return scope
+	}
+	if containsYield(body) {
+		// generators run their body each time they are iterated, like compiled ones
+		return func(args ...any) any {
+			self, hasSelf := in.self, in.hasSelf
+			in.hasSelf = false
+			return iter.Seq[any](func(yield func(any) bool) {
+				scope := bind(args, self, hasSelf)
+				scope.yield = yield
+				line := in.line
+				in.execBlock(body, scope)
+				in.line = line
+				in.returnValue = nil
+			})
+		}
+	}
+	return func(args ...any) any {
+		scope := bind(args, in.self, in.hasSelf)
+		in.hasSelf = false
+		in.returnValue = nil
+		line := in.line
+		if in.execBlock(body, scope) != flowReturn {
+			in.returnValue = nil
+		}
+		in.line = line
+		result := in.returnValue
+		in.returnValue = nil
+		return result
+	}
+}
+
+// call runs a function value, binding self when it is called as a method
+func (in *Interpreter) call(fn any, self any, hasSelf bool, args []any) any {
+	switch f := fn.(type) {
+	case func(args ...any) any:
+		in.self, in.hasSelf = self, hasSelf
+		return f(args...)
+	case nil:
+		panic("Cannot call null")
+	}
+	if reflect.TypeOf(fn).Kind() != reflect.Func {
+		panic("Cannot call " + OSLtypeof(fn))
+	}
+	return OSLcallFunc(fn, nil, args)
+}
+
+// interpCast converts a value to a declared OSL type the way typed variables are compiled
+func interpCast(typeName string, value any) any {
+	if base, nullable := nullableBase(typeName); nullable {
+		if value == nil {
+			return nil
+		}
+		typeName = base
+	}
+	switch typeName {
+	case "string":
+		return OSLtoString(value)
+	case "int":
+		return OSLcastInt(value)
+	case "number":
+		return OSLcastNumber(value)
+	case "boolean":
+		return OSLcastBool(value)
+	case "array":
+		return OSLcastArray(value)
+	case "object":
+		return OSLcastObject(value)
+	}
+	return value
+}
+
+func (in *Interpreter) assign(token *Token, scope *interpScope) {
+	op, _ := token.Data.(string)
+	if op == "=??" {
+		in.eval(token.Right, scope)
+		return
+	}
+
+	switch token.Left.Type {
+	case TKN_VAR:
+		name := token.Left.Data.(string)
+		if name == "self" || strings.HasPrefix(name, "OSL") {
+			panic("Cannot use reserved variable name: " + name)
+		}
+		if in.constants[name] {
+			panic("Cannot assign to constant " + name)
+		}
+		if token.SetType == "const" {
+			in.constants[name] = true
+			scope.set(name, in.eval(token.Right, scope))
+			return
+		}
+		if token.Right != nil && token.Right.Type == TKN_FNC && token.Right.Data == "function" && op == "=" {
+			scope.set(name, in.function(lambdaParams(token.Right), blockOf(token.Right.Parameters[1]), scope))
+			return
+		}
+		current, exists := scope.get(name)
+		if token.Local {
+			current, exists = scope.vars[name]
+		}
+		value := in.applyAssign(op, current, exists, token.Right, scope)
+		if token.SetType != "" {
+			if base, _ := nullableBase(token.SetType); oslTypes[base] == "" && base != "any" {
+				needsCompilation("type " + token.SetType)
+			}
+			value = interpCast(token.SetType, value)
+		}
+		if token.Local {
+			scope.vars[name] = value
+			return
+		}
+		scope.set(name, value)
+	case TKN_RMT:
+		path := token.Left.ObjPath
+		if len(path) == 0 {
+			panic("Invalid assignment target")
+		}
+		if op == "@=" && path[0].Type == TKN_VAR {
+			if typeName, ok := path[0].Data.(string); ok {
+				if _, isType := oslTypes[typeName]; isType && token.Right.Type == TKN_FNC {
+					in.defineTypeMethod(typeName, token.Left.Final, token.Right, scope)
+					return
+				}
+			}
+		}
+		parent := in.evalChain(path[:len(path)-1], scope)
+		var key any
+		final := token.Left.Final
+		if final.Type == TKN_MTV && final.Data == "item" && len(final.Parameters) > 0 {
+			key = in.eval(final.Parameters[0], scope)
+		} else if name, ok := final.Data.(string); ok && final.Type == TKN_VAR {
+			key = name
+		} else {
+			key = in.eval(final, scope)
+		}
+		current := OSLgetItem(parent, key)
+		value := in.applyAssign(op, current, current != nil, token.Right, scope)
+		if !OSLsetItem(parent, key, value) {
+			panic(fmt.Sprintf("Cannot set %v on %v", OSLtoString(key), OSLtypeof(parent)))
+		}
+	default:
+		panic("Invalid assignment target")
+	}
+}
+
+// applyAssign works out the new value of a variable for an assignment operator
+func (in *Interpreter) applyAssign(op string, current any, exists bool, right *Token, scope *interpScope) any {
+	switch op {
+	case "=", ":=", "@=":
+		return in.eval(right, scope)
+	case "++":
+		return interpArith("+", current, 1)
+	case "--":
+		return interpArith("-", current, 1)
+	case "??=":
+		if exists && current != nil {
+			return current
+		}
+		return in.eval(right, scope)
+	case "++=":
+		return interpConcat(current, in.eval(right, scope))
+	case "+=":
+		value := in.eval(right, scope)
+		if _, ok := current.(string); ok {
+			return OSLtoString(current) + " " + OSLtoString(value)
+		}
+		if arr, ok := current.([]any); ok {
+			return append(arr, OSLcastArray(value)...)
+		}
+		return interpArith("+", current, value)
+	case "-=", "*=", "/=", "%=", "^=":
+		return interpArith(strings.TrimSuffix(op, "="), current, in.eval(right, scope))
+	}
+	panic("Unknown assignment operator: " + op)
+}
+
+func (in *Interpreter) defineTypeMethod(typeName string, final *Token, fn *Token, scope *interpScope) {
+	name, _ := final.Data.(string)
+	if in.typeMethods[typeName] == nil {
+		in.typeMethods[typeName] = map[string]any{}
+	}
+	in.typeMethods[typeName][name] = in.function(lambdaParams(fn), blockOf(fn.Parameters[1]), scope)
+}
+
+// typeMethod finds a method added to a builtin type with type.@name = def() -> ( ... )
+func (in *Interpreter) typeMethod(value any, name string) (any, bool) {
+	typeName := OSLtypeof(value)
+	switch typeName {
+	case "any":
+		return nil, false
+	case "int":
+		if fn, ok := in.typeMethods["int"][name]; ok {
+			return fn, true
+		}
+		typeName = "number"
+	}
+	fn, ok := in.typeMethods[typeName][name]
+	return fn, ok
+}
+
+func (in *Interpreter) eval(token *Token, scope *interpScope) any {
+	if token == nil {
+		return nil
+	}
+	switch token.Type {
+	case TKN_NUM:
+		n := token.Data.(float64)
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int(n)
+		}
+		return n
+	case TKN_STR:
+		return token.Data
+	case TKN_RAW:
+		if b, ok := token.Data.(bool); ok {
+			return b
+		}
+		needsCompilation("raw Go code")
+	case TKN_VAR:
+		if token.NonNull {
+			return OSLnonNull(in.variable(token.Data.(string), scope), token.Data.(string))
+		}
+		return in.variable(token.Data.(string), scope)
+	case TKN_ARR:
+		items, _ := token.Data.([]*Token)
+		out := make([]any, 0, len(items))
+		for _, item := range items {
+			if item.Type == TKN_SPR {
+				out = append(out, OSLcastArray(in.eval(item.Data.(*Token), scope))...)
+				continue
+			}
+			out = append(out, in.eval(item, scope))
+		}
+		return out
+	case TKN_OBJ:
+		out := map[string]any{}
+		pairs, _ := token.Data.([][]*Token)
+		for _, pair := range pairs {
+			if len(pair) == 0 {
+				continue
+			}
+			if pair[0].Type == TKN_SPR {
+				for k, v := range OSLcastObject(in.eval(pair[0].Data.(*Token), scope)) {
+					out[k] = v
+				}
+				continue
+			}
+			var key string
+			if pair[0].Type == TKN_VAR {
+				key = pair[0].Data.(string)
+			} else {
+				key = OSLtoString(in.eval(pair[0], scope))
+			}
+			if len(pair) > 1 {
+				out[key] = in.eval(pair[1], scope)
+			} else {
+				out[key] = in.variable(key, scope)
+			}
+		}
+		return out
+	case TKN_TSR:
+		var out strings.Builder
+		for _, part := range token.Data.([]*Token) {
+			out.WriteString(OSLtoString(in.eval(part, scope)))
+		}
+		return out.String()
+	case TKN_EVL:
+		return in.eval(token.Data.(*Token), scope)
+	case TKN_BLK:
+		var result any
+		for _, line := range blockOf(token) {
+			if len(line) == 1 && line[0].Type != TKN_CMD && line[0].Type != TKN_ASI {
+				result = in.eval(line[0], scope)
+				continue
+			}
+			in.execLine(line, scope)
+		}
+		return result
+	case TKN_OPR:
+		return in.operator(token, scope)
+	case TKN_CMP:
+		return in.compare(token, scope)
+	case TKN_LOG:
+		return in.logic(token, scope)
+	case TKN_BIT:
+		left := OSLcastInt(in.eval(token.Left, scope))
+		right := OSLcastInt(in.eval(token.Right, scope))
+		switch token.Data {
+		case "&":
+			return left & right
+		case "|":
+			return left | right
+		case "<<":
+			return left << right
+		case ">>":
+			return left >> right
+		case "^^":
+			return OSLxor(left, right)
+		}
+		panic(fmt.Sprintf("Unknown bitwise operator: %v", token.Data))
+	case TKN_URY:
+		value := in.eval(token.Right, scope)
+		switch token.Data {
+		case "!":
+			return !OSLcastBool(value)
+		case "-":
+			if n, ok := value.(int); ok {
+				return -n
+			}
+			return -OSLcastNumber(value)
+		case "+":
+			return value
+		case "@":
+			needsCompilation("pointers")
+		}
+		panic(fmt.Sprintf("Unknown unary operator: %v", token.Data))
+	case TKN_QST:
+		if token.Left == nil || token.Right == nil || token.Right2 == nil {
+			return nil
+		}
+		if OSLcastBool(in.eval(token.Left, scope)) {
+			return in.eval(token.Right, scope)
+		}
+		return in.eval(token.Right2, scope)
+	case TKN_RMT:
+		path, _ := token.Data.([]*Token)
+		var parent any
+		if len(path) > 0 {
+			parent = in.eval(path[0], scope)
+		}
+		final := token.Final
+		if final != nil && final.Type == TKN_MTV && final.Data == "item" && len(final.Parameters) > 0 {
+			return OSLgetItem(parent, in.eval(final.Parameters[0], scope))
+		}
+		return OSLgetItem(parent, in.eval(final, scope))
+	case TKN_FNC:
+		return in.functionCall(token, scope)
+	case TKN_MTD:
+		parts, _ := token.Data.([]*Token)
+		return in.evalChain(parts, scope)
+	case TKN_UNK:
+		if data, ok := token.Data.(string); ok {
+			if msg, isErr := strings.CutPrefix(data, "error: "); isErr {
+				panic(msg)
+			}
+			if value, ok := scope.get(data); ok {
+				return value
+			}
+			return data
+		}
+		return nil
+	}
+	panic(fmt.Sprintf("Cannot evaluate %v", token.Type))
+}
+
+func (in *Interpreter) variable(name string, scope *interpScope) any {
+	switch name {
+	case "null":
+		return nil
+	case "timestamp":
+		return float64(time.Now().UnixMilli())
+	case "performance":
+		return float64(time.Now().UnixMicro())
+	case "timer":
+		return time.Since(in.start).Seconds()
+	case "origin":
+		return map[string]any{"version": OSL_VERSION, "isGosl": true, "interpreted": true}
+	}
+	if value, ok := scope.get(name); ok {
+		return value
+	}
+	if fn, ok := in.commands[name]; ok {
+		return fn
+	}
+	if strings.HasPrefix(name, "OSL") {
+		panic("Cannot use reserved variable name: " + name)
+	}
+	if in.undefinedNull {
+		return nil
+	}
+	panic("Undefined variable: " + name)
+}
+
+// evalChain evaluates a.b.c(...) style method and property chains. Methods that
+// change an array in place, like append and pop, write the result back to where
+// the array came from.
+func (in *Interpreter) evalChain(parts []*Token, scope *interpScope) any {
+	if len(parts) == 0 {
+		return nil
+	}
+	cur := in.eval(parts[0], scope)
+	var store func(any)
+	if name, ok := parts[0].Data.(string); ok && parts[0].Type == TKN_VAR {
+		store = func(v any) { scope.set(name, v) }
+	}
+
+	for i, part := range parts[1:] {
+		if prev := parts[i]; i > 0 && prev.NonNull {
+			cur = OSLnonNull(cur, prev.Data.(string))
+		}
+		// a ?. skips the rest of the chain when the value is null
+		if part.Optional && OSLisNull(cur) {
+			return nil
+		}
+		name, _ := part.Data.(string)
+		switch part.Type {
+		case TKN_VAR:
+			if name == "len" {
+				cur = OSLlen(cur)
+				store = nil
+				continue
+			}
+			if fn, ok := in.typeMethod(cur, name); ok {
+				cur = in.call(fn, cur, true, nil)
+				store = nil
+				continue
+			}
+			parent, key := cur, name
+			cur = OSLgetItem(parent, key)
+			store = func(v any) { OSLsetItem(parent, key, v) }
+		case TKN_MTV:
+			args := make([]any, len(part.Parameters))
+			for i, p := range part.Parameters {
+				args[i] = in.eval(p, scope)
+			}
+			if name == "item" && len(args) > 0 {
+				parent, key := cur, args[0]
+				cur = OSLgetItem(parent, key)
+				store = func(v any) { OSLsetItem(parent, key, v) }
+				continue
+			}
+			cur = in.method(cur, name, args, store)
+			store = nil
+		default:
+			panic(fmt.Sprintf("Cannot use %v in a method chain", part.Type))
+		}
+	}
+	if last := parts[len(parts)-1]; len(parts) > 1 && last.NonNull {
+		cur = OSLnonNull(cur, last.Data.(string))
+	}
+	return cur
+}
+
+func interpArg(args []any, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// method runs one of the builtin type methods the compiler knows about
+func (in *Interpreter) method(value any, name string, args []any, store func(any)) any {
+	if fn, ok := in.typeMethod(value, name); ok {
+		return in.call(fn, value, true, args)
+	}
+
+	mutate := func(v any) {
+		if store == nil {
+			panic("Cannot use ." + name + "() on a value that is not stored in a variable")
+		}
+		store(v)
+	}
+
+	switch name {
+	case "call":
+		return in.call(value, nil, false, args)
+	case "toStr":
+		return OSLtoString(value)
+	case "toInt":
+		return OSLcastInt(value)
+	case "toNum":
+		return OSLcastNumber(value)
+	case "toBool":
+		return OSLcastBool(value)
+	case "toArray":
+		return OSLcastArray(value)
+	case "toObject":
+		return OSLcastObject(value)
+	case "pop":
+		arr := OSLcastArray(value)
+		item := OSLpop(&arr)
+		mutate(arr)
+		return item
+	case "shift":
+		arr := OSLcastArray(value)
+		item := OSLshift(&arr)
+		mutate(arr)
+		return item
+	case "append":
+		arr := OSLcastArray(value)
+		OSLappend(&arr, interpArg(args, 0))
+		mutate(arr)
+		return arr
+	case "prepend":
+		arr := OSLcastArray(value)
+		OSLprepend(&arr, interpArg(args, 0))
+		mutate(arr)
+		return arr
+	case "in":
+		return OSLKeyIn(interpArg(args, 0), value)
+	case "ask":
+		return input(OSLtoString(value))
+	case "chr":
+		return string(rune(OSLcastInt(value)))
+	case "ord":
+		s := OSLtoString(value)
+		if s == "" {
+			return 0
+		}
+		return int(s[0])
+	case "toLower":
+		return strings.ToLower(OSLtoString(value))
+	case "toUpper":
+		return strings.ToUpper(OSLtoString(value))
+	case "getKeys":
+		return OSLgetKeys(value)
+	case "getValues":
+		return OSLgetValues(value)
+	case "floor":
+		return OSLfloor(interpNumber(value))
+	case "ceil":
+		return OSLceil(interpNumber(value))
+	case "round":
+		return OSLround(interpNumber(value))
+	case "startsWith":
+		return strings.HasPrefix(OSLtoString(value), OSLtoString(interpArg(args, 0)))
+	case "endsWith":
+		return strings.HasSuffix(OSLtoString(value), OSLtoString(interpArg(args, 0)))
+	case "contains":
+		return OSLcontains(value, interpArg(args, 0))
+	case "sort":
+		return OSLsort(OSLcastArray(value))
+	case "sortBy":
+		return OSLsortBy(OSLcastArray(value), interpArg(args, 0))
+	case "index":
+		return strings.Index(OSLtoString(value), OSLtoString(interpArg(args, 0))) + 1
+	case "strip":
+		return strings.TrimSpace(OSLtoString(value))
+	case "clone":
+		return OSLclone(value)
+	case "join":
+		if len(args) > 0 {
+			return OSLarrayJoin(value, args[0])
+		}
+		// with nothing to join with, arrays and strings stay as they are, like in compiled code
+		switch value.(type) {
+		case []any, string:
+			return value
+		}
+	case "map":
+		arr := OSLcastArray(value)
+		out := make([]any, len(arr))
+		for i, item := range arr {
+			out[i] = in.call(interpArg(args, 0), nil, false, []any{item})
+		}
+		return out
+	case "reverse":
+		if str, ok := value.(string); ok {
+			runes := []rune(str)
+			slices.Reverse(runes)
+			return string(runes)
+		}
+		arr := slices.Clone(OSLcastArray(value))
+		slices.Reverse(arr)
+		return arr
+	case "fill":
+		arr := make([]any, len(OSLcastArray(value)))
+		for i := range arr {
+			arr[i] = interpArg(args, 0)
+		}
+		return arr
+	case "concat":
+		if arr, ok := value.([]any); ok {
+			if other, ok := interpArg(args, 0).([]any); ok {
+				return append(slices.Clone(arr), other...)
+			}
+		}
+		return OSLtoString(value) + OSLtoString(interpArg(args, 0))
+	case "count":
+		if arr, ok := value.([]any); ok {
+			count := 0
+			for _, item := range arr {
+				if OSLequal(item, interpArg(args, 0)) {
+					count++
+				}
+			}
+			return count
+		}
+		sub := OSLtoString(interpArg(args, 0))
+		if sub == "" {
+			return 0
+		}
+		return strings.Count(OSLtoString(value), sub)
+	case "split":
+		return OSLSplit(OSLtoString(value), OSLtoString(interpArg(args, 0)))
+	case "replace":
+		return OSLreplace(OSLtoString(value), OSLtoString(interpArg(args, 0)), OSLtoString(interpArg(args, 1)))
+	case "replaceFirst":
+		return OSLreplaceFirst(OSLtoString(value), OSLtoString(interpArg(args, 0)), OSLtoString(interpArg(args, 1)))
+	case "delete":
+		result := OSLdelete(value, interpArg(args, 0))
+		if store != nil {
+			store(result)
+		}
+		return result
+	case "slice":
+		end := -1
+		if len(args) > 1 {
+			end = OSLcastInt(args[1])
+		}
+		return OSLslice(value, OSLcastInt(interpArg(args, 0)), end)
+	case "sign":
+		return OSLsign(value)
+	case "trim":
+		if len(args) == 0 {
+			return strings.TrimSpace(OSLtoString(value))
+		}
+		to := -1
+		if len(args) > 1 {
+			to = OSLcastInt(args[1])
+		}
+		if arr, ok := value.([]any); ok {
+			return OSLtrim(arr, OSLcastInt(args[0]), to)
+		}
+		return OSLtrim(OSLtoString(value), OSLcastInt(args[0]), to)
+	case "JsonStringify":
+		return JsonStringify(value)
+	case "JsonParse":
+		return interpUsable(JsonParse(OSLtoString(value)))
+	case "JsonFormat":
+		return JsonFormat(value)
+	case "stripStart":
+		return strings.TrimPrefix(OSLtoString(value), OSLtoString(interpArg(args, 0)))
+	case "stripEnd":
+		return strings.TrimSuffix(OSLtoString(value), OSLtoString(interpArg(args, 0)))
+	case "padStart":
+		return OSLpadStart(OSLtoString(value), OSLcastInt(interpArg(args, 1)), OSLtoString(interpArg(args, 0)))
+	case "padEnd":
+		return OSLpadEnd(OSLtoString(value), OSLcastInt(interpArg(args, 1)), OSLtoString(interpArg(args, 0)))
+	case "assert":
+		typeName := OSLtoString(interpArg(args, 0))
+		if OSLtypeof(value) != typeName && !(typeName == "number" && OSLtypeof(value) == "int") {
+			panic(fmt.Sprintf("assert: expected %v, got %v", typeName, OSLtypeof(value)))
+		}
+		return value
+	case "sin":
+		return math.Sin((OSLcastNumber(value) * math.Pi) / 180)
+	case "cos":
+		return math.Cos((OSLcastNumber(value) * math.Pi) / 180)
+	case "tan":
+		return math.Tan((OSLcastNumber(value) * math.Pi) / 180)
+	case "clamp":
+		return OSLmin(OSLmax(OSLcastNumber(value), OSLcastNumber(interpArg(args, 0))), OSLcastNumber(interpArg(args, 1)))
+	case "abs":
+		return math.Abs(OSLcastNumber(value))
+	case "sqrt":
+		return math.Sqrt(OSLcastNumber(value))
+	}
+
+	// objects can hold their own methods, which get the object as self
+	if obj, ok := value.(map[string]any); ok {
+		if fn, ok := obj[name]; ok && OSLisFunc(fn) {
+			return in.call(fn, obj, true, args)
+		}
+	}
+	needsCompilation(fmt.Sprintf("method .%v() on %v", name, OSLtypeof(value)))
+	return nil
+}
+
+// functionCall handles name(args) calls, including the builtin functions
+func (in *Interpreter) functionCall(token *Token, scope *interpScope) any {
+	name, _ := token.Data.(string)
+	if name == "function" {
+		var body [][]*Token
+		if len(token.Parameters) > 1 {
+			body = blockOf(token.Parameters[1])
+		}
+		return in.function(lambdaParams(token), body, scope)
+	}
+
+	args := make([]any, len(token.Parameters))
+	for i, p := range token.Parameters {
+		args[i] = in.eval(p, scope)
+	}
+
+	// user functions can shadow builtins, the same as in compiled code
+	if fn, ok := scope.get(name); ok {
+		return in.call(fn, nil, false, args)
+	}
+
+	switch name {
+	case "typeof":
+		return OSLtypeof(interpArg(args, 0))
+	case "round":
+		return OSLround(interpNumber(interpArg(args, 0)))
+	case "ceil":
+		return OSLceil(interpNumber(interpArg(args, 0)))
+	case "floor":
+		return OSLfloor(interpNumber(interpArg(args, 0)))
+	case "min":
+		return OSLmin(OSLcastNumber(interpArg(args, 0)), OSLcastNumber(interpArg(args, 1)))
+	case "max":
+		return OSLmax(OSLcastNumber(interpArg(args, 0)), OSLcastNumber(interpArg(args, 1)))
+	case "random":
+		low, high := interpArg(args, 0), interpArg(args, 1)
+		_, lowInt := low.(int)
+		_, highInt := high.(int)
+		if lowInt && highInt {
+			return OSLrandom(low.(int), high.(int))
+		}
+		return OSLrandom(OSLcastNumber(low), OSLcastNumber(high))
+	case "delete":
+		return OSLdelete(interpArg(args, 0), interpArg(args, 1))
+	case "string", "int", "number", "boolean", "object", "array":
+		return interpCast(name, interpArg(args, 0))
+	case "input":
+		return input(OSLtoString(interpArg(args, 0)))
+	case "dist":
+		return dist(OSLcastNumber(interpArg(args, 0)), OSLcastNumber(interpArg(args, 1)), OSLcastNumber(interpArg(args, 2)), OSLcastNumber(interpArg(args, 3)))
+	case "encodeURIComponent":
+		return encodeURIComponent(OSLtoString(interpArg(args, 0)))
+	case "decodeURIComponent":
+		return decodeURIComponent(OSLtoString(interpArg(args, 0)))
+	case "atob":
+		return atob(OSLtoString(interpArg(args, 0)))
+	case "btoa":
+		return btoa(OSLtoString(interpArg(args, 0)))
+	case "raw":
+		needsCompilation("raw Go code")
+	case "worker", "workerPool":
+		needsCompilation(name)
+	}
+	if _, isType := oslTypes[name]; isType {
+		needsCompilation("type " + name)
+	}
+	panic("Undefined function: " + name)
+}
+
+func (in *Interpreter) operator(token *Token, scope *interpScope) any {
+	if token.Data == "//" {
+		return nil
+	}
+	op, _ := token.Data.(string)
+	left := in.eval(token.Left, scope)
+	if op == "??" {
+		if left != nil {
+			return left
+		}
+		return in.eval(token.Right, scope)
+	}
+	right := in.eval(token.Right, scope)
+	switch op {
+	case "++":
+		return interpConcat(left, right)
+	case "to":
+		return OSLnewRange(left, right)
+	}
+	return interpArith(op, left, right)
+}
+
+// interpNumber keeps ints as ints and turns everything else into a float64
+func interpNumber(v any) any {
+	if n, ok := v.(int); ok {
+		return n
+	}
+	return OSLcastNumber(v)
+}
+
+// interpArith applies an arithmetic operator with the result types compiled code gives
+func interpArith(op string, left any, right any) any {
+	_, leftInt := left.(int)
+	_, rightInt := right.(int)
+	_, leftStr := left.(string)
+	_, rightStr := right.(string)
+
+	switch op {
+	case "+":
+		if leftStr || rightStr {
+			return OSLtoString(left) + " " + OSLtoString(right)
+		}
+		if leftInt && rightInt {
+			return left.(int) + right.(int)
+		}
+		return OSLadd(left, right)
+	case "-":
+		if leftInt && rightInt {
+			return left.(int) - right.(int)
+		}
+		return OSLsub(left, right)
+	case "*":
+		if leftStr {
+			return OSLmultiply(left.(string), OSLcastNumber(right))
+		}
+		if leftInt && rightInt {
+			return OSLmultiply(left.(int), right.(int))
+		}
+		return OSLmultiply(OSLcastNumber(left), OSLcastNumber(right))
+	case "/":
+		return OSLdivide(left, right)
+	case "%":
+		if leftInt && rightInt {
+			return OSLmod(left.(int), right.(int))
+		}
+		return OSLmod(OSLcastNumber(left), OSLcastNumber(right))
+	case "^":
+		if leftInt {
+			return OSLpow(left.(int), OSLcastNumber(right))
+		}
+		return OSLpow(OSLcastNumber(left), OSLcastNumber(right))
+	}
+	panic("Unknown operator: " + op)
+}
+
+func interpConcat(left any, right any) any {
+	leftArr, leftIsArr := left.([]any)
+	rightArr, rightIsArr := right.([]any)
+	if leftIsArr && rightIsArr {
+		return OSLconcat(leftArr, rightArr)
+	}
+	return OSLtoString(left) + OSLtoString(right)
+}
+
+// interpStrictEqual compares like Go's == does on the compiled values, treating
+// ints and floats with the same value as equal
+func interpStrictEqual(a any, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	_, aInt := a.(int)
+	_, bInt := b.(int)
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	if (aInt || aFloat) && (bInt || bFloat) {
+		return OSLcastNumber(a) == OSLcastNumber(b)
+	}
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	if !ta.Comparable() {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	return a == b
+}
+
+func (in *Interpreter) compare(token *Token, scope *interpScope) any {
+	left := in.eval(token.Left, scope)
+	right := in.eval(token.Right, scope)
+	switch token.Data {
+	case "==":
+		return OSLequal(left, right)
+	case "!=":
+		return OSLnotEqual(left, right)
+	case "===":
+		return interpStrictEqual(left, right)
+	case "!==":
+		return !interpStrictEqual(left, right)
+	case ">":
+		return OSLcastNumber(left) > OSLcastNumber(right)
+	case "<":
+		return OSLcastNumber(left) < OSLcastNumber(right)
+	case ">=", "!<":
+		return OSLcastNumber(left) >= OSLcastNumber(right)
+	case "<=", "!>":
+		return OSLcastNumber(left) <= OSLcastNumber(right)
+	case "in":
+		return OSLKeyIn(left, right)
+	case "notIn":
+		return !OSLKeyIn(left, right)
+	}
+	panic(fmt.Sprintf("Unknown comparison: %v", token.Data))
+}
+
+func (in *Interpreter) logic(token *Token, scope *interpScope) any {
+	left := OSLcastBool(in.eval(token.Left, scope))
+	switch token.Data {
+	case "and":
+		return left && OSLcastBool(in.eval(token.Right, scope))
+	case "or":
+		return left || OSLcastBool(in.eval(token.Right, scope))
+	}
+	right := OSLcastBool(in.eval(token.Right, scope))
+	switch token.Data {
+	case "nor":
+		return !(left || right)
+	case "nand":
+		return !(left && right)
+	case "xor":
+		return left != right
+	case "xnor":
+		return left == right
+	}
+	panic(fmt.Sprintf("Unknown logic operator: %v", token.Data))
+}
+
+// interpUsable turns decoded JSON into the value types OSL programs use
+func interpUsable(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = interpUsable(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = interpUsable(item)
+		}
+		return v
+	}
+	if n, ok := v.(interface{ Float64() (float64, error) }); ok {
+		f, _ := n.Float64()
+		return interpNumber(f)
+	}
+	return v
+}
+
+// interpret runs an OSL script with the interpreter, printing any error
+func interpret(script string) {
+	var ast [][]*Token
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Println("Error:", r)
+				ast = nil
+			}
+		}()
+		ast = scriptToAst(script)
+	}()
+	if ast == nil {
+		return
+	}
+	if err := NewInterpreter().Run(ast); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	LEX_SPACE   = "space"
+	LEX_NEWLINE = "newline"
+	LEX_COMMENT = "comment"
+	LEX_STRING  = "string"
+	LEX_NUMBER  = "number"
+	LEX_WORD    = "word"
+	LEX_SYMBOL  = "symbol"
+)
+
+// Lexeme is one piece of source. Offset is in bytes from the start of the source,
+// Line and Column start at 1 and Column counts bytes, like go/token.
+// Joining the Text of every lexeme gives back the source exactly.
+type Lexeme struct {
+	Kind   string
+	Text   string
+	Offset int
+	Line   int
+	Column int
+}
+
+// Trivia reports whether the lexeme can be left out without changing what the code
+// means. Comments are kept as trivia so tools can read them.
+func (l Lexeme) Trivia() bool {
+	return l.Kind == LEX_SPACE || l.Kind == LEX_NEWLINE || l.Kind == LEX_COMMENT
+}
+
+// lexSymbols are the symbols longer than one character, longest first so the
+// longest one that matches is taken
+var lexSymbols = []string{
+	"===", "!==", "??=", "++=",
+	"==", "!=", "<=", ">=", "++", "+=", "-=", "*=", "/=", "%=", "^=", "@=",
+	"??", "?.", "->", "//", "<<", ">>", "^^", "!>", "!<",
+}
+
+type Lexer struct {
+	src    string
+	pos    int
+	line   int
+	column int
+	out    []Lexeme
+}
+
+// Lex splits source into lexemes in one pass.
+//
+// A // comment runs to the end of the line, whether it starts the line or follows code.
+// Strings use ", ' or ` quotes and may span lines, templates nest, so a backtick
+// inside ${} does not end the template. A quote with no closing quote is a symbol.
+func Lex(src string) []Lexeme {
+	lx := &Lexer{src: src, line: 1, column: 1, out: make([]Lexeme, 0, len(src)/3+1)}
+	for lx.pos < len(lx.src) {
+		lx.next()
+	}
+	return lx.out
+}
+
+func (lx *Lexer) next() {
+	start := lx.pos
+	c := lx.src[lx.pos]
+	kind := LEX_SYMBOL
+	end := start + 1
+
+	switch {
+	case c == '\n':
+		kind = LEX_NEWLINE
+	case c == ' ' || c == '\t' || c == '\r':
+		kind = LEX_SPACE
+		end = lx.scanWhile(start, func(r rune) bool { return r == ' ' || r == '\t' || r == '\r' })
+	case c == '/' && lx.peek(1) == '/':
+		kind = LEX_COMMENT
+		end = start + strings.IndexByte(lx.src[start:]+"\n", '\n')
+	case c == '/' && lx.peek(1) == '*':
+		kind = LEX_COMMENT
+		if close := strings.Index(lx.src[start+2:], "*/"); close >= 0 {
+			end = start + 2 + close + 2
+		} else {
+			end = len(lx.src)
+		}
+	case c == '"' || c == '\'' || c == '`':
+		if close := lx.scanString(start); close > 0 {
+			kind = LEX_STRING
+			end = close
+		}
+	case c >= '0' && c <= '9':
+		kind = LEX_NUMBER
+		end = lx.scanNumber(start)
+	case c == '_' || c >= utf8.RuneSelf || unicode.IsLetter(rune(c)):
+		r, _ := utf8.DecodeRuneInString(lx.src[start:])
+		if isWordRune(r) {
+			kind = LEX_WORD
+			end = lx.scanWhile(start, isWordRune)
+		} else {
+			_, size := utf8.DecodeRuneInString(lx.src[start:])
+			end = start + size
+		}
+	default:
+		for _, symbol := range lexSymbols {
+			if strings.HasPrefix(lx.src[start:], symbol) {
+				end = start + len(symbol)
+				break
+			}
+		}
+	}
+
+	text := lx.src[start:end]
+	lx.out = append(lx.out, Lexeme{Kind: kind, Text: text, Offset: start, Line: lx.line, Column: lx.column})
+	lx.advance(text)
+}
+
+// advance moves the position past text, counting the lines it spans
+func (lx *Lexer) advance(text string) {
+	lx.pos += len(text)
+	if newlines := strings.Count(text, "\n"); newlines > 0 {
+		lx.line += newlines
+		lx.column = len(text) - strings.LastIndexByte(text, '\n')
+	} else {
+		lx.column += len(text)
+	}
+}
+
+func (lx *Lexer) peek(ahead int) byte {
+	if lx.pos+ahead < len(lx.src) {
+		return lx.src[lx.pos+ahead]
+	}
+	return 0
+}
+
+func (lx *Lexer) scanWhile(i int, match func(rune) bool) int {
+	for i < len(lx.src) {
+		r, size := utf8.DecodeRuneInString(lx.src[i:])
+		if !match(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// scanString returns the end of the string starting at i, or 0 when it is never closed
+func (lx *Lexer) scanString(i int) int {
+	quote := lx.src[i]
+	for j := i + 1; j < len(lx.src); j++ {
+		switch c := lx.src[j]; {
+		case c == '\\':
+			j++
+		case c == quote:
+			return j + 1
+		case quote == '`' && c == '$' && j+1 < len(lx.src) && lx.src[j+1] == '{':
+			close := lx.scanTemplateExpr(j + 2)
+			if close == 0 {
+				return 0
+			}
+			j = close - 1
+		}
+	}
+	return 0
+}
+
+// scanTemplateExpr returns the end of the ${} expression whose body starts at i
+func (lx *Lexer) scanTemplateExpr(i int) int {
+	depth := 1
+	for j := i; j < len(lx.src); j++ {
+		switch lx.src[j] {
+		case '"', '\'', '`':
+			close := lx.scanString(j)
+			if close == 0 {
+				return 0
+			}
+			j = close - 1
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return 0
+}
+
+// scanNumber reads digits with _ separators, a fraction and an exponent, so 1e-3 is one number
+func (lx *Lexer) scanNumber(i int) int {
+	isDigit := func(r rune) bool { return r >= '0' && r <= '9' || r == '_' }
+	i = lx.scanWhile(i, isDigit)
+	if i+1 < len(lx.src) && lx.src[i] == '.' && lx.src[i+1] >= '0' && lx.src[i+1] <= '9' {
+		i = lx.scanWhile(i+1, isDigit)
+	}
+	if i < len(lx.src) && (lx.src[i] == 'e' || lx.src[i] == 'E') {
+		j := i + 1
+		if j < len(lx.src) && (lx.src[j] == '+' || lx.src[j] == '-') {
+			j++
+		}
+		if j < len(lx.src) && lx.src[j] >= '0' && lx.src[j] <= '9' {
+			i = lx.scanWhile(j, isDigit)
+		}
+	}
+	return lx.scanWhile(i, isWordRune)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// spacedSymbols are the operators the AST builder needs spaces around
+var spacedSymbols = map[string]bool{
+	"++": true, "??": true, "->": true, "==": true, "!=": true, "<=": true, ">=": true,
+	"?": true, ">": true, "<": true, "+": true, "*": true, "^": true, "%": true,
+	"/": true, "-": true, "|": true, "&": true,
+	"===": true, "!==": true, "//": true, "<<": true, ">>": true, "^^": true, "!>": true, "!<": true,
+	"+=": true, "-=": true, "*=": true, "/=": true, "%=": true, "^=": true, "@=": true, "??=": true, "++=": true,
+}
+
+// spacedOperator reports whether the operator lx is written against a value on both
+// sides, like the + of a+b, rather than at the start of one, like the - of -1
+func spacedOperator(code string, lx Lexeme) bool {
+	if lx.Kind != LEX_SYMBOL || !spacedSymbols[lx.Text] || lx.Offset == 0 {
+		return false
+	}
+	before, _ := utf8.DecodeLastRuneInString(code[:lx.Offset])
+	after, _ := utf8.DecodeRuneInString(code[lx.Offset+len(lx.Text):])
+	return (isWordRune(before) || strings.ContainsRune(`]"})`, before)) &&
+		after != utf8.RuneError && !unicode.IsSpace(after)
+}
+
+// linePiece is one piece of a statement, which GenerateAST makes a token of. Its
+// lexemes are the ones it was written from.
+type linePiece struct {
+	text    string
+	lexemes []Lexeme
+}
+
+// splitLine splits a statement into pieces in one pass over its lexemes. Spaces outside
+// brackets separate pieces, and an operator written against a value is a piece of its
+// own, or is spaced out inside brackets, so a+b reads as a + b. A ) with nothing open
+// ends the piece before it and is dropped. Block comments are left out.
+func splitLine(code string) []linePiece {
+	lexemes := Lex(code)
+	pieces := make([]linePiece, 0, len(lexemes)/2+1)
+	var text strings.Builder
+	first, depth := 0, 0
+
+	// end ends the piece before the lexeme at i
+	end := func(i int) {
+		pieces = append(pieces, linePiece{text: text.String(), lexemes: lexemes[first:i]})
+		text.Reset()
+		first = i
+	}
+	write := func(i int, s string) {
+		if text.Len() == 0 {
+			first = i
+		}
+		text.WriteString(s)
+	}
+
+	for i, lx := range lexemes {
+		switch {
+		case lx.Kind == LEX_COMMENT:
+			continue
+		case lx.Kind == LEX_SPACE && depth == 0:
+			if text.Len() > 0 {
+				end(i)
+			}
+			continue
+		case spacedOperator(code, lx):
+			if depth > 0 {
+				write(i, " "+lx.Text+" ")
+				continue
+			}
+			if text.Len() > 0 {
+				end(i)
+			}
+			write(i, lx.Text)
+			end(i + 1)
+			continue
+		case lx.Text == ")" && depth == 0 && text.Len() > 0:
+			end(i)
+			continue
+		case lx.Text == "(" || lx.Text == "[" || lx.Text == "{":
+			depth++
+		case lx.Text == ")" || lx.Text == "]" || lx.Text == "}":
+			depth = max(depth-1, 0)
+		}
+		write(i, lx.Text)
+	}
+	if text.Len() == 0 {
+		first = len(lexemes)
+	}
+	return append(pieces, linePiece{text: text.String(), lexemes: lexemes[first:]})
+}
+
+// splitStatements splits prepared source into statements at each newline and ; outside
+// brackets
+func splitStatements(code string) []string {
+	var statements []string
+	start, depth := 0, 0
+	for _, lx := range Lex(code) {
+		switch lx.Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth = max(depth-1, 0)
+		case "\n", ";":
+			if depth == 0 {
+				statements = append(statements, code[start:lx.Offset])
+				start = lx.Offset + 1
+			}
+		}
+	}
+	return append(statements, code[start:])
+}
+
+// lexCursor walks the lexemes of the main file along with the statements parsed from
+// it, so each token gets the position of the lexeme it starts with
+type lexCursor struct {
+	lexemes []Lexeme
+	i       int
+	first   int // the first lexeme of the statement being parsed, -1 until it is placed
+}
+
+// place finds the lexemes of a piece in the file, from where the last piece ended,
+// and gives t the position of the first one found. prepareSource adds some lexemes,
+// like the . of x.[1], and the def rewrite drops the ->, so lexemes missing on either
+// side are stepped over. Brackets are matched whole.
+func (c *lexCursor) place(t *Token, piece []Lexeme) {
+	for i := 0; i < len(piece); i++ {
+		if piece[i].Trivia() {
+			continue
+		}
+		j := c.find(piece[i].Text)
+		if j < 0 {
+			continue
+		}
+		if t.Line == 0 {
+			t.Offset, t.Line, t.Column = c.lexemes[j].Offset, c.lexemes[j].Line, c.lexemes[j].Column
+			if c.first < 0 {
+				c.first = j
+			}
+		}
+		c.i = j + 1
+		if opensBracket(piece[i].Text) {
+			i = closingLexeme(piece, i)
+			c.i = closingLexeme(c.lexemes, j) + 1
+		}
+	}
+}
+
+// find returns the index of the next code lexeme with the given text, looking past at
+// most two others and never into brackets, or -1 when there is none
+func (c *lexCursor) find(text string) int {
+	passed := 0
+	for j := c.i; j < len(c.lexemes) && passed <= 2; j++ {
+		lx := c.lexemes[j]
+		switch {
+		case lx.Trivia():
+			continue
+		case lx.Text == text:
+			return j
+		case opensBracket(lx.Text) || closesBracket(lx.Text):
+			return -1
+		}
+		passed++
+	}
+	return -1
+}
+
+func opensBracket(s string) bool  { return s == "(" || s == "[" || s == "{" }
+func closesBracket(s string) bool { return s == ")" || s == "]" || s == "}" }
+
+// closingLexeme returns the index of the bracket closing the one at i, or the last
+// index when it is never closed
+func closingLexeme(lexemes []Lexeme, i int) int {
+	depth := 0
+	for ; i < len(lexemes); i++ {
+		switch {
+		case opensBracket(lexemes[i].Text):
+			depth++
+		case closesBracket(lexemes[i].Text):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(lexemes) - 1
+}
+
+// lineComments are the comments kept for one statement
+type lineComments struct {
+	leading  []string
+	trailing []string
+}
+
+// recordComment keeps the comment at i. A comment after code on the same line trails
+// that line, any other comment leads the next line of code.
+func (utils *OSLUtils) recordComment(lexemes []Lexeme, i int) {
+	prev := i - 1
+	for prev >= 0 && lexemes[prev].Kind == LEX_SPACE {
+		prev--
+	}
+	if prev >= 0 && lexemes[prev].Kind != LEX_NEWLINE {
+		c := utils.comments[lexemes[i].Line]
+		c.trailing = append(c.trailing, lexemes[i].Text)
+		utils.comments[lexemes[i].Line] = c
+		return
+	}
+	next := i + 1
+	for next < len(lexemes) && lexemes[next].Trivia() {
+		next++
+	}
+	if next < len(lexemes) {
+		c := utils.comments[lexemes[next].Line]
+		c.leading = append(c.leading, lexemes[i].Text)
+		utils.comments[lexemes[next].Line] = c
+	}
+}
+
+// prepareSource rewrites source into the lines the AST builder reads. Statements
+// are split onto their own lines, comments are dropped, a line starting with . carries
+// on the line before it, x[ becomes x.[ and f()( becomes f().call(.
+//
+// origin is where code starts in its file, so positions count from there. For the main
+// file each line is preceded by a /@line marker and the lexemes are kept, for the
+// statements parsed from them to take their positions from.
+func (utils *OSLUtils) prepareSource(code string, origin Lexeme, main bool) string {
+	lexemes := Lex(code)
+	for i := range lexemes {
+		if lexemes[i].Line == 1 {
+			lexemes[i].Column += origin.Column - 1
+		}
+		lexemes[i].Line += origin.Line - 1
+		lexemes[i].Offset += origin.Offset
+	}
+	var out strings.Builder
+
+	// nextCode returns the index of the first lexeme from i that is not trivia
+	nextCode := func(i int) int {
+		for i < len(lexemes) && lexemes[i].Trivia() {
+			i++
+		}
+		return i
+	}
+	hasNewline := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if lexemes[i].Kind == LEX_NEWLINE {
+				return true
+			}
+		}
+		return false
+	}
+	// indent returns the spaces just before the lexeme at i, kept so statement sources
+	// read as written
+	indent := func(i int) string {
+		if i > 0 && i <= len(lexemes) && lexemes[i-1].Kind == LEX_SPACE {
+			return lexemes[i-1].Text
+		}
+		return ""
+	}
+	before := func(lx Lexeme) byte {
+		if lx.Offset == origin.Offset {
+			return 0
+		}
+		return code[lx.Offset-origin.Offset-1]
+	}
+
+	if main {
+		utils.lexemes = lexemes
+		for i, lx := range lexemes {
+			if lx.Kind == LEX_COMMENT {
+				utils.recordComment(lexemes, i)
+			}
+		}
+	}
+
+	for i := 0; i < len(lexemes); i++ {
+		lx := lexemes[i]
+		if main && !lx.Trivia() {
+			if _, seen := utils.lineStarts[lx.Line]; !seen {
+				utils.lineStarts[lx.Line] = i
+			}
+		}
+
+		switch {
+		case lx.Kind == LEX_COMMENT:
+			// a block comment still separates the code either side of it, but only by
+			// one space, as the line tokeniser does not expect runs of spaces
+			spaced := i+1 < len(lexemes) && lexemes[i+1].Kind == LEX_SPACE
+			if strings.HasPrefix(lx.Text, "/*") && spaced && unicode.IsSpace(rune(before(lx))) {
+				i++
+			} else if strings.HasPrefix(lx.Text, "/*") && !spaced && !unicode.IsSpace(rune(before(lx))) {
+				out.WriteString(" ")
+			}
+			continue
+
+		case lx.Kind == LEX_SYMBOL && (lx.Text == "," || lx.Text == "{" || lx.Text == "["):
+			// a line ending in an open bracket or a comma carries on onto the next line
+			next := nextCode(i + 1)
+			if hasNewline(i+1, next) {
+				out.WriteString(lx.Text + "\n" + indent(next))
+				i = next - 1
+				continue
+			}
+			indexed := strings.IndexByte(`)"]}`, before(lx)) >= 0 || isASCIIAlnum(before(lx))
+			if lx.Text == "[" && indexed && i+1 < len(lexemes) && lexemes[i+1].Text != "]" {
+				out.WriteString(".[")
+				continue
+			}
+
+		case lx.Kind == LEX_SYMBOL && lx.Text == "(" && (before(lx) == ')' || before(lx) == ']'):
+			out.WriteString(".call(")
+			continue
+
+		case lx.Kind == LEX_SYMBOL && lx.Text == ";":
+			out.WriteString("\n")
+			continue
+
+		case lx.Kind == LEX_NEWLINE:
+			// blank lines and comment lines between statements are skipped
+			// the lexeme starting the next line goes through the rules above, unless it
+			// carries on this line
+			next := nextCode(i + 1)
+			i = next - 1
+			switch {
+			case next == len(lexemes):
+				out.WriteString("\n")
+			case strings.HasPrefix(lexemes[next].Text, "."):
+				out.WriteString(lexemes[next].Text)
+				i = next
+			case lexemes[next].Text == "}" || lexemes[next].Text == "]":
+				out.WriteString("\n" + indent(next) + lexemes[next].Text)
+				i = next
+			case main:
+				fmt.Fprintf(&out, "\n/@line %d\n%s", lexemes[next].Line, indent(next))
+			default:
+				out.WriteString("\n" + indent(next))
+			}
+			continue
+		}
+		out.WriteString(lx.Text)
+	}
+	return out.String()
+}
+
+// isVariableName reports whether s is a name, which may be negated with ! and may
+// end in : as an object key
+func isVariableName(s string) bool {
+	s = strings.TrimLeft(s, "!")
+	s = strings.TrimSuffix(s, ":")
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '_' && !isASCIIAlnum(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIIAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzLex checks that lexing gives back the source exactly and that every lexeme's
+// position is where it is in the source
+func FuzzLex(f *testing.F) {
+	for _, seed := range parserSeeds {
+		f.Add(seed)
+	}
+	f.Add("s = `a${ {b: `c${d}`}.b }e` // comment\n/* block\n*/ x = 1e-3 + 'unclosed")
+	f.Fuzz(func(t *testing.T, src string) {
+		var joined strings.Builder
+		for _, lx := range Lex(src) {
+			if lx.Text == "" {
+				t.Fatalf("empty %s lexeme at %d", lx.Kind, lx.Offset)
+			}
+			if lx.Offset != joined.Len() {
+				t.Fatalf("%q is at offset %d, want %d", lx.Text, lx.Offset, joined.Len())
+			}
+			if line, column := sourcePosition(src, lx.Offset); lx.Line != line || lx.Column != column {
+				t.Fatalf("%q at offset %d is at %d:%d, want %d:%d", lx.Text, lx.Offset, lx.Line, lx.Column, line, column)
+			}
+			joined.WriteString(lx.Text)
+		}
+		if joined.String() != src {
+			t.Fatalf("lexemes join to %q", joined.String())
+		}
+	})
+}
+
+// sourcePosition is the line and column of offset in src, both counting from 1
+func sourcePosition(src string, offset int) (line, column int) {
+	return 1 + strings.Count(src[:offset], "\n"), offset - strings.LastIndexByte(src[:offset], '\n')
+}
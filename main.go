@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/browser"
@@ -27,15 +28,43 @@ Commands:
   setup                      Setup OSL.go environment
   compile <file.osl> [-o <output>]     Compile OSL file
   compile-max <file.osl> [-o <output>] Compile OSL file with maximum optimizations
-  transpile <file.osl>       Transpile OSL file to Go and print to stdout
-  run <file.osl>             Compile and run OSL file
+  build [patterns] [-o <dir>] [-j <n>] Compile every entrypoint, eg. osl build ./..., into bin/
+  transpile <file.osl>       Transpile OSL file to formatted Go and print to stdout
+                             -o <dir> writes one file per module, --eject <dir> a Go module
+  run <file.osl> [--interp]  Compile and run OSL file, or interpret it without Go
+  bench [file.osl...]        Run bench "name" ( ... ) blocks, with --save and --compare for a baseline
+  fuzz [file.osl...]         Fuzz fuzz "name" (string s) ( ... ) blocks with go test, or --property test them
+  debug <file.osl> [-- args] Build with debug info and serve it to DAP clients (VS Code) with Delve
   ast <file.osl>             Generate AST for OSL file
-  package <name> Print source code for an OSL package
+  repl [packages...]         Start an interactive prompt, eg. osl repl osl/math
+  package [list]             List osl/* packages, from osl_packages, $OSL_PATH, ~/.osl/packages and built in
+  package <name>             Print source code for an osl/* package (or package show <name>)
+  package validate [names]   Check package headers and code, every package when no names are given
+  doc [package] [method]     Show the API of osl/* packages, eg. osl doc cache getOrSetFunc
+  doc --md|--html <dir>      Write an API reference for every package, or one package, to dir
+  new <template> <dir>       Create a project from a template: web, ws, cli, tui, window or lib
+  new --list                 List templates, including your own from ~/.osl/templates
+  new --register <name> <dir> Use a directory of your own as a template
   uninstall                  Uninstall OSL.go
   origin                     Open Origin website (https://origin.mistium.com)
   help                       Show this help message
   version                    Show version information
 
+Build options (compile, compile-max, run):
+  --profile [dir]            Write cpu, heap, block and mutex pprof profiles on exit
+  --pgo <file.pgo>           Build with profile-guided optimization
+  --race                     Build with the race detector
+  --safe-globals             Make every top level object and array thread-safe
+  --interp                   Run with the built-in interpreter instead of compiling (run only)
+  --target wasm|wasip1       Compile to a browser (with html loader) or wasi wasm module (compile only)
+  --shared                   Compile export def functions into a C shared library and header (compile only)
+  --headless                 Draw windows on a software canvas and write each frame to png
+  --frames <n>               Number of mainloop frames a headless window runs for (default 1)
+  --frames-dir <dir>         Where headless frames are written (default frames)
+  --input <file>             Scripted key and mouse input for a headless window
+  --tags <a,b>               Set tags for #if conditions and go build
+  -D <name>[=value]          Define a name for #if conditions, eg. -D debug or -D level=2
+
 For more information, visit: https://origin.mistium.com`
 )
 
@@ -111,7 +140,7 @@ func openFile(path string) string {
 }
 
 func scriptToAst(script string) [][]*Token {
-	ast := parser.GenerateFullAST(script, true)
+	ast := parser.GenerateFullAST(preprocess(script), true)
 	functionTypes := parser.functionReturnTypes
 	maps.Copy(allFunctionTypes, functionTypes)
 	return ast
@@ -140,63 +169,240 @@ func copyGoModFiles(srcDir, dstDir string) error {
 	return nil
 }
 
-func transpile(args []string) {
-	if len(args) != 1 {
-		fmt.Println("Usage: osl transpile <file.osl>")
-		return
+// buildOptions holds the flags shared by compile and run
+type buildOptions struct {
+	inputFile   string
+	inputs      []string // every file or pattern given, for osl build
+	output      string
+	max         bool
+	race        bool
+	pgo         string
+	profileDir  string
+	safeGlobals bool
+	interp      bool
+	headless    bool
+	frames      int
+	framesDir   string
+	eventsFile  string
+	events      string
+	target      string
+	shared      bool
+	tags        []string
+	defines     map[string]string
+}
+
+func parseBuildArgs(args []string, allowOutput bool) (buildOptions, error) {
+	var opts buildOptions
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-o":
+			if !allowOutput {
+				return opts, fmt.Errorf("-o is not supported here")
+			}
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("-o flag requires an output filename")
+			}
+			opts.output = args[i+1]
+			i++
+		case "--race":
+			opts.race = true
+		case "--shared":
+			opts.shared = true
+		case "--safe-globals":
+			opts.safeGlobals = true
+		case "--interp":
+			opts.interp = true
+		case "--headless":
+			opts.headless = true
+		case "--frames":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--frames flag requires a number of frames")
+			}
+			frames, err := strconv.Atoi(args[i+1])
+			if err != nil || frames < 1 {
+				return opts, fmt.Errorf("--frames flag requires a number of frames, got %s", args[i+1])
+			}
+			opts.frames = frames
+			i++
+		case "--frames-dir":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--frames-dir flag requires a directory")
+			}
+			opts.framesDir = args[i+1]
+			i++
+		case "--input":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--input flag requires an input event file")
+			}
+			opts.eventsFile = args[i+1]
+			i++
+		case "--tags":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--tags flag requires a comma separated list of tags")
+			}
+			for _, tag := range strings.Split(args[i+1], ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					opts.tags = append(opts.tags, tag)
+				}
+			}
+			i++
+		case "-D":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("-D flag requires name=value")
+			}
+			opts.addDefine(args[i+1])
+			i++
+		case "--target":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--target flag requires a target (wasm or wasip1)")
+			}
+			opts.target = args[i+1]
+			if _, ok := wasmTargets[opts.target]; !ok {
+				return opts, fmt.Errorf("unknown target %s (expected wasm or wasip1)", opts.target)
+			}
+			i++
+		case "--pgo":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--pgo flag requires a profile file")
+			}
+			opts.pgo = args[i+1]
+			i++
+		case "--profile":
+			opts.profileDir = "."
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && !strings.HasSuffix(args[i+1], ".osl") {
+				opts.profileDir = args[i+1]
+				i++
+			}
+		default:
+			if define, ok := strings.CutPrefix(args[i], "-D"); ok && define != "" {
+				opts.addDefine(define)
+				continue
+			}
+			if strings.HasPrefix(args[i], "--") {
+				return opts, fmt.Errorf("unknown flag %s", args[i])
+			}
+			if opts.inputFile == "" {
+				opts.inputFile = args[i]
+			}
+			opts.inputs = append(opts.inputs, args[i])
+		}
+	}
+
+	// the profile paths are relative to where osl was invoked, not the script directory
+	var err error
+	if opts.pgo != "" && opts.pgo != "auto" && opts.pgo != "off" {
+		if opts.pgo, err = filepath.Abs(opts.pgo); err != nil {
+			return opts, err
+		}
+	}
+	if opts.profileDir != "" {
+		if opts.profileDir, err = filepath.Abs(opts.profileDir); err != nil {
+			return opts, err
+		}
+	}
+	if opts.headless && opts.framesDir == "" {
+		opts.framesDir = "frames"
+	}
+	if opts.framesDir != "" {
+		if opts.framesDir, err = filepath.Abs(opts.framesDir); err != nil {
+			return opts, err
+		}
+	}
+	if opts.eventsFile != "" {
+		data, err := os.ReadFile(opts.eventsFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.events = string(data)
 	}
 
-	scriptPath := args[0]
+	return opts, nil
+}
 
-	scriptDir := filepath.Dir(scriptPath)
-	originalDir, err := os.Getwd()
-	if err != nil {
-		fmt.Println("Failed to get current directory:", err)
-		return
+// addDefine records a -D name=value flag, a bare -D name defines it as true
+func (opts *buildOptions) addDefine(define string) {
+	if opts.defines == nil {
+		opts.defines = map[string]string{}
+	}
+	name, value, ok := strings.Cut(define, "=")
+	if !ok {
+		value = "true"
 	}
+	opts.defines[strings.TrimSpace(name)] = value
+}
 
-	if err := os.Chdir(scriptDir); err != nil {
-		fmt.Println("Failed to change to script directory:", err)
-		return
+func (opts buildOptions) toCompileOptions() CompileOptions {
+	return CompileOptions{
+		ProfileDir:  opts.profileDir,
+		SafeGlobals: opts.safeGlobals,
+		Target:      opts.target,
+		Tags:        opts.tags,
+		Defines:     opts.defines,
+		Headless:    opts.headless,
+		Frames:      opts.frames,
+		FramesDir:   opts.framesDir,
+		Input:       opts.events,
+		Shared:      opts.shared,
 	}
-	defer func() {
-		_ = os.Chdir(originalDir)
-	}()
+}
 
-	script := openFile(filepath.Base(scriptPath))
-	if script == "" {
-		return
+func (opts buildOptions) goBuildArgs(outputPath string, goFile string) []string {
+	args := []string{"build"}
+	if opts.max {
+		args = append(args, "-ldflags", "-s -w")
+	}
+	if opts.race {
+		args = append(args, "-race")
+	}
+	if opts.pgo != "" {
+		args = append(args, "-pgo", opts.pgo)
 	}
-	fmt.Println(scriptToGo(script))
+	if opts.shared {
+		args = append(args, "-buildmode=c-shared")
+	}
+	if len(opts.tags) > 0 {
+		args = append(args, "-tags", strings.Join(opts.tags, ","))
+	}
+	return append(args, "-o", outputPath, goFile)
+}
+
+// goBuildEnv returns the environment go build runs with
+func (opts buildOptions) goBuildEnv() []string {
+	env := os.Environ()
+	if goos, ok := wasmTargets[opts.target]; ok {
+		env = append(env, "GOOS="+goos, "GOARCH=wasm")
+	}
+	return env
 }
 
 func compile(main_args []string, max bool) {
-	if len(main_args) < 1 {
-		fmt.Println("Usage: osl compile <file.osl> [-o <output>]")
+	opts, err := parseBuildArgs(main_args, true)
+	if err != nil {
+		fmt.Println("Error:", err)
 		return
 	}
-
-	inputFile := ""
-	customOutput := ""
-
-	for i := 0; i < len(main_args); i++ {
-		if main_args[i] == "-o" {
-			if i+1 < len(main_args) {
-				customOutput = main_args[i+1]
-				i++
-			} else {
-				fmt.Println("Error: -o flag requires an output filename")
-				return
-			}
-		} else if inputFile == "" {
-			inputFile = main_args[i]
-		}
+	opts.max = max
+	if opts.interp {
+		fmt.Println("Error: --interp only works with osl run")
+		return
+	}
+	if opts.target != "" && opts.race {
+		fmt.Println("Error: --race is not supported with --target", opts.target)
+		return
+	}
+	if opts.target != "" && opts.shared {
+		fmt.Println("Error: --shared is not supported with --target", opts.target)
+		return
 	}
 
-	if inputFile == "" {
-		fmt.Println("Usage: osl compile <file.osl> [-o <output>]")
+	// one file, anything else is a mistake rather than something to ignore
+	if len(opts.inputs) != 1 {
+		fmt.Println("Usage: osl compile <file.osl> [-o <output>] [--target wasm|wasip1] [--shared] [--pgo <file.pgo>] [--race] [--profile [dir]]")
 		return
 	}
+	inputFile := opts.inputFile
+	customOutput := opts.output
 
 	scriptDir := filepath.Dir(inputFile)
 	originalDir, err := os.Getwd()
@@ -225,8 +431,24 @@ func compile(main_args []string, max bool) {
 	}
 	defer os.RemoveAll(tmpDir)
 
+	compileOptions = opts.toCompileOptions()
+
+	ast := scriptToAst(script)
+	if opts.target != "" {
+		if err := checkTargetImports(ast, opts.target); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if opts.shared {
+		if err := checkSharedExports(ast); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
 	tmpGoFile := filepath.Join(tmpDir, "main.go")
-	if err := os.WriteFile(tmpGoFile, []byte(scriptToGo(script)), 0644); err != nil {
+	if err := os.WriteFile(tmpGoFile, []byte("package main\n\n"+Compile(ast)), 0644); err != nil {
 		fmt.Println("Failed to write temp Go file:", err)
 		return
 	}
@@ -240,6 +462,10 @@ func compile(main_args []string, max bool) {
 	if err := copyGoModFiles(cwd, tmpDir); err != nil {
 		fmt.Println("Warning: failed to copy go.mod/go.sum:", err)
 	}
+	if err := copyEmbeddedFiles(cwd, tmpDir); err != nil {
+		fmt.Println("Failed to copy embedded files:", err)
+		return
+	}
 
 	var outputPath string
 	if customOutput != "" {
@@ -250,17 +476,18 @@ func compile(main_args []string, max bool) {
 		}
 	} else {
 		outputName := strings.TrimSuffix(filepath.Base(inputFile), ".osl")
+		if opts.target != "" {
+			outputName += ".wasm"
+		}
+		if opts.shared {
+			outputName = sharedLibraryName(outputName)
+		}
 		outputPath = filepath.Join(cwd, outputName)
 	}
 
-	args := []string{}
-	if max {
-		args = append(args, "-ldflags", "-s -w")
-	}
-	args = append(args, "-o", outputPath, tmpGoFile)
-
-	buildCmd := exec.Command("go", append([]string{"build"}, args...)...)
+	buildCmd := exec.Command("go", opts.goBuildArgs(outputPath, tmpGoFile)...)
 	buildCmd.Dir = tmpDir
+	buildCmd.Env = opts.goBuildEnv()
 	output, err := buildCmd.CombinedOutput()
 	if err != nil {
 		fmt.Println("Build failed!")
@@ -268,7 +495,26 @@ func compile(main_args []string, max bool) {
 		return
 	}
 
-	fmt.Printf("Compiled binary: %s\n", outputPath)
+	switch opts.target {
+	case "wasm":
+		htmlPath, err := writeWasmLoader(outputPath)
+		if err != nil {
+			fmt.Println("Failed to write wasm loader:", err)
+			return
+		}
+		fmt.Printf("Compiled wasm module: %s\n", outputPath)
+		fmt.Printf("Serve %s with wasm_exec.js over http to run it\n", htmlPath)
+	case "wasip1":
+		fmt.Printf("Compiled wasm module: %s\n", outputPath)
+		fmt.Println("Run it with a wasi runtime, eg. wasmtime", filepath.Base(outputPath))
+	default:
+		if opts.shared {
+			fmt.Printf("Compiled shared library: %s\n", outputPath)
+			fmt.Printf("C header: %s\n", strings.TrimSuffix(outputPath, filepath.Ext(outputPath))+".h")
+			return
+		}
+		fmt.Printf("Compiled binary: %s\n", outputPath)
+	}
 }
 
 func ast(args []string) {
@@ -291,12 +537,22 @@ func ast(args []string) {
 }
 
 func run(args []string) {
-	if len(args) != 1 {
-		fmt.Println("Usage: osl run <file.osl>")
+	opts, err := parseBuildArgs(args, false)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if opts.target != "" || opts.shared {
+		fmt.Println("Error: --target and --shared only work with osl compile")
+		return
+	}
+	// one file, anything else is a mistake rather than something to ignore
+	if len(opts.inputs) != 1 {
+		fmt.Println("Usage: osl run <file.osl> [--interp] [--headless] [--pgo <file.pgo>] [--race] [--profile [dir]]")
 		return
 	}
 
-	scriptPath := args[0]
+	scriptPath := opts.inputFile
 
 	scriptDir := filepath.Dir(scriptPath)
 	originalDir, err := os.Getwd()
@@ -318,6 +574,12 @@ func run(args []string) {
 		return
 	}
 
+	compileOptions = opts.toCompileOptions()
+	if opts.interp {
+		interpret(script)
+		return
+	}
+
 	tmpDir, err := os.MkdirTemp("", "osl-build-*")
 	if err != nil {
 		fmt.Println("Failed to create temp dir:", err)
@@ -337,6 +599,10 @@ func run(args []string) {
 			// not fatal
 			fmt.Println("Warning: failed to copy go.mod/go.sum:", err)
 		}
+		if err := copyEmbeddedFiles(cwd, tmpDir); err != nil {
+			fmt.Println("Failed to copy embedded files:", err)
+			return
+		}
 	}
 
 	// platform-specific binary name
@@ -346,7 +612,7 @@ func run(args []string) {
 	}
 	binaryPath := filepath.Join(tmpDir, binName)
 
-	buildCmd := exec.Command("go", "build", "-o", binaryPath, tmpGoFile)
+	buildCmd := exec.Command("go", opts.goBuildArgs(binaryPath, tmpGoFile)...)
 	buildCmd.Dir = tmpDir
 	output, err := buildCmd.CombinedOutput()
 	if err != nil {
@@ -363,26 +629,6 @@ func run(args []string) {
 	}
 }
 
-func pkg(args []string) {
-	if len(args) < 1 {
-		fmt.Println("Usage: osl package <name>")
-		fmt.Println("Available packages:")
-		entries, _ := packagesFS.ReadDir("packages")
-		for _, e := range entries {
-			name := strings.TrimSuffix(e.Name(), ".go")
-			fmt.Println("  " + name)
-		}
-		return
-	}
-	name := args[0]
-	data, err := packagesFS.ReadFile("packages/" + name + ".go")
-	if err != nil {
-		fmt.Println("Package not found:", name)
-		return
-	}
-	fmt.Print(string(data))
-}
-
 func main() {
 	args := os.Args
 	if len(args) < 2 {
@@ -398,14 +644,28 @@ func main() {
 		compile(args[2:], false)
 	case "compile-max":
 		compile(args[2:], true)
+	case "build":
+		build(args[2:])
 	case "transpile":
 		transpile(args[2:])
+	case "bench":
+		benchmark(args[2:])
+	case "fuzz":
+		fuzzCommand(args[2:])
+	case "debug":
+		debug(args[2:])
 	case "ast":
 		ast(args[2:])
 	case "package":
 		pkg(args[2:])
+	case "doc":
+		doc(args[2:])
+	case "new":
+		newProject(args[2:])
 	case "run":
 		run(args[2:])
+	case "repl":
+		repl(args[2:])
 	case "uninstall":
 		uninstall()
 	case "origin":
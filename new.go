@@ -0,0 +1,322 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//go:embed all:templates
+var templatesFS embed.FS
+
+// projectManifest is the osl.json at the root of a project made by osl new
+type projectManifest struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Template    string   `json:"template,omitempty"`
+	Entrypoints []string `json:"entrypoints"`
+	Tests       []string `json:"tests,omitempty"`
+}
+
+// readManifest reads dir/osl.json, returning nil if the directory has none
+func readManifest(dir string) (*projectManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "osl.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m projectManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, "osl.json"), err)
+	}
+	return &m, nil
+}
+
+// projectTemplate is a directory of files that osl new copies into a new project.
+// Files may use {{name}} for the project name, {{ident}} for the name as an
+// identifier and {{Ident}} for the same capitalised. A .tmpl suffix is dropped,
+// which lets built in templates carry files like go.mod.
+type projectTemplate struct {
+	name    string
+	files   fs.FS
+	path    string // the directory it is read from, or "" for built in templates
+	shadows bool   // it hides the built in template with the same name
+}
+
+func (t *projectTemplate) location() string {
+	if t.path == "" {
+		return "built in"
+	}
+	return t.path
+}
+
+func userTemplatesDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".osl")
+}
+
+// registeredTemplates reads the template directories added with osl new --register
+func registeredTemplates() map[string]string {
+	registered := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(userTemplatesDir(), "templates.json"))
+	if err == nil {
+		json.Unmarshal(data, &registered)
+	}
+	return registered
+}
+
+func builtinTemplate(name string) *projectTemplate {
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		return nil
+	}
+	files, err := fs.Sub(templatesFS, "templates/"+name)
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(files, "."); err != nil {
+		return nil
+	}
+	return &projectTemplate{name: name, files: files}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// findTemplate looks a template up by name: templates registered with --register,
+// then ~/.osl/templates/<name>, then the built in ones. A path to a directory is
+// used as a template directly.
+func findTemplate(name string) (*projectTemplate, error) {
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		if !isDir(name) {
+			return nil, fmt.Errorf("template directory %s not found", name)
+		}
+		return &projectTemplate{name: filepath.Base(name), files: os.DirFS(name), path: name}, nil
+	}
+
+	builtin := builtinTemplate(name)
+	dir, ok := registeredTemplates()[name]
+	if !ok {
+		dir = filepath.Join(userTemplatesDir(), "templates", name)
+	}
+	if isDir(dir) {
+		return &projectTemplate{name: name, files: os.DirFS(dir), path: dir, shadows: builtin != nil}, nil
+	}
+	if ok {
+		return nil, fmt.Errorf("template %s is registered to %s, which is not a directory", name, dir)
+	}
+	if builtin == nil {
+		return nil, fmt.Errorf("unknown template %s, see osl new --list", name)
+	}
+	return builtin, nil
+}
+
+// allTemplates lists the template used for every name, built in ones first
+func allTemplates() []*projectTemplate {
+	names := make(map[string]bool)
+	entries, _ := templatesFS.ReadDir("templates")
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	for name := range registeredTemplates() {
+		names[name] = true
+	}
+	entries, _ = os.ReadDir(filepath.Join(userTemplatesDir(), "templates"))
+	for _, e := range entries {
+		if e.IsDir() {
+			names[e.Name()] = true
+		}
+	}
+
+	var templates []*projectTemplate
+	for name := range names {
+		if t, err := findTemplate(name); err == nil {
+			templates = append(templates, t)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if (templates[i].path == "") != (templates[j].path == "") {
+			return templates[i].path == ""
+		}
+		return templates[i].name < templates[j].name
+	})
+	return templates
+}
+
+// projectIdent turns a project name into something usable as a Go and osl name
+func projectIdent(name string) string {
+	var ident strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			ident.WriteRune(r)
+		}
+	}
+	out := ident.String()
+	if out == "" || unicode.IsDigit(rune(out[0])) {
+		out = "app" + out
+	}
+	if token.IsKeyword(out) || out == "main" {
+		out += "lib"
+	}
+	return out
+}
+
+// instantiate copies the template into dir, filling in the placeholders
+func (t *projectTemplate) instantiate(dir string, name string) ([]string, error) {
+	ident := projectIdent(name)
+	replacer := strings.NewReplacer(
+		"{{name}}", name,
+		"{{ident}}", ident,
+		"{{Ident}}", strings.ToUpper(ident[:1])+ident[1:],
+	)
+
+	var written []string
+	err := fs.WalkDir(t.files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		target := filepath.Join(dir, filepath.FromSlash(replacer.Replace(strings.TrimSuffix(path, ".tmpl"))))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := fs.ReadFile(t.files, path)
+		if err != nil {
+			return err
+		}
+		// leave binary files such as images alone
+		if !strings.ContainsRune(string(data), 0) {
+			data = []byte(replacer.Replace(string(data)))
+		}
+		mode := os.FileMode(0644)
+		if info, err := d.Info(); err == nil && info.Mode()&0111 != 0 {
+			mode = 0755
+		}
+		if err := os.WriteFile(target, data, mode); err != nil {
+			return err
+		}
+		written = append(written, filepath.ToSlash(strings.TrimPrefix(target, dir+string(filepath.Separator))))
+		return nil
+	})
+	return written, err
+}
+
+func registerTemplate(name string, dir string) error {
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		return fmt.Errorf("invalid template name %q", name)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if !isDir(abs) {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	registered := registeredTemplates()
+	registered[name] = abs
+	data, err := json.MarshalIndent(registered, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(userTemplatesDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(userTemplatesDir(), "templates.json"), append(data, '\n'), 0644)
+}
+
+func newProject(args []string) {
+	if len(args) == 0 || args[0] == "--list" {
+		fmt.Println("Usage: osl new <template> <dir>, or osl new --register <name> <template dir>")
+		fmt.Println("Templates are looked up in templates registered with --register, ~/.osl/templates and the built in templates")
+		fmt.Println()
+		for _, t := range allTemplates() {
+			fmt.Printf("  %-10s %s\n", t.name, t.location())
+			if t.shadows {
+				fmt.Printf("  %-10s overrides the built in %s template\n", "", t.name)
+			}
+		}
+		return
+	}
+
+	if args[0] == "--register" {
+		if len(args) != 3 {
+			fmt.Println("Usage: osl new --register <name> <template dir>")
+			return
+		}
+		if err := registerTemplate(args[1], args[2]); err != nil {
+			fmt.Println("Failed to register template:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Registered template %s, use it with: osl new %s <dir>\n", args[1], args[1])
+		if builtinTemplate(args[1]) != nil {
+			fmt.Printf("Warning: %s overrides the built in %s template\n", args[2], args[1])
+		}
+		return
+	}
+
+	if len(args) != 2 {
+		fmt.Println("Usage: osl new <template> <dir>")
+		return
+	}
+	t, err := findTemplate(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if t.shadows {
+		fmt.Printf("Warning: using the %s template from %s, which overrides the built in one\n", t.name, t.path)
+	}
+
+	dir := args[1]
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		fmt.Printf("%s already exists and is not empty\n", dir)
+		os.Exit(1)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Println("Failed to create project:", err)
+		os.Exit(1)
+	}
+	written, err := t.instantiate(dir, filepath.Base(abs))
+	if err != nil {
+		fmt.Println("Failed to create project:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created %s from the %s template:\n", dir, t.name)
+	for _, file := range written {
+		fmt.Println("  " + file)
+	}
+	m, err := readManifest(dir)
+	if err != nil || m == nil {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Next:")
+	fmt.Println("  cd " + dir)
+	for _, entry := range m.Entrypoints {
+		fmt.Println("  osl run " + entry)
+	}
+	for _, test := range m.Tests {
+		fmt.Println("  osl run " + test)
+	}
+}
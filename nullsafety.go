@@ -0,0 +1,143 @@
+package main
+
+import "strings"
+
+// nullableBase returns the type a T null declaration wraps, and whether the type is nullable
+func nullableBase(oslType string) (string, bool) {
+	return strings.CutSuffix(oslType, " null")
+}
+
+// isNilable reports whether a go type can already hold nil, so its nullable form needs no boxing
+func isNilable(goType string) bool {
+	switch {
+	case goType == "any", goType == "error":
+		return true
+	case strings.HasPrefix(goType, "*"), strings.HasPrefix(goType, "[]"),
+		strings.HasPrefix(goType, "map["), strings.HasPrefix(goType, "func("),
+		strings.HasPrefix(goType, "chan "), strings.HasPrefix(goType, "iter."):
+		return true
+	}
+	return false
+}
+
+func isNullToken(token *Token) bool {
+	return token != nil && token.Type == TKN_VAR && token.Data == "null"
+}
+
+// nullChecks reads an if condition and returns the variables it proves are not
+// null when the condition is true, and the ones it proves are not null when it is false
+func nullChecks(cond *Token) (whenTrue []string, whenFalse []string) {
+	if cond == nil {
+		return nil, nil
+	}
+	switch cond.Type {
+	case TKN_EVL:
+		if inner, ok := cond.Data.(*Token); ok {
+			return nullChecks(inner)
+		}
+	case TKN_VAR:
+		// a truthy value is never null
+		if name, ok := cond.Data.(string); ok && name != "null" {
+			return []string{name}, nil
+		}
+	case TKN_CMP:
+		var checked *Token
+		switch {
+		case isNullToken(cond.Right) && cond.Left != nil && cond.Left.Type == TKN_VAR:
+			checked = cond.Left
+		case isNullToken(cond.Left) && cond.Right != nil && cond.Right.Type == TKN_VAR:
+			checked = cond.Right
+		default:
+			return nil, nil
+		}
+		name := checked.Data.(string)
+		switch cond.Data {
+		case "!=", "!==":
+			return []string{name}, nil
+		case "==", "===":
+			return nil, []string{name}
+		}
+	case TKN_LOG:
+		leftTrue, leftFalse := nullChecks(cond.Left)
+		rightTrue, rightFalse := nullChecks(cond.Right)
+		switch cond.Data {
+		case "and":
+			return append(leftTrue, rightTrue...), nil
+		case "or":
+			return nil, append(leftFalse, rightFalse...)
+		}
+	}
+	return nil, nil
+}
+
+// blockExits reports whether a block always leaves the code around it, so the
+// code after an if x == null ( return ) can treat x as checked
+func blockExits(block [][]*Token) bool {
+	if len(block) == 0 {
+		return false
+	}
+	last := block[len(block)-1]
+	if len(last) == 0 || last[0].Type != TKN_CMD {
+		return false
+	}
+	switch last[0].Data {
+	case "return", "break", "continue", "exit", "error":
+		return true
+	}
+	return false
+}
+
+// narrow marks variables as checked for null, returning a func that undoes it
+func narrow(names []string, ctx *VariableContext) func() {
+	var added []string
+	for _, name := range names {
+		if _, nullable := ctx.Nullable[name]; nullable && !ctx.Narrowed[name] {
+			ctx.Narrowed[name] = true
+			added = append(added, name)
+		}
+	}
+	return func() {
+		for _, name := range added {
+			delete(ctx.Narrowed, name)
+		}
+	}
+}
+
+// checkNullableUse warns when a nullable variable is used in a way that fails on null
+// without checking it first
+func checkNullableUse(token *Token, ctx *VariableContext) {
+	if token == nil || token.Type != TKN_VAR || token.NonNull {
+		return
+	}
+	name, _ := token.Data.(string)
+	if _, nullable := ctx.Nullable[name]; !nullable || ctx.Narrowed[name] {
+		return
+	}
+	compileWarning(ctx, "'%v' may be null here. Check it with if %v != null, use %v?. or assert it with %v!", name, name, name, name)
+}
+
+// declareNullable records the nullability of a variable declared with a type, and
+// whether its first value already proves it is not null
+func declareNullable(name string, oslType string, value *Token, ctx *VariableContext) {
+	base, nullable := nullableBase(oslType)
+	if !nullable {
+		delete(ctx.Nullable, name)
+		delete(ctx.Narrowed, name)
+		return
+	}
+	ctx.Nullable[name] = base
+	if value != nil && isLiteralValue(value) {
+		ctx.Narrowed[name] = true
+	} else {
+		delete(ctx.Narrowed, name)
+	}
+}
+
+// isLiteralValue reports whether a token is a literal, which is never null
+func isLiteralValue(token *Token) bool {
+	switch token.Type {
+	case TKN_STR, TKN_NUM, TKN_ARR, TKN_OBJ, TKN_RAW, TKN_TSR:
+		return true
+	}
+	return false
+}
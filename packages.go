@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// oslPackage is an osl/* package, read from a package directory or built into osl.
+// A package is a Go file named after the package, starting with a header like
+//
+//	// name: greet
+//	// description: Says hello
+//	// author: someone
+//	// version: 1.0.0
+//	// requires: strings, net/http as nethttp
+//
+// followed by the code, which usually declares a var named after the package.
+type oslPackage struct {
+	name        string
+	description string
+	author      string
+	version     string
+	requires    []string
+	header      map[string]string
+	source      string
+	path        string // where the file was read from, or "" for built in packages
+	shadows     []string
+}
+
+// packageParts are files in packages/ that are loaded by osl itself rather than imported
+var packageParts = map[string]bool{
+	"std":             true,
+	"win-buttons":     true,
+	"window-gl":       true,
+	"window-headless": true,
+}
+
+// packageDirs returns the directories searched for packages, in the order they are
+// searched before the built in packages: ./osl_packages, each entry of $OSL_PATH and
+// ~/.osl/packages
+func packageDirs() []string {
+	dirs := []string{"osl_packages"}
+	for _, dir := range filepath.SplitList(os.Getenv("OSL_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".osl", "packages"))
+	}
+	return dirs
+}
+
+func (p *oslPackage) builtin() bool {
+	return p.path == ""
+}
+
+// goVar is the Go name of the var programs use the package through. It is the package
+// name unless a // var: header renames it, for packages named like a Go package they
+// require, such as math.
+func (p *oslPackage) goVar() string {
+	if name := p.header["var"]; name != "" {
+		return name
+	}
+	return p.name
+}
+
+// packageMethod is the Go name of a package method. Go keywords cannot be method names,
+// so cache.map and csv.map call the method OSLmap.
+func packageMethod(name string) string {
+	if token.IsKeyword(name) {
+		return "OSL" + name
+	}
+	return name
+}
+
+// location describes where the package came from for messages
+func (p *oslPackage) location() string {
+	if p.builtin() {
+		return "built in"
+	}
+	return p.path
+}
+
+func parsePackage(name string, source string, path string) *oslPackage {
+	p := &oslPackage{name: name, source: source, path: path, header: make(map[string]string)}
+	for _, line := range strings.Split(strings.TrimSpace(source), "\n") {
+		comment, ok := strings.CutPrefix(strings.TrimSpace(line), "//")
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(comment, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		p.header[key] = value
+		switch key {
+		case "description":
+			p.description = value
+		case "author":
+			p.author = value
+		case "version":
+			p.version = value
+		case "requires":
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					p.requires = append(p.requires, part)
+				}
+			}
+		}
+	}
+	return p
+}
+
+// findPackages returns every copy of a package, the one that is used first. Copies in
+// package directories come before the built in one, so they override it.
+func findPackages(name string) []*oslPackage {
+	var found []*oslPackage
+	for _, dir := range packageDirs() {
+		path := filepath.Join(dir, name+".go")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		found = append(found, parsePackage(name, string(data), path))
+	}
+	if data, err := packagesFS.ReadFile("packages/" + name + ".go"); err == nil {
+		found = append(found, parsePackage(name, string(data), ""))
+	}
+	return found
+}
+
+// findPackage returns the package an import of osl/name uses, with the copies it overrides
+func findPackage(name string) (*oslPackage, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid package name osl/%s", name)
+	}
+	found := findPackages(name)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("package osl/%s not found, looked in %s and the built in packages", name, strings.Join(packageDirs(), ", "))
+	}
+	for _, other := range found[1:] {
+		found[0].shadows = append(found[0].shadows, other.location())
+	}
+	return found[0], nil
+}
+
+// overrideWarning explains which copies of a package are hidden by the one in use
+func (p *oslPackage) overrideWarning() string {
+	if len(p.shadows) == 0 {
+		return ""
+	}
+	var hidden []string
+	for _, other := range p.shadows {
+		if other == "built in" {
+			hidden = append(hidden, "the built in osl/"+p.name)
+		} else {
+			hidden = append(hidden, other)
+		}
+	}
+	return fmt.Sprintf("osl/%s is loaded from %s, which overrides %s", p.name, p.path, strings.Join(hidden, " and "))
+}
+
+// allPackages lists the package used for every name, local packages first
+func allPackages() []*oslPackage {
+	names := make(map[string]bool)
+	for _, dir := range packageDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if name, ok := strings.CutSuffix(e.Name(), ".go"); ok && !e.IsDir() {
+				names[name] = true
+			}
+		}
+	}
+	entries, _ := packagesFS.ReadDir("packages")
+	for _, e := range entries {
+		if name := strings.TrimSuffix(e.Name(), ".go"); !packageParts[name] {
+			names[name] = true
+		}
+	}
+
+	var packages []*oslPackage
+	for name := range names {
+		if p, err := findPackage(name); err == nil {
+			packages = append(packages, p)
+		}
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].builtin() != packages[j].builtin() {
+			return !packages[i].builtin()
+		}
+		return packages[i].name < packages[j].name
+	})
+	return packages
+}
+
+var (
+	packageVersionRegex = regexp.MustCompile(`^v?\d+(\.\d+){0,2}(-[0-9A-Za-z.-]+)?$`)
+	packageImportRegex  = regexp.MustCompile(`^[A-Za-z0-9_.\-~/]+( as [A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+// validate returns the problems with a package, an empty list if it can be imported
+func (p *oslPackage) validate() []string {
+	var problems []string
+	switch declared := p.header["name"]; {
+	case declared == "":
+		problems = append(problems, "missing // name: header")
+	case declared != p.name:
+		problems = append(problems, fmt.Sprintf("header names it %s but the file is %s.go", declared, p.name))
+	}
+	if p.description == "" {
+		problems = append(problems, "missing // description: header")
+	}
+	if p.version != "" && !packageVersionRegex.MatchString(p.version) {
+		problems = append(problems, fmt.Sprintf("version %q is not like 1.2.3", p.version))
+	}
+	for _, req := range p.requires {
+		if !packageImportRegex.MatchString(req) {
+			problems = append(problems, fmt.Sprintf("requires %q is not an import path, optionally followed by as <alias>", req))
+		}
+	}
+
+	filename := p.path
+	if p.builtin() {
+		filename = "packages/" + p.name + ".go"
+	}
+	// the package clause goes on the first line so errors keep the file's line numbers
+	file, err := goparser.ParseFile(token.NewFileSet(), filename, "package main; "+p.source, goparser.SkipObjectResolution)
+	if err != nil {
+		problems = append(problems, strings.ReplaceAll(err.Error(), "\n", "; "))
+		return problems
+	}
+	if len(file.Imports) > 0 {
+		problems = append(problems, "has Go import statements, list imports in // requires: instead")
+	}
+	if !declaresName(file, p.goVar()) {
+		problems = append(problems, fmt.Sprintf("does not declare a top level var %s for programs to use", p.goVar()))
+	}
+	return problems
+}
+
+func declaresName(file *goast.File, name string) bool {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*goast.GenDecl)
+		if !ok || gen.Tok != token.VAR && gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, ident := range spec.(*goast.ValueSpec).Names {
+				if ident.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func pkg(args []string) {
+	if len(args) < 1 || args[0] == "list" {
+		fmt.Println("Usage: osl package [list | show <name> | validate [name or file.go...] | <name>]")
+		fmt.Println("Packages are searched for in " + strings.Join(packageDirs(), ", ") + ", then the built in packages")
+		fmt.Println()
+		for _, p := range allPackages() {
+			version := p.version
+			if version == "" {
+				version = "-"
+			}
+			fmt.Printf("  %-16s %-9s %-s\n", p.name, version, p.description)
+			fmt.Printf("  %-16s %-9s from %s\n", "", "", p.location())
+			if len(p.shadows) > 0 {
+				fmt.Printf("  %-16s %-9s overrides %s\n", "", "", strings.Join(p.shadows, ", "))
+			}
+		}
+		return
+	}
+
+	switch args[0] {
+	case "validate":
+		os.Exit(validatePackages(args[1:]))
+	case "show":
+		if len(args) < 2 {
+			fmt.Println("Usage: osl package show <name>")
+			return
+		}
+		args = args[1:]
+	}
+
+	p, err := findPackage(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if warning := p.overrideWarning(); warning != "" {
+		fmt.Fprintln(os.Stderr, "Warning: "+warning)
+	}
+	fmt.Print(p.source)
+}
+
+// validatePackages checks the named packages or package files, or every package
+// when none are given, and returns the exit code
+func validatePackages(names []string) int {
+	var packages []*oslPackage
+	if len(names) == 0 {
+		packages = allPackages()
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".go") {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			packages = append(packages, parsePackage(strings.TrimSuffix(filepath.Base(name), ".go"), string(data), name))
+			continue
+		}
+		p, err := findPackage(name)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		packages = append(packages, p)
+	}
+
+	failed := 0
+	for _, p := range packages {
+		problems := p.validate()
+		if warning := p.overrideWarning(); warning != "" {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("ok    osl/%s (%s)\n", p.name, p.location())
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  osl/%s (%s)\n", p.name, p.location())
+		for _, problem := range problems {
+			fmt.Println("        " + problem)
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
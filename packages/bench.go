@@ -0,0 +1,168 @@
+// name: bench
+// description: Runs the bench blocks of a program, used by osl bench
+// author: Mist
+// requires: flag, regexp, testing, path/filepath
+
+type OSLBenchCase struct {
+	name string
+	fn   func()
+}
+
+// OSLBenchResult is one benchmark's measurement, as saved in a baseline file
+type OSLBenchResult struct {
+	Name        string  `json:"name"`
+	N           int     `json:"n"`
+	NsPerOp     float64 `json:"ns_op"`
+	AllocsPerOp float64 `json:"allocs_op"`
+	BytesPerOp  float64 `json:"bytes_op"`
+}
+
+type OSLBenchBaseline struct {
+	OSL     string           `json:"osl"`
+	Go      string           `json:"go"`
+	Results []OSLBenchResult `json:"results"`
+}
+
+type OSLBench struct {
+	cases []OSLBenchCase
+}
+
+// Add registers a bench block, bench "name" ( ... ) compiles to a call to it
+func (b *OSLBench) Add(name any, fn func()) {
+	b.cases = append(b.cases, OSLBenchCase{name: OSLtoString(name), fn: fn})
+}
+
+// measure runs fn until its timing settles: a warm-up, then testing.Benchmark,
+// which scales the iterations until the run takes the bench time
+func (b *OSLBench) measure(c OSLBenchCase, warmup time.Duration, count int) OSLBenchResult {
+	for start := time.Now(); time.Since(start) < warmup; {
+		c.fn()
+	}
+	var runs []OSLBenchResult
+	for range count {
+		r := testing.Benchmark(func(tb *testing.B) {
+			tb.ReportAllocs()
+			for i := 0; i < tb.N; i++ {
+				c.fn()
+			}
+		})
+		runs = append(runs, OSLBenchResult{
+			Name:        c.name,
+			N:           r.N,
+			NsPerOp:     float64(r.T.Nanoseconds()) / float64(max(r.N, 1)),
+			AllocsPerOp: float64(r.MemAllocs) / float64(max(r.N, 1)),
+			BytesPerOp:  float64(r.MemBytes) / float64(max(r.N, 1)),
+		})
+	}
+	// the median run is the least affected by noise from the rest of the machine
+	sort.Slice(runs, func(i, j int) bool { return runs[i].NsPerOp < runs[j].NsPerOp })
+	return runs[len(runs)/2]
+}
+
+func (b *OSLBench) readBaseline(path string) map[string]OSLBenchResult {
+	results := map[string]OSLBenchResult{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return results
+	}
+	var baseline OSLBenchBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %s is not a baseline file: %v\n", path, err)
+		return results
+	}
+	for _, r := range baseline.Results {
+		results[r.Name] = r
+	}
+	return results
+}
+
+// saveBaseline writes the results to path, keeping the results of other benchmarks in it
+func (b *OSLBench) saveBaseline(path string, results []OSLBenchResult) error {
+	merged := b.readBaseline(path)
+	for _, r := range results {
+		merged[r.Name] = r
+	}
+	baseline := OSLBenchBaseline{OSL: OSLtoString(origin["version"]), Go: runtime.Version()}
+	for _, r := range merged {
+		baseline.Results = append(baseline.Results, r)
+	}
+	sort.Slice(baseline.Results, func(i, j int) bool { return baseline.Results[i].Name < baseline.Results[j].Name })
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Run measures every registered benchmark, reading its settings from the command line:
+// -run <regexp>, -time <duration>, -warmup <duration>, -count <n>, -save <file>, -compare <file>
+func (b *OSLBench) Run() {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	run := flags.String("run", "", "only run benchmarks whose name matches this regexp")
+	benchTime := flags.Duration("time", time.Second, "how long each measurement runs for")
+	warmup := flags.Duration("warmup", 100*time.Millisecond, "how long to run each benchmark before measuring it")
+	count := flags.Int("count", 1, "measure each benchmark this many times and report the median")
+	save := flags.String("save", "", "write the results to this baseline file")
+	compare := flags.String("compare", "", "compare the results with this baseline file")
+	flags.Parse(os.Args[1:])
+
+	testing.Init()
+	flag.Set("test.benchtime", benchTime.String())
+
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		if filter, err = regexp.Compile(*run); err != nil {
+			fmt.Fprintln(os.Stderr, "bench: invalid -run:", err)
+			os.Exit(2)
+		}
+	}
+	var baseline map[string]OSLBenchResult
+	if *compare != "" {
+		baseline = b.readBaseline(*compare)
+	}
+
+	width := 0
+	for _, c := range b.cases {
+		width = max(width, len(c.name))
+	}
+	var results []OSLBenchResult
+	for _, c := range b.cases {
+		if filter != nil && !filter.MatchString(c.name) {
+			continue
+		}
+		r := b.measure(c, *warmup, max(*count, 1))
+		results = append(results, r)
+		line := fmt.Sprintf("%-*s %12d %14.2f ns/op %10.2f allocs/op %10.0f B/op", width, r.Name, r.N, r.NsPerOp, r.AllocsPerOp, r.BytesPerOp)
+		if old, ok := baseline[r.Name]; ok && old.NsPerOp > 0 {
+			change := (r.NsPerOp - old.NsPerOp) / old.NsPerOp * 100
+			verdict := ""
+			switch {
+			case change >= 5:
+				verdict = " slower"
+			case change <= -5:
+				verdict = " faster"
+			}
+			line += fmt.Sprintf("   %+6.1f%%%s (was %.2f ns/op, %.2f allocs/op)", change, verdict, old.NsPerOp, old.AllocsPerOp)
+		} else if baseline != nil {
+			line += "   new"
+		}
+		fmt.Println(line)
+	}
+	if len(results) == 0 {
+		fmt.Println("bench: no benchmarks to run")
+		return
+	}
+	if *save != "" {
+		if err := b.saveBaseline(*save, results); err != nil {
+			fmt.Fprintln(os.Stderr, "bench: could not save the baseline:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Saved baseline to", *save)
+	}
+}
+
+var bench = &OSLBench{}
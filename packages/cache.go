@@ -166,7 +166,7 @@ func (Cache) create(capacity any, ttl any) *Cache {
 }
 
 func (Cache) createDefault() *Cache {
-	return Cache.create(100, 300)
+	return Cache{}.create(100, 300)
 }
 
 func (c *Cache) set(key any, value any) bool {
@@ -437,7 +437,8 @@ func (c *Cache) filter(fn any) map[string]any {
 	return result
 }
 
-func (c *Cache) map(fn any) map[string]any {
+// OSLmap is cache.map, map is a Go keyword so it cannot be the method name
+func (c *Cache) OSLmap(fn any) map[string]any {
 	if c == nil || c.lru == nil {
 		return map[string]any{}
 	}
@@ -1,4 +1,4 @@
-// name: color
+// name: colors
 // description: Color utilities
 // author: Mist
 // requires: image/color
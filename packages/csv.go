@@ -1,14 +1,25 @@
 // name: csv
 // description: CSV parsing and generation utilities
 // author: roturbot
-// requires: encoding/csv, strings, os
+// requires: encoding/csv as gocsv, strings, os
 
 type CSV struct{}
 
+// OSLcsvWrite writes a record to writer, which writes to out. encoding/csv writes a lone
+// empty field as a blank line, which readers skip, so it is written quoted instead.
+func OSLcsvWrite(writer *gocsv.Writer, out *strings.Builder, record []string) {
+	if len(record) == 1 && record[0] == "" {
+		writer.Flush()
+		out.WriteString("\"\"\n")
+		return
+	}
+	writer.Write(record)
+}
+
 func (CSV) parse(data any) []map[string]any {
 	dataStr := OSLtoString(data)
 
-	reader := csv.NewReader(strings.NewReader(dataStr))
+	reader := gocsv.NewReader(strings.NewReader(dataStr))
 	records, err := reader.ReadAll()
 	if err != nil {
 		return []map[string]any{}
@@ -41,7 +52,7 @@ func (CSV) parse(data any) []map[string]any {
 func (CSV) parseRaw(data any) [][]any {
 	dataStr := OSLtoString(data)
 
-	reader := csv.NewReader(strings.NewReader(dataStr))
+	reader := gocsv.NewReader(strings.NewReader(dataStr))
 	records, err := reader.ReadAll()
 	if err != nil {
 		return [][]any{}
@@ -62,7 +73,7 @@ func (CSV) parseRaw(data any) [][]any {
 func (CSV) stringify(data map[string]any) string {
 	var builder strings.Builder
 
-	writer := csv.NewWriter(&builder)
+	writer := gocsv.NewWriter(&builder)
 	headers := make([]string, 0, len(data))
 	values := make([]string, 0, len(data))
 
@@ -71,8 +82,8 @@ func (CSV) stringify(data map[string]any) string {
 		values = append(values, OSLtoString(v))
 	}
 
-	writer.Write(headers)
-	writer.Write(values)
+	OSLcsvWrite(writer, &builder, headers)
+	OSLcsvWrite(writer, &builder, values)
 	writer.Flush()
 
 	return builder.String()
@@ -84,7 +95,7 @@ func (CSV) stringifyRows(data []map[string]any) string {
 	}
 
 	var builder strings.Builder
-	writer := csv.NewWriter(&builder)
+	writer := gocsv.NewWriter(&builder)
 
 	firstRow := data[0]
 	headers := make([]string, 0, len(firstRow))
@@ -93,14 +104,14 @@ func (CSV) stringifyRows(data []map[string]any) string {
 		headers = append(headers, k)
 	}
 
-	writer.Write(headers)
+	OSLcsvWrite(writer, &builder, headers)
 
 	for _, row := range data {
 		values := make([]string, len(headers))
 		for i, header := range headers {
 			values[i] = OSLtoString(row[header])
 		}
-		writer.Write(values)
+		OSLcsvWrite(writer, &builder, values)
 	}
 
 	writer.Flush()
@@ -110,14 +121,14 @@ func (CSV) stringifyRows(data []map[string]any) string {
 
 func (CSV) stringifyArray(data [][]any) string {
 	var builder strings.Builder
-	writer := csv.NewWriter(&builder)
+	writer := gocsv.NewWriter(&builder)
 
 	for _, row := range data {
 		values := make([]string, len(row))
 		for i, field := range row {
 			values[i] = OSLtoString(field)
 		}
-		writer.Write(values)
+		OSLcsvWrite(writer, &builder, values)
 	}
 
 	writer.Flush()
@@ -126,46 +137,33 @@ func (CSV) stringifyArray(data [][]any) string {
 }
 
 func (CSV) readFile(path any) []map[string]any {
-	pathStr := OSLtoString(path)
-	data := fs.ReadFile(path)
-
-	if data == "" {
+	data, err := os.ReadFile(OSLtoString(path))
+	if err != nil {
 		return []map[string]any{}
 	}
 
-	return csv.parse(data)
+	return csv.parse(string(data))
 }
 
 func (CSV) readFileRaw(path any) [][]any {
-	pathStr := OSLtoString(path)
-	data := fs.ReadFile(path)
-
-	if data == "" {
+	data, err := os.ReadFile(OSLtoString(path))
+	if err != nil {
 		return [][]any{}
 	}
 
-	return csv.parseRaw(data)
+	return csv.parseRaw(string(data))
 }
 
 func (CSV) writeFile(path any, data map[string]any) bool {
-	pathStr := OSLtoString(path)
-	csvData := csv.stringify(data)
-
-	return fs.WriteFile(path, csvData)
+	return os.WriteFile(OSLtoString(path), []byte(csv.stringify(data)), 0644) == nil
 }
 
 func (CSV) writeFileRows(path any, data []map[string]any) bool {
-	pathStr := OSLtoString(path)
-	csvData := csv.stringifyRows(data)
-
-	return fs.WriteFile(path, csvData)
+	return os.WriteFile(OSLtoString(path), []byte(csv.stringifyRows(data)), 0644) == nil
 }
 
 func (CSV) writeFileArray(path any, data [][]any) bool {
-	pathStr := OSLtoString(path)
-	csvData := csv.stringifyArray(data)
-
-	return fs.WriteFile(path, csvData)
+	return os.WriteFile(OSLtoString(path), []byte(csv.stringifyArray(data)), 0644) == nil
 }
 
 func (CSV) toRows(data []map[string]any) [][]any {
@@ -241,7 +239,8 @@ func (CSV) filter(data []map[string]any, fn any) []map[string]any {
 	return result
 }
 
-func (CSV) map(data []map[string]any, fn any) []map[string]any {
+// OSLmap is csv.map, map is a Go keyword so it cannot be the method name
+func (CSV) OSLmap(data []map[string]any, fn any) []map[string]any {
 	result := make([]map[string]any, len(data))
 
 	for i, row := range data {
@@ -384,7 +383,6 @@ func (CSV) pivot(data []map[string]any, keyColumn any, valueColumn any, pivotCol
 	valCol := OSLtoString(valueColumn)
 	pivotCol := OSLtoString(pivotColumn)
 
-	pivotValues := csv.unique(data, pivotCol)
 	result := make(map[string]map[string]any)
 
 	for _, row := range data {
@@ -431,28 +429,25 @@ func (CSV) stats(data []map[string]any, key any) map[string]any {
 		return map[string]any{}
 	}
 
-	numValues := make([]float64, 0)
+	minValue, maxValue, sum := math.Inf(1), math.Inf(-1), 0.0
 	for _, v := range values {
-		numValues = append(numValues, OSLcastNumber(v))
-	}
-
-	if len(numValues) == 0 {
-		return map[string]any{
-			"count": len(values),
-		}
+		num := OSLcastNumber(v)
+		minValue = math.Min(minValue, num)
+		maxValue = math.Max(maxValue, num)
+		sum += num
 	}
 
 	return map[string]any{
 		"count":   len(values),
-		"min":     math.min(numValues...),
-		"max":     math.max(numValues...),
-		"average": math.avg(numValues),
-		"sum":     math.sum(numValues),
+		"min":     minValue,
+		"max":     maxValue,
+		"average": sum / float64(len(values)),
+		"sum":     sum,
 	}
 }
 
 func (CSV) merge(data1 []map[string]any, data2 []map[string]any) []map[string]any {
-	result := append(data1, data2)
+	result := append(append([]map[string]any{}, data1...), data2...)
 	return result
 }
 
@@ -511,15 +506,9 @@ func (CSV) sample(data []map[string]any, count any) []map[string]any {
 		return result
 	}
 
-	result := make([]map[string]any, countInt)
-	indices := make(map[int]bool)
-
-	for len(result) < countInt {
-		randIndex := OSLrandomInt(0, len(data)-1)
-		if !indices[randIndex] {
-			indices[randIndex] = true
-			result[len(result)] = data[randIndex]
-		}
+	result := make([]map[string]any, 0, max(countInt, 0))
+	for _, i := range OSLrand.Perm(len(data))[:max(countInt, 0)] {
+		result = append(result, data[i])
 	}
 
 	return result
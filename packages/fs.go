@@ -6,7 +6,7 @@
 type FS struct{}
 
 func (FS) ReadFile(path any) string {
-	data, err := os.ReadFile(OSLtoString(path))
+	data, err := OSLreadFile(OSLtoString(path))
 	if err != nil {
 		return ""
 	}
@@ -14,7 +14,7 @@ func (FS) ReadFile(path any) string {
 }
 
 func (FS) ReadFileBytes(path any) []byte {
-	data, err := os.ReadFile(OSLtoString(path))
+	data, err := OSLreadFile(OSLtoString(path))
 	if err != nil {
 		return []byte{}
 	}
@@ -43,7 +43,7 @@ func (FS) Rename(oldPath any, newPath any) bool {
 }
 
 func (FS) Exists(path any) bool {
-	_, err := os.Stat(OSLtoString(path))
+	_, err := OSLstat(OSLtoString(path))
 	return err == nil
 }
 
@@ -129,7 +129,7 @@ func (FS) CopyDir(srcPath any, dstPath any) bool {
 }
 
 func (FS) ReadDir(path any) []any {
-	files, err := os.ReadDir(OSLtoString(path))
+	files, err := OSLreadDir(OSLtoString(path))
 	if err != nil {
 		return []any{}
 	}
@@ -142,7 +142,7 @@ func (FS) ReadDir(path any) []any {
 
 func (FS) ReadDirAll(path any) []map[string]any {
 	dir := OSLtoString(path)
-	entries, err := os.ReadDir(dir)
+	entries, err := OSLreadDir(dir)
 	if err != nil {
 		return []map[string]any{}
 	}
@@ -197,7 +197,7 @@ func (FS) WalkDir(path any, fn func(path string, file map[string]any, control ma
 }
 
 func (FS) IsDir(path any) bool {
-	info, err := os.Stat(OSLtoString(path))
+	info, err := OSLstat(OSLtoString(path))
 	if err != nil {
 		return false
 	}
@@ -243,7 +243,7 @@ func (FS) GetParts(path any) []any {
 }
 
 func (FS) GetSize(path any) float64 {
-	info, err := os.Stat(OSLtoString(path))
+	info, err := OSLstat(OSLtoString(path))
 	if err != nil {
 		return 0
 	}
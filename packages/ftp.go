@@ -1,7 +1,7 @@
 // name: ftp
 // description: FTP file transfer protocol
 // author: roturbot
-// requires: io, os, strings, time
+// requires: io, os, path/filepath, strconv, strings, time
 
 type FTP struct {
 	host     string
@@ -245,7 +245,7 @@ func (FTP) uploadDirectory(localDir any, remoteDir any) bool {
 	localDirStr := OSLtoString(localDir)
 	remoteDirStr := OSLtoString(remoteDir)
 
-	if !fs Exists(localDirStr) {
+	if !fs.Exists(localDirStr) {
 		return false
 	}
 
@@ -283,7 +283,7 @@ func (FTP) downloadDirectory(remoteDir any, localDir any) bool {
 	for _, file := range files {
 		fileInfo, _ := file.(map[string]any)
 		name := fileInfo["name"]
-		isDir := fileInfo["isDir"]
+		isDir, _ := fileInfo["isDir"].(bool)
 
 		remotePath := filepath.Join(remoteDirStr, OSLtoString(name))
 		localPath := filepath.Join(localDirStr, OSLtoString(name))
@@ -0,0 +1,513 @@
+// name: fuzz
+// description: Runs the fuzz blocks of a program as Go fuzz targets and property tests, used by osl fuzz
+// author: Mist
+// requires: flag, maps, regexp, testing, crypto/sha256, encoding/hex, path/filepath
+
+type OSLFuzzCase struct {
+	name   string
+	target string
+	params []string
+	fn     reflect.Value
+	types  []reflect.Type
+}
+
+type OSLFuzz struct {
+	cases []OSLFuzzCase
+}
+
+// OSLFuzzGen makes random values of the osl types, size bounds how long and deep they get
+type OSLFuzzGen struct {
+	rand *OSLrand.Rand
+	size int
+}
+
+// the characters parsers tend to trip over: quotes, separators, brackets, escapes and multi byte runes
+var OSLfuzzRunes = []rune("\"',;:\\/<>&=[]{}()\n\r\t #0123456789-+.eE_éß€😀\x00")
+
+func (g *OSLFuzzGen) string() string {
+	n := g.rand.Intn(g.size + 1)
+	var b strings.Builder
+	for range n {
+		if g.rand.Intn(3) == 0 {
+			b.WriteRune(OSLfuzzRunes[g.rand.Intn(len(OSLfuzzRunes))])
+		} else {
+			b.WriteByte(byte(' ' + g.rand.Intn(95)))
+		}
+	}
+	return b.String()
+}
+
+func (g *OSLFuzzGen) int() int {
+	switch g.rand.Intn(10) {
+	case 0:
+		return []int{0, 1, -1, math.MaxInt64, math.MinInt64}[g.rand.Intn(5)]
+	case 1:
+		return int(g.rand.Uint64())
+	default:
+		return g.rand.Intn(2*g.size+1) - g.size
+	}
+}
+
+func (g *OSLFuzzGen) number() float64 {
+	switch g.rand.Intn(10) {
+	case 0:
+		special := []float64{0, 0.1, -0.5, 1e-9, 1e21, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1), math.NaN()}
+		return special[g.rand.Intn(len(special))]
+	default:
+		return g.finite()
+	}
+}
+
+// finite is a number that fits in json, for the numbers in arrays and objects
+func (g *OSLFuzzGen) finite() float64 {
+	if g.rand.Intn(2) == 0 {
+		return float64(g.rand.Intn(2*g.size+1) - g.size)
+	}
+	return (g.rand.Float64()*2 - 1) * float64(g.size)
+}
+
+func (g *OSLFuzzGen) array(depth int) []any {
+	out := []any{}
+	for range g.rand.Intn(g.size/2 + 1) {
+		out = append(out, g.any(depth+1))
+	}
+	return out
+}
+
+func (g *OSLFuzzGen) object(depth int) map[string]any {
+	out := map[string]any{}
+	for range g.rand.Intn(g.size/2 + 1) {
+		key := (&OSLFuzzGen{rand: g.rand, size: min(g.size, 8)}).string()
+		out[key] = g.any(depth + 1)
+	}
+	return out
+}
+
+func (g *OSLFuzzGen) any(depth int) any {
+	kinds := 6
+	if depth >= 3 {
+		kinds = 4
+	}
+	switch g.rand.Intn(kinds) {
+	case 0:
+		return g.finite()
+	case 1:
+		return g.string()
+	case 2:
+		return g.rand.Intn(2) == 0
+	case 3:
+		return nil
+	case 4:
+		return g.array(depth)
+	default:
+		return g.object(depth)
+	}
+}
+
+func (g *OSLFuzzGen) value(t reflect.Type) any {
+	switch t.Kind() {
+	case reflect.String:
+		return g.string()
+	case reflect.Int:
+		return g.int()
+	case reflect.Float64:
+		return g.number()
+	case reflect.Bool:
+		return g.rand.Intn(2) == 0
+	case reflect.Slice:
+		return g.array(0)
+	case reflect.Map:
+		return g.object(0)
+	default:
+		return g.any(0)
+	}
+}
+
+// Add registers a fuzz block, fuzz "name" (string s) ( ... ) compiles to a call to it
+func (z *OSLFuzz) Add(name any, target string, params []string, fn any) {
+	c := OSLFuzzCase{name: OSLtoString(name), target: target, params: params, fn: reflect.ValueOf(fn)}
+	for i := range c.fn.Type().NumIn() {
+		t := c.fn.Type().In(i)
+		switch t.Kind() {
+		case reflect.String, reflect.Int, reflect.Float64, reflect.Bool, reflect.Interface:
+		case reflect.Slice, reflect.Map:
+			if t.Elem().Kind() != reflect.Interface {
+				panic(fmt.Sprintf("fuzz %q: parameters can be string, number, int, boolean, array or object, not %v", c.name, t))
+			}
+		default:
+			panic(fmt.Sprintf("fuzz %q: parameters can be string, number, int, boolean, array or object, not %v", c.name, t))
+		}
+		c.types = append(c.types, t)
+	}
+	z.cases = append(z.cases, c)
+}
+
+// check fails the fuzz block when condition is false, eg. fuzz.check(csv.parse(s).len > 0, "no rows")
+func (z *OSLFuzz) check(condition any, message ...any) {
+	if !OSLcastBool(condition) {
+		if len(message) == 0 {
+			message = []any{"check failed"}
+		}
+		z.fail(message...)
+	}
+}
+
+// fail stops the fuzz block and reports the input that got there as a failure
+func (z *OSLFuzz) fail(message ...any) {
+	parts := make([]string, len(message))
+	for i, m := range message {
+		parts[i] = OSLtoString(m)
+	}
+	panic(strings.Join(parts, " "))
+}
+
+// call runs a fuzz block, a panic in it is a failure
+func (z *OSLFuzz) call(c OSLFuzzCase, args []any) (failure string, failed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure, failed = fmt.Sprint(r), true
+		}
+	}()
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			in[i] = reflect.Zero(c.types[i])
+		} else {
+			in[i] = reflect.ValueOf(arg)
+		}
+	}
+	c.fn.Call(in)
+	return "", false
+}
+
+// fuzzType is the type go test fuzzes for a parameter, arrays and objects are fuzzed as bytes
+func (z *OSLFuzz) fuzzType(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Float64, reflect.Bool:
+		return t
+	}
+	return reflect.TypeOf([]byte(nil))
+}
+
+// encode turns a value into what go test fuzzes for its type, arrays and objects as json
+func (z *OSLFuzz) encode(t reflect.Type, v any) any {
+	if z.fuzzType(t) == t {
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return []byte{}
+	}
+	return data
+}
+
+// decode turns fuzzed bytes into an array or object: json of the right shape is used as is,
+// anything else seeds the generator, so every input the fuzzer tries picks a value
+func (z *OSLFuzz) decode(t reflect.Type, data []byte) any {
+	var v any
+	if json.Unmarshal(data, &v) == nil && (t.Kind() == reflect.Interface || (v != nil && reflect.TypeOf(v) == t)) {
+		return v
+	}
+	seed := int64(len(data))
+	for _, b := range data {
+		seed = seed*31 + int64(b)
+	}
+	g := &OSLFuzzGen{rand: OSLrand.New(OSLrand.NewSource(seed)), size: min(len(data), 64)}
+	return g.value(t)
+}
+
+// Target runs a fuzz block as the go fuzz target f, osl fuzz generates a FuzzX function for each block that calls it
+func (z *OSLFuzz) Target(f *testing.F, name string) {
+	var c OSLFuzzCase
+	found := false
+	for _, fc := range z.cases {
+		if fc.name == name {
+			c, found = fc, true
+		}
+	}
+	if !found {
+		f.Fatalf("no fuzz block named %q", name)
+	}
+
+	in := []reflect.Type{reflect.TypeOf((*testing.T)(nil))}
+	for _, t := range c.types {
+		in = append(in, z.fuzzType(t))
+	}
+	// a few generated inputs give the fuzzer somewhere to start, next to the corpus
+	g := &OSLFuzzGen{rand: OSLrand.New(OSLrand.NewSource(1)), size: 8}
+	for range 8 {
+		var seed []any
+		for _, t := range c.types {
+			seed = append(seed, z.encode(t, g.value(t)))
+		}
+		f.Add(seed...)
+	}
+	target := reflect.MakeFunc(reflect.FuncOf(in, nil, false), func(values []reflect.Value) []reflect.Value {
+		args := make([]reflect.Value, len(c.types))
+		for i, t := range c.types {
+			if z.fuzzType(t) == t {
+				args[i] = values[i+1]
+				continue
+			}
+			v := z.decode(t, values[i+1].Bytes())
+			if v == nil {
+				args[i] = reflect.Zero(t)
+			} else {
+				args[i] = reflect.ValueOf(v)
+			}
+		}
+		c.fn.Call(args)
+		return nil
+	})
+	f.Fuzz(target.Interface())
+}
+
+// shrinks returns simpler versions of v, the simplest first
+func (z *OSLFuzz) shrinks(v any) []any {
+	var out []any
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		runes := []rune(v)
+		out = append(out, "")
+		for chunk := len(runes) / 2; chunk > 0; chunk /= 2 {
+			for i := 0; i+chunk <= len(runes) && len(out) < 64; i += chunk {
+				out = append(out, string(runes[:i])+string(runes[i+chunk:]))
+			}
+		}
+		for i, r := range runes {
+			if r != 'a' && len(out) < 128 {
+				out = append(out, string(runes[:i])+"a"+string(runes[i+1:]))
+			}
+		}
+	case int:
+		if v == 0 {
+			return nil
+		}
+		out = append(out, 0, v/2)
+		if v < 0 {
+			out = append(out, -v, v+1)
+		} else {
+			out = append(out, v-1)
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+		out = append(out, 0.0)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return out
+		}
+		if math.Trunc(v) != v {
+			out = append(out, math.Trunc(v))
+		}
+		if math.Abs(v) > 1 {
+			out = append(out, v/2)
+		}
+		if v < 0 {
+			out = append(out, -v)
+		}
+	case bool:
+		if v {
+			out = append(out, false)
+		}
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+		out = append(out, []any{})
+		for chunk := len(v) / 2; chunk > 0; chunk /= 2 {
+			for i := 0; i+chunk <= len(v) && len(out) < 64; i += chunk {
+				out = append(out, append(append([]any{}, v[:i]...), v[i+chunk:]...))
+			}
+		}
+		for i, item := range v {
+			for _, smaller := range z.shrinks(item) {
+				next := append([]any{}, v...)
+				next[i] = smaller
+				out = append(out, next)
+			}
+		}
+	case map[string]any:
+		if len(v) == 0 {
+			return nil
+		}
+		out = append(out, map[string]any{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			next := maps.Clone(v)
+			delete(next, key)
+			out = append(out, next)
+		}
+		for _, key := range keys {
+			for _, smaller := range z.shrinks(v[key]) {
+				next := maps.Clone(v)
+				next[key] = smaller
+				out = append(out, next)
+			}
+		}
+	case nil:
+		return nil
+	default:
+		out = append(out, nil)
+	}
+	return out
+}
+
+// shrink looks for the simplest arguments that still fail, one simpler argument at a time
+func (z *OSLFuzz) shrink(c OSLFuzzCase, args []any, failure string) ([]any, string, int) {
+	steps, attempts := 0, 0
+	for attempts < 5000 {
+		improved := false
+		for i := range args {
+			for _, candidate := range z.shrinks(args[i]) {
+				if candidate == nil && c.types[i].Kind() != reflect.Interface {
+					continue
+				}
+				attempts++
+				next := append([]any{}, args...)
+				next[i] = candidate
+				if message, failed := z.call(c, next); failed {
+					args, failure, improved = next, message, true
+					steps++
+					break
+				}
+			}
+			if improved {
+				break
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+	return args, failure, steps
+}
+
+func (z *OSLFuzz) format(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case nil:
+		return "null"
+	case []any, map[string]any:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// corpusEntry writes arguments in go test's corpus format, so go test replays them
+func (z *OSLFuzz) corpusEntry(c OSLFuzzCase, args []any) []byte {
+	var b strings.Builder
+	b.WriteString("go test fuzz v1\n")
+	for i, t := range c.types {
+		switch v := z.encode(t, args[i]).(type) {
+		case string:
+			fmt.Fprintf(&b, "string(%q)\n", v)
+		case int:
+			fmt.Fprintf(&b, "int(%d)\n", v)
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) || (v == 0 && math.Signbit(v)) {
+				fmt.Fprintf(&b, "math.Float64frombits(0x%x)\n", math.Float64bits(v))
+			} else {
+				fmt.Fprintf(&b, "float64(%v)\n", v)
+			}
+		case bool:
+			fmt.Fprintf(&b, "bool(%v)\n", v)
+		case []byte:
+			fmt.Fprintf(&b, "[]byte(%q)\n", v)
+		}
+	}
+	return []byte(b.String())
+}
+
+// Run tests every registered fuzz block as a property against generated inputs, reading its settings
+// from the command line: -run <regexp>, -runs <n>, -seed <n>, -corpus <dir>
+func (z *OSLFuzz) Run() {
+	// under go test the FuzzX functions run the blocks instead
+	if testing.Testing() {
+		return
+	}
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	run := flags.String("run", "", "only run fuzz blocks whose name matches this regexp")
+	runs := flags.Int("runs", 1000, "how many inputs to try in each fuzz block")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed for the generated inputs")
+	corpus := flags.String("corpus", "", "save failing inputs to this corpus directory")
+	flags.Parse(os.Args[1:])
+
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		if filter, err = regexp.Compile(*run); err != nil {
+			fmt.Fprintln(os.Stderr, "fuzz: invalid -run:", err)
+			os.Exit(2)
+		}
+	}
+
+	width := 0
+	for _, c := range z.cases {
+		width = max(width, len(c.name))
+	}
+	fmt.Printf("seed %d\n", *seed)
+	rng := OSLrand.New(OSLrand.NewSource(*seed))
+	failed, ran := false, 0
+	for _, c := range z.cases {
+		if filter != nil && !filter.MatchString(c.name) {
+			continue
+		}
+		ran++
+		total := max(*runs, 1)
+		passed := true
+		for i := range total {
+			// inputs start small and grow, so the first failure found is usually a simple one
+			g := &OSLFuzzGen{rand: rng, size: 1 + i*99/max(total-1, 1)}
+			args := make([]any, len(c.types))
+			for j, t := range c.types {
+				args[j] = g.value(t)
+			}
+			failure, isFailure := z.call(c, args)
+			if !isFailure {
+				continue
+			}
+			passed, failed = false, true
+			args, failure, steps := z.shrink(c, args, failure)
+			fmt.Printf("FAIL  %-*s after %d runs, shrunk %d times: %s\n", width, c.name, i+1, steps, failure)
+			for j := range args {
+				fmt.Printf("        %s = %s\n", c.params[j], z.format(args[j]))
+			}
+			if *corpus != "" {
+				entry := z.corpusEntry(c, args)
+				sum := sha256.Sum256(entry)
+				path := filepath.Join(*corpus, c.target, hex.EncodeToString(sum[:8]))
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := os.WriteFile(path, entry, 0644); err != nil {
+					fmt.Fprintln(os.Stderr, "fuzz: could not save the failing input:", err)
+				} else {
+					fmt.Printf("        saved to %s\n", path)
+				}
+			}
+			break
+		}
+		if passed {
+			fmt.Printf("ok    %-*s %d runs\n", width, c.name, total)
+		}
+	}
+	if ran == 0 {
+		fmt.Println("fuzz: no fuzz blocks to run")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+var fuzz = &OSLFuzz{}
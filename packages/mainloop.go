@@ -0,0 +1,64 @@
+// name: mainloop
+// description: Fixed timestep loop for mainloop: in programs that do not draw, like servers and simulations
+// author: Mist
+// requires: os/signal, syscall
+
+type OSLtickLoop struct {
+	delta   float64 // how many seconds each tick simulates, 1 / the tick rate
+	stopped bool
+	mu      sync.Mutex
+}
+
+var mainloop = &OSLtickLoop{}
+
+// run calls tick rate times a second until mainloop.stop() is called or the
+// program is interrupted. Ticks that run late are caught up on, so the simulation
+// keeps pace with real time, but a loop that falls far behind skips ahead rather
+// than running many ticks at once.
+func (l *OSLtickLoop) run(rate float64, tick func()) {
+	interval := time.Duration(float64(time.Second) / rate)
+	l.delta = 1 / rate
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	next := time.Now()
+	for !l.done() {
+		OSLupdateTimer()
+		tick()
+
+		next = next.Add(interval)
+		wait := time.Until(next)
+		if wait < -5*interval {
+			next = time.Now()
+			wait = 0
+		}
+		if wait > 0 {
+			select {
+			case <-sig:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		select {
+		case <-sig:
+			return
+		default:
+		}
+	}
+}
+
+func (l *OSLtickLoop) done() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+// stop ends the mainloop once the current tick finishes
+func (l *OSLtickLoop) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+}
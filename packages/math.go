@@ -1,7 +1,8 @@
 // name: math
 // description: Advanced mathematical utilities
 // author: roturbot
-// requires: math, math/cmplx, math/rand
+// requires: math, math/rand
+// var: OSLmath
 
 type Math struct{}
 
@@ -135,7 +136,7 @@ func (Math) avg(numbers []any) float64 {
 	if len(numbers) == 0 {
 		return 0
 	}
-	return math.sum(numbers) / float64(len(numbers))
+	return OSLmath.sum(numbers) / float64(len(numbers))
 }
 
 func (Math) median(numbers []any) float64 {
@@ -185,7 +186,7 @@ func (Math) stdDev(numbers []any) float64 {
 		return 0
 	}
 
-	mean := math.avg(numbers)
+	mean := OSLmath.avg(numbers)
 	variance := 0.0
 
 	for _, n := range numbers {
@@ -202,7 +203,7 @@ func (Math) variance(numbers []any) float64 {
 		return 0
 	}
 
-	mean := math.avg(numbers)
+	mean := OSLmath.avg(numbers)
 	variance := 0.0
 
 	for _, n := range numbers {
@@ -213,7 +214,7 @@ func (Math) variance(numbers []any) float64 {
 	return variance / float64(len(numbers))
 }
 
-func (Math) range(numbers []any) float64 {
+func (Math) spread(numbers []any) float64 {
 	if len(numbers) == 0 {
 		return 0
 	}
@@ -287,7 +288,7 @@ func (Math) lcm(a any, b any) int {
 		return 0
 	}
 
-	return (aVal * bVal) / math.gcd(aVal, bVal)
+	return (aVal * bVal) / OSLmath.gcd(aVal, bVal)
 }
 
 func (Math) isPrime(n any) bool {
@@ -355,7 +356,7 @@ func (Math) random(min any, max any) float64 {
 		minVal, maxVal = maxVal, minVal
 	}
 
-	return minVal + (rand.Float64() * (maxVal - minVal))
+	return minVal + (OSLrand.Float64() * (maxVal - minVal))
 }
 
 func (Math) randomInt(min any, max any) int {
@@ -366,19 +367,19 @@ func (Math) randomInt(min any, max any) int {
 		minVal, maxVal = maxVal, minVal
 	}
 
-	return minVal + rand.Intn(maxVal-minVal+1)
+	return minVal + OSLrand.Intn(maxVal-minVal+1)
 }
 
 func (Math) randomChoice(choices []any) any {
 	if len(choices) == 0 {
 		return nil
 	}
-	return choices[math.randomInt(0, len(choices)-1)]
+	return choices[OSLmath.randomInt(0, len(choices)-1)]
 }
 
 func (Math) randomSeed(seed any) {
 	seedVal := int64(OSLcastNumber(seed))
-	rand.Seed(seedVal)
+	OSLrand.Seed(seedVal)
 }
 
 func (Math) hypot(x any, y any) float64 {
@@ -406,7 +407,7 @@ func (Math) isNan(x any) bool {
 	return math.IsNaN(OSLcastNumber(x))
 }
 
-func (Math) isInf(x any) int {
+func (Math) isInf(x any) bool {
 	return math.IsInf(OSLcastNumber(x), 0)
 }
 
@@ -448,4 +449,4 @@ func (Math) toPercent(x any, total any) float64 {
 	return (value / totalVal) * 100
 }
 
-var math = Math{}
+var OSLmath = Math{}
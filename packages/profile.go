@@ -0,0 +1,90 @@
+// name: profile
+// description: pprof CPU, heap, block and mutex profiling
+// author: Mist
+// requires: fmt, os, os/signal, path/filepath, runtime, runtime/pprof, sync, syscall
+
+type Profile struct {
+	dir     string
+	cpuFile *os.File
+	running bool
+	mu      sync.Mutex
+}
+
+func (p *Profile) start(dir any) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running {
+		return false
+	}
+
+	p.dir = OSLtoString(dir)
+	if p.dir == "" {
+		p.dir = "."
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "profile: failed to create directory:", err)
+		return false
+	}
+
+	f, err := os.Create(filepath.Join(p.dir, "cpu.pprof"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "profile: failed to create cpu profile:", err)
+		return false
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		fmt.Fprintln(os.Stderr, "profile: failed to start cpu profile:", err)
+		return false
+	}
+
+	runtime.SetBlockProfileRate(1)
+	runtime.SetMutexProfileFraction(1)
+
+	p.cpuFile = f
+	p.running = true
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		p.stop()
+		os.Exit(130)
+	}()
+
+	return true
+}
+
+func (p *Profile) stop() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return false
+	}
+	p.running = false
+
+	pprof.StopCPUProfile()
+	p.cpuFile.Close()
+
+	runtime.GC()
+	for _, name := range []string{"heap", "block", "mutex"} {
+		f, err := os.Create(filepath.Join(p.dir, name+".pprof"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "profile: failed to create "+name+" profile:", err)
+			continue
+		}
+		if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+			fmt.Fprintln(os.Stderr, "profile: failed to write "+name+" profile:", err)
+		}
+		f.Close()
+	}
+
+	runtime.SetBlockProfileRate(0)
+	runtime.SetMutexProfileFraction(0)
+
+	fmt.Fprintln(os.Stderr, "profile: wrote cpu, heap, block and mutex profiles to", p.dir)
+	return true
+}
+
+var profile = &Profile{}
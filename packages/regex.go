@@ -312,14 +312,32 @@ func (Regex) maskPhoneNumber(phone any) string {
 	return masked
 }
 
+// OSLregexColors are the colours highlight knows, the basic ones of tui.Color
+var OSLregexColors = map[string]string{
+	"black":   "\033[30m",
+	"red":     "\033[31m",
+	"green":   "\033[32m",
+	"yellow":  "\033[33m",
+	"blue":    "\033[34m",
+	"magenta": "\033[35m",
+	"purple":  "\033[35m",
+	"cyan":    "\033[36m",
+	"white":   "\033[37m",
+	"gray":    "\033[90m",
+	"grey":    "\033[90m",
+}
+
 func (Regex) highlight(text any, pattern any, color any) string {
 	textStr := OSLtoString(text)
 	patternStr := OSLtoString(pattern)
-	colorStr := OSLtoString(color)
+	colorCode, ok := OSLregexColors[strings.ToLower(OSLtoString(color))]
+	if !ok {
+		return textStr
+	}
 
 	re := regexp.MustCompile(`(` + patternStr + `)`)
 	result := re.ReplaceAllStringFunc(textStr, func(match string) string {
-		return tui.Color(colorStr, match)
+		return colorCode + match + "\033[0m"
 	})
 
 	return result
@@ -1,7 +1,7 @@
 // name: serve
 // description: Gin-like HTTP server framework for OSL
 // author: Mist
-// requires: encoding/json, sync, time, strings, fmt
+// requires: encoding/json, sync, time, strings, fmt, net/http
 
 type HttpContext struct {
 	w http.ResponseWriter
@@ -211,7 +211,15 @@ func (rt *HttpRouter) Use(mw func(*HttpContext) bool) {
 }
 
 func (rt *HttpRouter) Static(prefix, dir string) {
-	rt.mux.Handle(prefix+"/", http.StripPrefix(prefix, http.FileServer(http.Dir(dir))))
+	var files http.FileSystem = http.Dir(dir)
+	if _, err := os.Stat(dir); err != nil && OSLembedded != nil {
+		if sub, err := OSLiofs.Sub(OSLembedded, OSLembedPath(dir)); err == nil {
+			if _, err := OSLiofs.Stat(sub, "."); err == nil {
+				files = http.FS(sub)
+			}
+		}
+	}
+	rt.mux.Handle(prefix+"/", http.StripPrefix(prefix, http.FileServer(files)))
 }
 
 func (rt *HttpRouter) Group(prefix string) *HttpRouter {
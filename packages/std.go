@@ -1,8 +1,12 @@
 // This is a set of funtions that are used in the compiler for OSL.go
 
+// OSLgamepadSource reads the connected gamepads, osl/window sets it when a window opens
+var OSLgamepadSource func() []any
+
 func getGamepads() []any {
-	// Stub implementation - returns empty array
-	// This should be implemented with actual gamepad support
+	if OSLgamepadSource != nil {
+		return OSLgamepadSource()
+	}
 	return []any{}
 }
 
@@ -33,6 +37,12 @@ func OSLlen(s any) int {
 		return len(s)
 	case []OSLio.Reader:
 		return len(s)
+	case *SafeMap[string, any]:
+		return s.Len()
+	case *SafeSlice[any]:
+		return s.Len()
+	case OSLRange:
+		return s.Len()
 	}
 	v := reflect.ValueOf(s)
 	if v.Kind() == reflect.Ptr {
@@ -126,6 +136,8 @@ func OSLtoString(s any) string {
 		return JsonStringify(s)
 	case map[string]any, map[string]string, map[string]int, map[string]float64, map[string]bool:
 		return JsonStringify(s)
+	case *SafeMap[string, any], *SafeSlice[any], *OSLWorker, OSLRange:
+		return JsonStringify(s)
 	case OSLio.Reader:
 		data, err := OSLio.ReadAll(s)
 		if err != nil {
@@ -139,6 +151,9 @@ func OSLtoString(s any) string {
 	case float64:
 		return strconv.FormatFloat(s, 'f', -1, 64)
 	default:
+		if hook := OSLhook(s, "__str"); hook != nil {
+			return OSLtoString(OSLcallFunc(hook, nil, nil))
+		}
 		return fmt.Sprintf("%v", s)
 	}
 }
@@ -151,6 +166,9 @@ func OSLcastObject(s any) map[string]any {
 	if ok {
 		return obj
 	}
+	if sm, ok := s.(*SafeMap[string, any]); ok {
+		return sm.Snapshot()
+	}
 	panic("OSLcastObject, invalid type: " + reflect.TypeOf(s).String())
 
 }
@@ -162,6 +180,19 @@ func OSLcastArray(values ...any) []any {
 		if arr, ok := v.([]any); ok {
 			return arr
 		}
+		if ss, ok := v.(*SafeSlice[any]); ok {
+			return ss.Values()
+		}
+		if r, ok := v.(OSLRange); ok {
+			return r.Array()
+		}
+		if gen, ok := v.(iter.Seq[any]); ok {
+			out := []any{}
+			for item := range gen {
+				out = append(out, item)
+			}
+			return out
+		}
 
 		rv := reflect.ValueOf(v)
 
@@ -190,6 +221,9 @@ func OSLequal(a any, b any) bool {
 	if a == b {
 		return true
 	}
+	if hook := OSLhook(a, "__eq"); hook != nil {
+		return OSLcastBool(OSLcallFunc(hook, nil, []any{b}))
+	}
 	return strings.EqualFold(OSLtoString(a), OSLtoString(b))
 }
 
@@ -197,9 +231,42 @@ func OSLnotEqual(a any, b any) bool {
 	if a == b {
 		return false
 	}
+	if hook := OSLhook(a, "__eq"); hook != nil {
+		return !OSLcastBool(OSLcallFunc(hook, nil, []any{b}))
+	}
 	return !strings.EqualFold(OSLtoString(a), OSLtoString(b))
 }
 
+// OSLnonNull returns a value asserted not to be null with !, and stops the program
+// naming the value when it is null after all
+func OSLnonNull[T any](v T, name string) T {
+	if OSLisNull(any(v)) {
+		panic(name + " is null")
+	}
+	return v
+}
+
+// OSLoptional calls next with v unless v is null, for ?. chains that call methods
+func OSLoptional(v any, next func(any) any) any {
+	if OSLisNull(v) {
+		return nil
+	}
+	return next(v)
+}
+
+// OSLisNull reports whether a value is null, including nil pointers, maps and slices stored in an any
+func OSLisNull(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
 func OSLcastInt(i any) int {
 	if i == nil {
 		return 0
@@ -242,6 +309,7 @@ func OSLlogValues(values ...any) {
 func OSLlog(v any) {
 	if v == nil {
 		fmt.Println("null")
+		return
 	}
 	switch v := v.(type) {
 	case *SafeMap[string, any]:
@@ -269,29 +337,7 @@ func OSLlog(v any) {
 		return
 	default:
 		fmt.Println(OSLtoString(v))
-		return
 	}
-
-	rv := reflect.ValueOf(v)
-	if rv.Kind() == reflect.Ptr {
-		if rv.IsNil() {
-			fmt.Println("null")
-			return
-		}
-		rv = rv.Elem()
-	}
-
-	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
-		fmt.Println(JsonStringify(OSLcastArray(v)))
-		return
-	}
-
-	if rv.Kind() == reflect.Map {
-		fmt.Println(JsonStringify(OSLcastObject(v)))
-		return
-	}
-
-	fmt.Println(v)
 }
 
 func OSLisFunc(v any) bool {
@@ -387,6 +433,9 @@ func OSLsort(arr []any) []any {
 	}
 
 	sort.Slice(arr, func(i, j int) bool {
+		if hook := OSLhook(arr[i], "__lt"); hook != nil {
+			return OSLcastBool(OSLcallFunc(hook, nil, []any{arr[j]}))
+		}
 		return OSLtoString(arr[i]) < OSLtoString(arr[j])
 	})
 	return arr
@@ -434,6 +483,9 @@ func OSLsortBy(arr []any, key any) []any {
 }
 
 func OSLless(a any, b any) bool {
+	if hook := OSLhook(a, "__lt"); hook != nil {
+		return OSLcastBool(OSLcallFunc(hook, nil, []any{b}))
+	}
 	if a == b {
 		return false
 	}
@@ -441,6 +493,9 @@ func OSLless(a any, b any) bool {
 }
 
 func OSLgreater(a any, b any) bool {
+	if hook := OSLhook(b, "__lt"); hook != nil {
+		return OSLcastBool(OSLcallFunc(hook, nil, []any{a}))
+	}
 	if a == b {
 		return false
 	}
@@ -603,6 +658,9 @@ func OSLgetItem(a any, b any) any {
 		return nil
 	}
 
+	if w, ok := a.(*OSLWorker); ok {
+		a = w.state
+	}
 	if sm, ok := a.(*SafeMap[string, any]); ok {
 		val, _ := sm.Get(OSLtoString(b))
 		return val
@@ -621,6 +679,10 @@ func OSLgetItem(a any, b any) any {
 		return v[OSLtoString(b)]
 	}
 
+	if r, ok := a.(OSLRange); ok {
+		return r.Get(OSLcastInt(b))
+	}
+
 	v := reflect.ValueOf(a)
 	if v.Kind() == reflect.Ptr {
 		if v.IsNil() {
@@ -647,17 +709,24 @@ func OSLgetItem(a any, b any) any {
 	case reflect.Struct:
 		// Try exact field name
 		field := v.FieldByName(key)
-		if field.IsValid() && field.CanInterface() {
-			return field.Interface()
+		if field.IsValid() {
+			if val, ok := getFieldUnsafe(field); ok {
+				return val
+			}
 		}
 		// Optionally: loop through fields and match lowercase names
 		t := v.Type()
 		for i := 0; i < t.NumField(); i++ {
 			f := t.Field(i)
-			if strings.EqualFold(f.Name, key) && v.Field(i).CanInterface() {
-				return v.Field(i).Interface()
+			if strings.EqualFold(f.Name, key) {
+				if val, ok := getFieldUnsafe(v.Field(i)); ok {
+					return val
+				}
 			}
 		}
+		if hook := OSLhook(a, "__index"); hook != nil {
+			return OSLcallFunc(hook, nil, []any{b})
+		}
 	case reflect.String:
 		idx := OSLcastInt(b) - 1
 		s := v.String()
@@ -745,6 +814,66 @@ func OSLmod[T float64 | int](a T, b T) T {
 	return T(math.Mod(OSLcastNumber(a), OSLcastNumber(b)))
 }
 
+// OSLoperatorHooks maps each operator user types can overload to the hook that implements it
+var OSLoperatorHooks = map[string]string{
+	"+": "__add",
+	"-": "__sub",
+	"*": "__mul",
+	"/": "__div",
+	"%": "__mod",
+}
+
+// OSLoperator applies an arithmetic operator, calling the left value's hook if its type defines one
+func OSLoperator(op string, a any, b any) any {
+	if hook := OSLhook(a, OSLoperatorHooks[op]); hook != nil {
+		return OSLcallFunc(hook, nil, []any{b})
+	}
+	switch op {
+	case "+":
+		return OSLadd(a, b)
+	case "-":
+		return OSLsub(a, b)
+	case "*":
+		if str, ok := a.(string); ok {
+			return OSLmultiply(str, OSLcastNumber(b))
+		}
+		return OSLcastNumber(a) * OSLcastNumber(b)
+	case "/":
+		return OSLdivide(a, b)
+	case "%":
+		return math.Mod(OSLcastNumber(a), OSLcastNumber(b))
+	}
+	panic("OSLoperator: unknown operator " + op)
+}
+
+// OSLcompare orders two values, using the __lt hook of user types that define one
+func OSLcompare(op string, a any, b any) bool {
+	if OSLhook(a, "__lt") == nil && OSLhook(b, "__lt") == nil {
+		x, y := OSLcastNumber(a), OSLcastNumber(b)
+		switch op {
+		case "<":
+			return x < y
+		case ">":
+			return x > y
+		case "<=":
+			return x <= y
+		case ">=":
+			return x >= y
+		}
+	}
+	switch op {
+	case "<":
+		return OSLless(a, b)
+	case ">":
+		return OSLgreater(a, b)
+	case "<=":
+		return !OSLgreater(a, b)
+	case ">=":
+		return !OSLless(a, b)
+	}
+	panic("OSLcompare: unknown operator " + op)
+}
+
 func OSLmin[T float64 | int](a T, b T) T {
 	if a < b {
 		return a
@@ -841,7 +970,7 @@ func OSLtrim[S string | []any, F int | float64, T int | float64](s S, from F, to
 }
 
 func OSLwait(seconds float64) {
-	time.Sleep(time.Duration(seconds) * time.Second)
+	time.Sleep(time.Duration(seconds * float64(time.Second)))
 }
 
 func OSLsign(n any) string {
@@ -913,10 +1042,12 @@ func OSLtypeof(s any) string {
 		return "number"
 	case bool:
 		return "boolean"
-	case map[string]any:
+	case map[string]any, *SafeMap[string, any], *OSLWorker:
 		return "object"
-	case []any:
+	case []any, *SafeSlice[any], OSLRange:
 		return "array"
+	case iter.Seq[any]:
+		return "generator"
 	default:
 		return "any"
 	}
@@ -932,6 +1063,9 @@ func OSLKeyIn(b any, a any) bool {
 		_, exists := sm.Get(key)
 		return exists
 	}
+	if ss, ok := a.(*SafeSlice[any]); ok {
+		a = ss.Values()
+	}
 
 	switch a := a.(type) {
 	case map[string]any:
@@ -1004,6 +1138,11 @@ func OSLdelete(a any, b any) any {
 		return a
 	}
 
+	if ss, ok := a.(*SafeSlice[any]); ok {
+		ss.Delete(OSLcastInt(b) - 1)
+		return a
+	}
+
 	switch a := a.(type) {
 	case map[string]any:
 		delete(a, OSLtoString(b))
@@ -1052,6 +1191,9 @@ func OSLsetItem(a any, b any, value any) bool {
 		return false
 	}
 
+	if w, ok := a.(*OSLWorker); ok {
+		a = w.state
+	}
 	if sm, ok := a.(*SafeMap[string, any]); ok {
 		sm.Set(OSLtoString(b), value)
 		return true
@@ -1123,6 +1265,33 @@ func OSLsetItem(a any, b any, value any) bool {
 	return false
 }
 
+// OSLhook returns the function a user type assigned to a hook field like __add or __str,
+// or nil when the value is not a user type or leaves the hook unset
+func OSLhook(v any, name string) any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := rv.Elem().FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.Func || field.IsNil() {
+		return nil
+	}
+	hook, _ := getFieldUnsafe(field)
+	return hook
+}
+
+// getFieldUnsafe reads a struct field, including the unexported fields of OSL types
+func getFieldUnsafe(field reflect.Value) (any, bool) {
+	if field.CanInterface() {
+		return field.Interface(), true
+	}
+	if !field.CanAddr() {
+		return nil, false
+	}
+	ptr := unsafe.Pointer(field.UnsafeAddr())
+	return reflect.NewAt(field.Type(), ptr).Elem().Interface(), true
+}
+
 func setFieldUnsafe(field reflect.Value, val reflect.Value) bool {
 	if !field.CanAddr() {
 		return false
@@ -1252,6 +1421,8 @@ func OSLcontains(a any, b any) bool {
 		return false
 	case string:
 		return strings.Contains(a, OSLtoString(b))
+	case OSLRange:
+		return a.Contains(b)
 	default:
 		return false
 	}
@@ -1308,30 +1479,276 @@ func OSLclone(a any) any {
 
 var OSLself any = nil
 
-func OSLworker(props map[string]any) map[string]any {
-	props["createdTime"] = time.Now()
-	props["processTime"] = 0
-	props["alive"] = true
-	props["kill"] = func() {
-		props["alive"] = false
-	}
-	go (func() {
-		OSLself = props
-		OSLcallFunc(props["oncreate"], props, nil)
-		for {
-			startTime := time.Now()
-			OSLself = props
-			OSLcallFunc(props["onframe"], props, nil)
-			props["processTime"] = OSLcastNumber(props["processTime"]) + time.Since(startTime).Seconds()
-			if props["alive"] != true {
-				props["alive"] = false
-				OSLself = props
-				OSLcallFunc(props["onkill"], props, nil)
-				break
-			}
+// OSLworkerQueue is how many messages can wait for a worker, or for main code,
+// before postMessage blocks
+const OSLworkerQueue = 64
+
+type OSLworkerCore struct {
+	state    *SafeMap[string, any]
+	handlers map[string]any
+	inbox    chan any // messages from main code to the worker
+	outbox   chan any // messages from the worker to main code
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	result   any
+}
+
+// OSLWorker is a handle to a worker. Main code holds one from worker(), the worker's
+// own handlers get another as self, so postMessage and receive talk to the other side.
+// The worker's values live in a SafeMap so both sides can read and write them.
+type OSLWorker struct {
+	*OSLworkerCore
+	inside bool
+}
+
+// OSLworker starts a worker from an object of values and handlers. With onframe the
+// worker runs frames at fps times a second (60 by default), without it the worker
+// waits for messages and handles each with onmessage.
+func OSLworker(props map[string]any) *OSLWorker {
+	return OSLstartWorker(props, make(chan any, OSLworkerQueue), make(chan any, OSLworkerQueue))
+}
+
+func OSLstartWorker(props map[string]any, inbox, outbox chan any) *OSLWorker {
+	core := &OSLworkerCore{
+		state:    NewSafeMap(map[string]any{}),
+		handlers: map[string]any{},
+		inbox:    inbox,
+		outbox:   outbox,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for k, v := range props {
+		if v != nil && reflect.TypeOf(v).Kind() == reflect.Func {
+			core.handlers[k] = v
+		} else {
+			core.state.Set(k, v)
+		}
+	}
+	core.state.Set("createdTime", time.Now())
+	core.state.Set("processTime", 0.0)
+	core.state.Set("alive", true)
+
+	w := &OSLWorker{OSLworkerCore: core}
+	go w.run(&OSLWorker{OSLworkerCore: core, inside: true})
+	return w
+}
+
+func (w *OSLWorker) run(self *OSLWorker) {
+	defer close(w.done)
+	w.call(self, "oncreate")
+
+	var frames <-chan time.Time
+	if w.handlers["onframe"] != nil {
+		fps := 60.0
+		if v, ok := w.state.Get("fps"); ok && OSLcastNumber(v) > 0 {
+			fps = OSLcastNumber(v)
+		}
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+		defer ticker.Stop()
+		frames = ticker.C
+	}
+
+	for w.running() {
+		select {
+		case <-w.stop:
+		case msg := <-w.inbox:
+			w.call(self, "onmessage", msg)
+		case <-frames:
+			start := time.Now()
+			w.call(self, "onframe")
+			processTime, _ := w.state.Get("processTime")
+			w.state.Set("processTime", OSLcastNumber(processTime)+time.Since(start).Seconds())
+		}
+	}
+
+	w.state.Set("alive", false)
+	w.result = w.call(self, "onkill")
+}
+
+func (w *OSLWorker) running() bool {
+	select {
+	case <-w.stop:
+		return false
+	default:
+		return true
+	}
+}
+
+// call runs a handler, passing the worker as self to handlers that take it
+func (w *OSLWorker) call(self *OSLWorker, name string, args ...any) any {
+	fn := w.handlers[name]
+	if fn == nil {
+		return nil
+	}
+	if reflect.TypeOf(fn).NumIn() > len(args) {
+		args = append([]any{self}, args...)
+	}
+	return OSLcallFunc(fn, nil, args)
+}
+
+// postMessage sends a message to the other side, it returns false once the worker has ended
+func (w *OSLWorker) postMessage(msg any) bool {
+	to := w.inbox
+	if w.inside {
+		to = w.outbox
+	}
+	select {
+	case to <- msg:
+		return true
+	case <-w.done:
+		return false
+	case <-w.stop:
+		return false
+	}
+}
+
+// receive waits for a message from the other side. Given a number of milliseconds it
+// waits at most that long. It returns null when nothing arrives or the worker has ended.
+func (w *OSLWorker) receive(timeout ...any) any {
+	from := w.outbox
+	if w.inside {
+		from = w.inbox
+	}
+	var expired <-chan time.Time
+	if len(timeout) > 0 {
+		expired = time.After(time.Duration(OSLcastNumber(timeout[0]) * float64(time.Millisecond)))
+	}
+	select {
+	case msg := <-from:
+		return msg
+	case <-expired:
+		return nil
+	case <-w.done:
+		select {
+		case msg := <-from:
+			return msg
+		default:
+			return nil
+		}
+	}
+}
+
+// kill stops the worker after the frame or message it is handling. From main code it
+// waits for the worker to end and returns what onkill returned.
+func (w *OSLWorker) kill() any {
+	w.stopOnce.Do(func() { close(w.stop) })
+	if w.inside {
+		return nil
+	}
+	return w.join()
+}
+
+// join waits for the worker to end and returns what onkill returned
+func (w *OSLWorker) join() any {
+	if w.inside {
+		panic("A worker cannot join itself, use self.kill()")
+	}
+	<-w.done
+	return w.result
+}
+
+func (w *OSLWorker) MarshalJSON() ([]byte, error) {
+	return w.state.MarshalJSON()
+}
+
+// OSLWorkerPool is a fixed number of workers made from the same object. Each has its
+// own self, with id set to its number, and they take messages from one shared queue,
+// so at most size messages are handled at once.
+type OSLWorkerPool struct {
+	workers []*OSLWorker
+	inbox   chan any
+	outbox  chan any
+	done    chan struct{} // closed once every worker has ended
+}
+
+func OSLworkerPool(size int, props map[string]any) *OSLWorkerPool {
+	if size < 1 {
+		panic("A worker pool needs at least 1 worker")
+	}
+	pool := &OSLWorkerPool{inbox: make(chan any, OSLworkerQueue), outbox: make(chan any, OSLworkerQueue), done: make(chan struct{})}
+	for i := range size {
+		member := OSLclone(props).(map[string]any)
+		member["id"] = i + 1
+		pool.workers = append(pool.workers, OSLstartWorker(member, pool.inbox, pool.outbox))
+	}
+	go func() {
+		for _, w := range pool.workers {
+			<-w.done
 		}
-	})()
-	return props
+		close(pool.done)
+	}()
+	return pool
+}
+
+// postMessage queues a message for the next free worker, it returns false once every
+// worker has ended
+func (p *OSLWorkerPool) postMessage(msg any) bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	select {
+	case p.inbox <- msg:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// receive waits for a message from any worker, like OSLWorker.receive. It returns
+// null once every worker has ended and nothing is left to read.
+func (p *OSLWorkerPool) receive(timeout ...any) any {
+	var expired <-chan time.Time
+	if len(timeout) > 0 {
+		expired = time.After(time.Duration(OSLcastNumber(timeout[0]) * float64(time.Millisecond)))
+	}
+	select {
+	case msg := <-p.outbox:
+		return msg
+	case <-expired:
+		return nil
+	case <-p.done:
+		select {
+		case msg := <-p.outbox:
+			return msg
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *OSLWorkerPool) size() int {
+	return len(p.workers)
+}
+
+// kill stops every worker and returns what each onkill returned
+func (p *OSLWorkerPool) kill() []any {
+	for _, w := range p.workers {
+		w.stopOnce.Do(func() { close(w.stop) })
+	}
+	return p.join()
+}
+
+func (p *OSLWorkerPool) join() []any {
+	results := make([]any, len(p.workers))
+	for i, w := range p.workers {
+		results[i] = w.join()
+	}
+	return results
+}
+
+// OSLjoinWorker is join() without a separator. It waits for a worker or a pool, anything
+// else is returned as it is.
+func OSLjoinWorker(value any) any {
+	switch v := value.(type) {
+	case *OSLWorker:
+		return v.join()
+	case *OSLWorkerPool:
+		return v.join()
+	}
+	return value
 }
 
 type SafeMap[K comparable, V any] struct {
@@ -1378,25 +1795,159 @@ func (m *SafeMap[K, V]) Keys() []K {
 	return keys
 }
 
-func OSLrangeBetween(rawp0, rawp1 any) []int {
-	p0 := OSLcastInt(rawp0)
-	p1 := OSLcastInt(rawp1)
-	length := p1 - p0
-	if length < 0 {
-		length = -length
+func (m *SafeMap[K, V]) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.data)
+}
+
+// Snapshot returns a copy of the map that is safe to iterate over
+func (m *SafeMap[K, V]) Snapshot() map[K]V {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[K]V, len(m.data))
+	for k, v := range m.data {
+		out[k] = v
 	}
+	return out
+}
 
-	result := make([]int, length+1)
+// Store replaces the contents of the map
+func (m *SafeMap[K, V]) Store(data map[K]V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = make(map[K]V, len(data))
+	for k, v := range data {
+		m.data[k] = v
+	}
+}
 
-	for i := 0; i <= length; i++ {
-		if p0 < p1 {
-			result[i] = p0 + i
-		} else {
-			result[i] = p0 - i
+func (m *SafeMap[K, V]) MarshalJSON() ([]byte, error) {
+	return []byte(JsonStringify(m.Snapshot())), nil
+}
+
+// OSLRange is the value of start to end. Iterating, indexing and measuring it count
+// from start, so a range costs the same however far it goes, and it only becomes an
+// array when something needs one, like a method that changes it.
+type OSLRange struct {
+	start, end int
+}
+
+func OSLnewRange(rawStart, rawEnd any) OSLRange {
+	return OSLRange{start: OSLcastInt(rawStart), end: OSLcastInt(rawEnd)}
+}
+
+func (r OSLRange) step() int {
+	if r.end < r.start {
+		return -1
+	}
+	return 1
+}
+
+func (r OSLRange) Len() int {
+	return (r.end-r.start)*r.step() + 1
+}
+
+// Get is the number at index i, counting from 1, or nil outside the range
+func (r OSLRange) Get(i int) any {
+	if i < 1 || i > r.Len() {
+		return nil
+	}
+	return r.start + (i-1)*r.step()
+}
+
+func (r OSLRange) Contains(v any) bool {
+	n, ok := v.(int)
+	if !ok {
+		f, isNum := v.(float64)
+		if !isNum || f != float64(int(f)) {
+			return false
 		}
+		n = int(f)
+	}
+	return (n-r.start)*r.step() >= 0 && (r.end-n)*r.step() >= 0
+}
+
+func (r OSLRange) All() iter.Seq2[int, any] {
+	return OSLrange(r.start, r.end)
+}
+
+func (r OSLRange) Array() []any {
+	out := make([]any, 0, r.Len())
+	for _, n := range r.All() {
+		out = append(out, n)
 	}
+	return out
+}
 
-	return result
+func (r OSLRange) MarshalJSON() ([]byte, error) {
+	out := []byte{'['}
+	for i, n := range r.All() {
+		if i > 1 {
+			out = append(out, ',')
+		}
+		out = strconv.AppendInt(out, int64(n.(int)), 10)
+	}
+	return append(out, ']'), nil
+}
+
+// OSLrange counts from start to end, up or down, for each loops over a range
+func OSLrange(rawStart, rawEnd any) iter.Seq2[int, any] {
+	start := OSLcastInt(rawStart)
+	end := OSLcastInt(rawEnd)
+	step := 1
+	if end < start {
+		step = -1
+	}
+	return func(yield func(int, any) bool) {
+		for i, n := 1, start; ; i, n = i+1, n+step {
+			if !yield(i, n) || n == end {
+				return
+			}
+		}
+	}
+}
+
+// OSLiterate turns any iterable value into its 1-indexed items for each loops.
+// Generators and ranges stay lazy, strings give their characters and objects their keys.
+func OSLiterate(v any) iter.Seq2[int, any] {
+	var items []any
+	switch v := v.(type) {
+	case nil:
+		return func(yield func(int, any) bool) {}
+	case iter.Seq2[int, any]:
+		return v
+	case OSLRange:
+		return v.All()
+	case iter.Seq[any]:
+		return func(yield func(int, any) bool) {
+			i := 0
+			for item := range v {
+				i++
+				if !yield(i, item) {
+					return
+				}
+			}
+		}
+	case string:
+		for _, char := range v {
+			items = append(items, string(char))
+		}
+	case map[string]any, *SafeMap[string, any]:
+		items = OSLgetKeys(v)
+		sort.Slice(items, func(i, j int) bool {
+			return OSLtoString(items[i]) < OSLtoString(items[j])
+		})
+	default:
+		items = OSLcastArray(v)
+	}
+	return func(yield func(int, any) bool) {
+		for i, item := range items {
+			if !yield(i+1, item) {
+				return
+			}
+		}
+	}
 }
 
 func (m *SafeMap[K, V]) Values() []V {
@@ -1423,10 +1974,57 @@ func NewSafeSlice[V any](defaults []V) *SafeSlice[V] {
 	return ss
 }
 
-func (s *SafeSlice[V]) Append(value V) {
+func (s *SafeSlice[V]) Append(value V) *SafeSlice[V] {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.data = append(s.data, value)
+	return s
+}
+
+func (s *SafeSlice[V]) Prepend(value V) *SafeSlice[V] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append([]V{value}, s.data...)
+	return s
+}
+
+func (s *SafeSlice[V]) Pop() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data) == 0 {
+		return nil
+	}
+	last := s.data[len(s.data)-1]
+	s.data = s.data[:len(s.data)-1]
+	return last
+}
+
+func (s *SafeSlice[V]) Shift() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data) == 0 {
+		return nil
+	}
+	first := s.data[0]
+	s.data = append([]V{}, s.data[1:]...)
+	return first
+}
+
+func (s *SafeSlice[V]) Delete(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index < 0 || index >= len(s.data) {
+		return
+	}
+	s.data = append(s.data[:index], s.data[index+1:]...)
+}
+
+// Store replaces the contents of the slice
+func (s *SafeSlice[V]) Store(data []V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make([]V, len(data))
+	copy(s.data, data)
 }
 
 func (s *SafeSlice[V]) Get(index int) (V, bool) {
@@ -1463,6 +2061,10 @@ func (s *SafeSlice[V]) Values() []V {
 	return values
 }
 
+func (s *SafeSlice[V]) MarshalJSON() ([]byte, error) {
+	return []byte(JsonStringify(s.Values())), nil
+}
+
 // Keyboard methods (stub implementations)
 // Note: These are defined as methods on a custom string type
 type OSLString string
@@ -1491,3 +2093,52 @@ func btoa(data string) string {
 	encoded := base64.StdEncoding.EncodeToString([]byte(data))
 	return encoded
 }
+
+// OSLembedded holds the files added with the embed command, it is nil when nothing is embedded
+var OSLembedded OSLiofs.FS
+
+// OSLembedPath converts a path to the form embedded files are stored under
+func OSLembedPath(path string) string {
+	path = strings.ReplaceAll(path, "\\", "/")
+	for strings.HasPrefix(path, "./") {
+		path = path[2:]
+	}
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+// OSLreadFile reads a file from disk, falling back to the embedded files
+func OSLreadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && OSLembedded != nil {
+		if embedded, embedErr := OSLiofs.ReadFile(OSLembedded, OSLembedPath(path)); embedErr == nil {
+			return embedded, nil
+		}
+	}
+	return data, err
+}
+
+// OSLstat stats a file on disk, falling back to the embedded files
+func OSLstat(path string) (OSLiofs.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil && OSLembedded != nil {
+		if embedded, embedErr := OSLiofs.Stat(OSLembedded, OSLembedPath(path)); embedErr == nil {
+			return embedded, nil
+		}
+	}
+	return info, err
+}
+
+// OSLreadDir lists a directory on disk, falling back to the embedded files
+func OSLreadDir(path string) ([]OSLiofs.DirEntry, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil && OSLembedded != nil {
+		if embedded, embedErr := OSLiofs.ReadDir(OSLembedded, OSLembedPath(path)); embedErr == nil {
+			return embedded, nil
+		}
+	}
+	return entries, err
+}
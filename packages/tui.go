@@ -311,9 +311,10 @@ func (TUI) Box(title any, content any) string {
 	if titleStr != "" {
 		maxWidth = len(titleStr) + 4
 	}
+	// each content line has a space either side of it
 	for _, line := range contentLines {
-		if len(line) > maxWidth {
-			maxWidth = len(line)
+		if len(line)+2 > maxWidth {
+			maxWidth = len(line) + 2
 		}
 	}
 
@@ -335,39 +336,7 @@ func (TUI) Box(title any, content any) string {
 	box.WriteString(top + "\n")
 
 	for _, line := range contentLines {
-		padding := maxWidth - len(line) - 1
-		box.WriteString("│ " + line + strings.Repeat(" ", padding) + " │\n")
-	}
-
-	box.WriteString(bottom)
-
-	return box.String()
-}
-	for _, line := range contentLines {
-		if len(line) > maxWidth {
-			maxWidth = len(line)
-		}
-	}
-
-	top := "┌"
-	if titleStr != "" {
-		titlePadding := maxWidth - (len(titleStr) + 3)
-		top += "─ " + titleStr + " "
-		if titlePadding > 0 {
-			top += strings.Repeat("─", titlePadding)
-		}
-	} else {
-		top += strings.Repeat("─", maxWidth)
-	}
-	top += "┐"
-
-	bottom := "└" + strings.Repeat("─", maxWidth) + "┘"
-
-	var box strings.Builder
-	box.WriteString(top + "\n")
-
-	for _, line := range contentLines {
-		padding := maxWidth - len(line)
+		padding := maxWidth - len(line) - 2
 		box.WriteString("│ " + line + strings.Repeat(" ", padding) + " │\n")
 	}
 
@@ -0,0 +1,359 @@
+// name: window-gl
+// description: pixelgl backend for osl/window, draws with OpenGL in a window
+// author: Mist
+// requires: github.com/faiface/pixel, github.com/faiface/pixel/pixelgl, github.com/faiface/pixel/imdraw
+
+type OSLwinBackend struct {
+	win        *pixelgl.Window
+	canvas     *OSLwinCanvas
+	imd        *imdraw.IMDraw
+	rectSprite *pixel.Sprite
+}
+
+func (pkg *OSLwinpkg) Create(setup func(w *OSLWindow)) {
+	pixelgl.Run(func() {
+		w := pkg.open()
+
+		if setup != nil {
+			setup(w)
+		}
+
+		win, err := pixelgl.NewWindow(pixelgl.WindowConfig{
+			Title:     w.title,
+			Bounds:    pixel.R(0, 0, w.width, w.height),
+			VSync:     true,
+			Resizable: w.resizable,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		rectImg := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		rectImg.Set(0, 0, color.White)
+		rectPic := pixel.PictureDataFromImage(rectImg)
+
+		imd := imdraw.New(nil)
+		imd.EndShape = imdraw.RoundEndShape
+
+		w.backend = &OSLwinBackend{
+			win:        win,
+			canvas:     &OSLwinCanvas{canvas: win.Canvas()},
+			imd:        imd,
+			rectSprite: pixel.NewSprite(rectPic, rectPic.Bounds()),
+		}
+
+		for !win.Closed() && !w.closed {
+			pkg.frame(w, w.backend.input(w), w.backend.gamepads())
+			w.backend.canvas.flush()
+			win.Update()
+		}
+	})
+}
+
+// input reads what changed since the last frame as input events
+func (b *OSLwinBackend) input(w *OSLWindow) []OSLwinInput {
+	win := b.win
+	var events []OSLwinInput
+
+	bounds := win.Bounds()
+	if bounds.W() != w.width || bounds.H() != w.height {
+		events = append(events, OSLwinInput{kind: "resize", x: bounds.W(), y: bounds.H()})
+	}
+	if focused := win.Focused(); focused != window_focused {
+		kind := "blur"
+		if focused {
+			kind = "focus"
+		}
+		events = append(events, OSLwinInput{kind: kind})
+	}
+
+	pos := win.MousePosition()
+	x, y := pos.X-bounds.W()/2, pos.Y-bounds.H()/2
+	if x != mouse_x || y != mouse_y {
+		events = append(events, OSLwinInput{kind: "mousemove", x: x, y: y})
+	}
+	for _, name := range []string{"left", "right", "middle"} {
+		button := OSLmouseButtons[name]
+		if win.JustPressed(button) {
+			events = append(events, OSLwinInput{kind: "mousedown", name: name})
+		}
+		if win.JustReleased(button) {
+			events = append(events, OSLwinInput{kind: "mouseup", name: name})
+		}
+	}
+	if scroll := win.MouseScroll(); scroll != pixel.ZV {
+		events = append(events, OSLwinInput{kind: "scroll", x: scroll.X, y: scroll.Y})
+	}
+	if typed := win.Typed(); typed != "" {
+		events = append(events, OSLwinInput{kind: "text", name: typed})
+	}
+
+	for _, name := range OSLkeyNames {
+		button := OSLkeyMap[name]
+		if win.JustPressed(button) {
+			events = append(events, OSLwinInput{kind: "keydown", name: name})
+		}
+		if win.JustReleased(button) {
+			events = append(events, OSLwinInput{kind: "keyup", name: name})
+		}
+	}
+	return events
+}
+
+// gamepads reads the connected joysticks
+func (b *OSLwinBackend) gamepads() []OSLgamepad {
+	var gamepads []OSLgamepad
+	for js := pixelgl.Joystick1; js <= pixelgl.JoystickLast; js++ {
+		if !b.win.JoystickPresent(js) {
+			continue
+		}
+		pad := OSLgamepad{
+			name:    b.win.JoystickName(js),
+			axes:    make([]float64, b.win.JoystickAxisCount(js)),
+			buttons: make([]bool, b.win.JoystickButtonCount(js)),
+		}
+		for i := range pad.axes {
+			pad.axes[i] = b.win.JoystickAxis(js, i)
+		}
+		for i := range pad.buttons {
+			pad.buttons[i] = b.win.JoystickPressed(js, i)
+		}
+		gamepads = append(gamepads, pad)
+	}
+	return gamepads
+}
+
+// screen returns the canvas of the window, which pixelgl resizes with it
+func (b *OSLwinBackend) screen(width, height int) *OSLwinCanvas {
+	return b.canvas
+}
+
+func (b *OSLwinBackend) setTitle(title string) {
+	b.win.SetTitle(title)
+}
+
+func (b *OSLwinBackend) setSize(width, height float64) {
+	b.win.SetBounds(pixel.R(0, 0, width, height))
+}
+
+func (b *OSLwinBackend) setPos(x, y float64) {
+	b.win.SetPos(pixel.V(x, y))
+}
+
+var OSLmouseButtons = map[string]pixelgl.Button{
+	"left":   pixelgl.MouseButtonLeft,
+	"right":  pixelgl.MouseButtonRight,
+	"middle": pixelgl.MouseButtonMiddle,
+}
+
+// OSLkeyNames lists the keys input events are read for, in a fixed order. Other names
+// for them are in OSLkeyAliases.
+var OSLkeyNames = []string{
+	"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m",
+	"n", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z",
+	"0", "1", "2", "3", "4", "5", "6", "7", "8", "9",
+	"space", "enter", "escape", "backspace", "tab",
+	"leftshift", "rightshift", "leftctrl", "rightctrl", "leftalt", "rightalt",
+	"up", "down", "left", "right",
+}
+
+var OSLkeyMap = map[string]pixelgl.Button{
+	// Letters
+	"a": pixelgl.KeyA, "b": pixelgl.KeyB, "c": pixelgl.KeyC, "d": pixelgl.KeyD,
+	"e": pixelgl.KeyE, "f": pixelgl.KeyF, "g": pixelgl.KeyG, "h": pixelgl.KeyH,
+	"i": pixelgl.KeyI, "j": pixelgl.KeyJ, "k": pixelgl.KeyK, "l": pixelgl.KeyL,
+	"m": pixelgl.KeyM, "n": pixelgl.KeyN, "o": pixelgl.KeyO, "p": pixelgl.KeyP,
+	"q": pixelgl.KeyQ, "r": pixelgl.KeyR, "s": pixelgl.KeyS, "t": pixelgl.KeyT,
+	"u": pixelgl.KeyU, "v": pixelgl.KeyV, "w": pixelgl.KeyW, "x": pixelgl.KeyX,
+	"y": pixelgl.KeyY, "z": pixelgl.KeyZ,
+
+	// Numbers
+	"0": pixelgl.Key0, "1": pixelgl.Key1, "2": pixelgl.Key2, "3": pixelgl.Key3,
+	"4": pixelgl.Key4, "5": pixelgl.Key5, "6": pixelgl.Key6, "7": pixelgl.Key7,
+	"8": pixelgl.Key8, "9": pixelgl.Key9,
+
+	// Special keys
+	"space":     pixelgl.KeySpace,
+	"enter":     pixelgl.KeyEnter,
+	"escape":    pixelgl.KeyEscape,
+	"backspace": pixelgl.KeyBackspace,
+	"tab":       pixelgl.KeyTab,
+
+	// Modifiers
+	"leftshift":  pixelgl.KeyLeftShift,
+	"rightshift": pixelgl.KeyRightShift,
+	"leftctrl":   pixelgl.KeyLeftControl,
+	"rightctrl":  pixelgl.KeyRightControl,
+	"leftalt":    pixelgl.KeyLeftAlt,
+	"rightalt":   pixelgl.KeyRightAlt,
+
+	// Arrow keys
+	"up":    pixelgl.KeyUp,
+	"down":  pixelgl.KeyDown,
+	"left":  pixelgl.KeyLeft,
+	"right": pixelgl.KeyRight,
+}
+
+// OSLwinCanvas is something drawing goes to, or that window.sprite() draws: the
+// window, a layer or an image. Drawing goes straight to OpenGL.
+type OSLwinCanvas struct {
+	size    image.Rectangle
+	canvas  *pixelgl.Canvas // made on first use for layers, as it needs the window open
+	picture pixel.Picture   // set for images, which are only drawn
+
+	// pixelgl cannot clip, so drawing inside a clip rectangle goes to clipped, and
+	// the part of it inside clip is drawn onto canvas once the clip rectangle changes
+	clipped  *pixelgl.Canvas
+	clip     image.Rectangle
+	clipping bool
+}
+
+func OSLwinNewCanvas(width, height int) *OSLwinCanvas {
+	return &OSLwinCanvas{size: image.Rect(0, 0, width, height)}
+}
+
+func OSLwinImage(img *image.RGBA) *OSLwinCanvas {
+	return &OSLwinCanvas{
+		size:    image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()),
+		picture: pixel.PictureDataFromImage(img),
+	}
+}
+
+func (c *OSLwinCanvas) bounds() image.Rectangle {
+	if c.canvas == nil {
+		return c.size
+	}
+	b := c.canvas.Bounds()
+	return image.Rect(0, 0, int(b.W()), int(b.H()))
+}
+
+func (c *OSLwinCanvas) gl() *pixelgl.Canvas {
+	if c.canvas == nil {
+		c.canvas = pixelgl.NewCanvas(pixel.R(0, 0, float64(c.size.Dx()), float64(c.size.Dy())))
+	}
+	return c.canvas
+}
+
+// target returns what to draw to inside a clip rectangle, or nil if nothing can be drawn
+func (c *OSLwinCanvas) target(clip image.Rectangle) pixel.Target {
+	canvas := c.gl()
+	if clip.Empty() {
+		return nil
+	}
+	if c.bounds().In(clip) {
+		c.flush()
+		return canvas
+	}
+	if c.clipping && clip == c.clip {
+		return c.clipped
+	}
+	c.flush()
+	if c.clipped == nil || c.clipped.Bounds() != canvas.Bounds() {
+		c.clipped = pixelgl.NewCanvas(canvas.Bounds())
+	}
+	c.clipped.Clear(pixel.Alpha(0))
+	c.clip, c.clipping = clip, true
+	return c.clipped
+}
+
+// flush draws what was drawn inside the clip rectangle onto the canvas
+func (c *OSLwinCanvas) flush() {
+	if !c.clipping {
+		return
+	}
+	c.clipping = false
+	// clip rectangles have y going down, pixelgl has y going up
+	height := c.canvas.Bounds().H()
+	frame := pixel.R(float64(c.clip.Min.X), height-float64(c.clip.Max.Y), float64(c.clip.Max.X), height-float64(c.clip.Min.Y))
+	pixel.NewSprite(c.clipped, frame).Draw(c.canvas, pixel.IM.Moved(frame.Center()))
+}
+
+// pic returns what window.sprite() draws for the canvas
+func (c *OSLwinCanvas) pic() pixel.Picture {
+	if c.picture != nil {
+		return c.picture
+	}
+	c.flush()
+	return c.gl()
+}
+
+func (c *OSLwinCanvas) at(x, y int) color.RGBA {
+	canvas := c.pic().(*pixelgl.Canvas)
+	col := canvas.Color(pixel.V(float64(x)+0.5, canvas.Bounds().H()-float64(y)-0.5))
+	return color.RGBA{uint8(col.R * 255), uint8(col.G * 255), uint8(col.B * 255), uint8(col.A * 255)}
+}
+
+func (c *OSLwinCanvas) image() image.Image {
+	canvas := c.pic().(*pixelgl.Canvas)
+	img := image.NewRGBA(c.bounds())
+	pixels := canvas.Pixels()
+	// rows are read from the bottom up
+	stride := img.Stride
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := pixels[(img.Rect.Dy()-1-y)*stride:]
+		copy(img.Pix[y*stride:(y+1)*stride], row[:stride])
+	}
+	return img
+}
+
+// glTransform maps drawing coordinates through the current transform to the
+// coordinates pixelgl draws with, where y goes up from the bottom left
+func (r *OSLwinRender) glTransform() OSLwinTransform {
+	bounds := r.canvas.bounds()
+	toGL := OSLwinTransform{xx: 1, yy: 1, x0: float64(bounds.Dx()) / 2, y0: float64(bounds.Dy()) / 2}
+	return toGL.then(r.transform)
+}
+
+func (m OSLwinTransform) matrix() pixel.Matrix {
+	return pixel.Matrix{m.xx, m.yx, m.xy, m.yy, m.x0, m.y0}
+}
+
+// mask is a colour with the current alpha applied, for pixelgl to draw with
+func (r *OSLwinRender) mask(col color.Color, alpha float64) pixel.RGBA {
+	return pixel.ToRGBA(col).Scaled(r.alpha * alpha)
+}
+
+func (r *OSLwinRender) Clear(col color.Color) {
+	if r.canvas == nil {
+		return
+	}
+	// clearing covers what was drawn inside the clip rectangle too
+	r.canvas.clipping = false
+	r.canvas.gl().Clear(col)
+}
+
+func (r *OSLwinRender) strokeLine(x1, y1, x2, y2, thickness float64, col color.Color) {
+	t := r.canvas.target(r.clip)
+	if t == nil {
+		return
+	}
+	m := r.glTransform()
+	ax, ay := m.apply(x1, y1)
+	bx, by := m.apply(x2, y2)
+
+	imd := r.window.backend.imd
+	imd.Clear()
+	imd.Color = r.mask(col, 1)
+	imd.Push(pixel.V(ax, ay), pixel.V(bx, by))
+	imd.Line(thickness * m.scaleFactor())
+	imd.Draw(t)
+}
+
+func (r *OSLwinRender) fillRect(x, y, width, height float64, col color.Color) {
+	t := r.canvas.target(r.clip)
+	if t == nil {
+		return
+	}
+	// the rect sprite is one pixel centred on 0, 0
+	place := OSLwinTransform{xx: width, yy: height, x0: x, y0: y}
+	r.window.backend.rectSprite.DrawColorMask(t, r.glTransform().then(place).matrix(), r.mask(col, 1))
+}
+
+func (r *OSLwinRender) drawImage(src *OSLwinCanvas, place OSLwinTransform, alpha float64, tint color.RGBA) {
+	t := r.canvas.target(r.clip)
+	if t == nil {
+		return
+	}
+	pic := src.pic()
+	pixel.NewSprite(pic, pic.Bounds()).DrawColorMask(t, r.glTransform().then(place).matrix(), r.mask(tint, alpha))
+}
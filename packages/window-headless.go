@@ -0,0 +1,358 @@
+// name: window-headless
+// description: Headless backend for osl/window, runs a fixed number of frames and writes them to png
+// author: Mist
+// requires: path/filepath
+
+// OSLwinEvent is one line of a scripted input, applied at the start of a frame
+type OSLwinEvent struct {
+	frame  int
+	fields []string
+}
+
+type OSLwinBackend struct {
+	frames   int
+	dir      string
+	events   []OSLwinEvent
+	gamepads []OSLgamepad
+	canvas   *OSLwinCanvas
+}
+
+func (pkg *OSLwinpkg) Create(setup func(w *OSLWindow)) {
+	w := pkg.open()
+	b := &OSLwinBackend{frames: OSLheadlessFrames, dir: OSLheadlessDir}
+	w.backend = b
+	b.simulate(OSLheadlessInput)
+
+	if setup != nil {
+		setup(w)
+	}
+
+	if b.dir != "" {
+		if err := os.MkdirAll(b.dir, 0755); err != nil {
+			panic(err)
+		}
+	}
+
+	for frame := 1; frame <= b.frames && !w.closed; frame++ {
+		pkg.frame(w, b.input(frame), b.gamepads)
+		if b.dir != "" {
+			w.save(filepath.Join(b.dir, fmt.Sprintf("frame_%04d.png", frame)))
+		}
+	}
+}
+
+// headless sets the size of the canvas and, optionally, how many frames the mainloop runs for
+func (w *OSLWindow) headless(width, height any, frames ...any) {
+	w.resize(width, height)
+	if len(frames) > 0 {
+		w.backend.frames = OSLcastInt(frames[0])
+	}
+}
+
+// simulate queues scripted input. Each line starts with the frame it happens on:
+//
+//	1 key space down
+//	3 key space up
+//	2 mouse 10 -20
+//	2 mouse down
+//	4 mouse 0 0 up
+//	4 mouse down right
+//	5 scroll 0 -1
+//	6 type hello world
+//	7 blur
+//	7 focus
+//	8 resize 400 300
+//	9 gamepad 1 button 1 down
+//	9 gamepad 1 axis 2 -0.5
+func (w *OSLWindow) simulate(script any) {
+	w.backend.simulate(OSLtoString(script))
+}
+
+func (b *OSLwinBackend) simulate(script string) {
+	for _, line := range strings.Split(script, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) < 2 {
+			panic("Invalid input event, expected <frame> <event>: " + line)
+		}
+		b.events = append(b.events, OSLwinEvent{frame: frame, fields: fields[1:]})
+	}
+}
+
+// input turns the scripted input for a frame into input events
+func (b *OSLwinBackend) input(frame int) []OSLwinInput {
+	var events []OSLwinInput
+	for _, event := range b.events {
+		if event.frame != frame {
+			continue
+		}
+		fields := event.fields
+		state := func(i int) string {
+			if i < len(fields) && fields[i] == "up" {
+				return "up"
+			}
+			return "down"
+		}
+		switch {
+		case fields[0] == "key" && len(fields) >= 2:
+			events = append(events, OSLwinInput{kind: "key" + state(2), name: OSLkeyName(fields[1])})
+		case fields[0] == "mouse" && len(fields) >= 2 && (fields[1] == "down" || fields[1] == "up"):
+			button := "left"
+			if len(fields) > 2 {
+				button = strings.ToLower(fields[2])
+			}
+			events = append(events, OSLwinInput{kind: "mouse" + fields[1], name: button})
+		case fields[0] == "mouse" && len(fields) >= 3:
+			events = append(events, OSLwinInput{kind: "mousemove", x: OSLcastNumber(fields[1]), y: OSLcastNumber(fields[2])})
+			if len(fields) > 3 {
+				events = append(events, OSLwinInput{kind: "mouse" + state(3), name: "left"})
+			}
+		case fields[0] == "scroll" && len(fields) == 3:
+			events = append(events, OSLwinInput{kind: "scroll", x: OSLcastNumber(fields[1]), y: OSLcastNumber(fields[2])})
+		case fields[0] == "type" && len(fields) >= 2:
+			events = append(events, OSLwinInput{kind: "text", name: strings.Join(fields[1:], " ")})
+		case fields[0] == "focus" || fields[0] == "blur":
+			events = append(events, OSLwinInput{kind: fields[0]})
+		case fields[0] == "resize" && len(fields) == 3:
+			events = append(events, OSLwinInput{kind: "resize", x: OSLcastNumber(fields[1]), y: OSLcastNumber(fields[2])})
+		case fields[0] == "gamepad" && len(fields) >= 4:
+			b.gamepad(fields)
+		default:
+			panic("Unknown input event: " + strings.Join(fields, " "))
+		}
+	}
+	return events
+}
+
+// gamepad applies a scripted gamepad button or axis change, connecting the gamepad if needed
+func (b *OSLwinBackend) gamepad(fields []string) {
+	pad := OSLcastInt(fields[1]) - 1
+	index := OSLcastInt(fields[3]) - 1
+	if pad < 0 || index < 0 {
+		panic("Gamepads, buttons and axes start at 1: " + strings.Join(fields, " "))
+	}
+	// the window compares against the gamepads it was given last frame, so change a copy
+	gamepads := make([]OSLgamepad, max(len(b.gamepads), pad+1))
+	copy(gamepads, b.gamepads)
+	p := gamepads[pad]
+	p.name = "Gamepad " + fields[1]
+	switch fields[2] {
+	case "button":
+		buttons := make([]bool, max(len(p.buttons), index+1))
+		copy(buttons, p.buttons)
+		buttons[index] = len(fields) < 5 || fields[4] != "up"
+		p.buttons = buttons
+	case "axis":
+		if len(fields) < 5 {
+			panic("Gamepad axis events need a value: " + strings.Join(fields, " "))
+		}
+		axes := make([]float64, max(len(p.axes), index+1))
+		copy(axes, p.axes)
+		axes[index] = OSLcastNumber(fields[4])
+		p.axes = axes
+	default:
+		panic("Unknown gamepad event: " + strings.Join(fields, " "))
+	}
+	gamepads[pad] = p
+	b.gamepads = gamepads
+}
+
+func (b *OSLwinBackend) setTitle(title string) {}
+
+func (b *OSLwinBackend) setSize(width, height float64) {}
+
+func (b *OSLwinBackend) setPos(x, y float64) {}
+
+// OSLwinCanvas is an image drawing goes to, or that window.sprite() draws: the
+// window, a layer or an image. Headless windows draw with a software rasterizer.
+type OSLwinCanvas struct {
+	img *image.RGBA
+}
+
+func OSLwinNewCanvas(width, height int) *OSLwinCanvas {
+	return &OSLwinCanvas{img: image.NewRGBA(image.Rect(0, 0, width, height))}
+}
+
+func OSLwinImage(img *image.RGBA) *OSLwinCanvas {
+	return &OSLwinCanvas{img: img}
+}
+
+func (c *OSLwinCanvas) bounds() image.Rectangle {
+	return c.img.Bounds()
+}
+
+func (c *OSLwinCanvas) at(x, y int) color.RGBA {
+	return c.img.RGBAAt(x, y)
+}
+
+func (c *OSLwinCanvas) image() image.Image {
+	return c.img
+}
+
+// screen returns the canvas a frame is drawn to, made again when the window is resized
+func (b *OSLwinBackend) screen(width, height int) *OSLwinCanvas {
+	if b.canvas == nil || b.canvas.bounds() != image.Rect(0, 0, width, height) {
+		b.canvas = OSLwinNewCanvas(width, height)
+	}
+	return b.canvas
+}
+
+// blend draws a colour over one canvas pixel. coverage is how much of the pixel the
+// shape covers, which anti aliases its edges.
+func (r *OSLwinRender) blend(x, y int, col color.Color, coverage float64) {
+	coverage *= r.alpha
+	if coverage <= 0 || !(image.Point{x, y}).In(r.clip) {
+		return
+	}
+	coverage = min(coverage, 1)
+	sr, sg, sb, sa := col.RGBA()
+	keep := 1 - float64(sa)/0xffff*coverage
+	i := r.canvas.img.PixOffset(x, y)
+	pix := r.canvas.img.Pix[i : i+4 : i+4]
+	pix[0] = uint8(float64(sr>>8)*coverage + float64(pix[0])*keep)
+	pix[1] = uint8(float64(sg>>8)*coverage + float64(pix[1])*keep)
+	pix[2] = uint8(float64(sb>>8)*coverage + float64(pix[2])*keep)
+	pix[3] = uint8(float64(sa>>8)*coverage + float64(pix[3])*keep)
+}
+
+func (r *OSLwinRender) Clear(col color.Color) {
+	if r.canvas == nil {
+		return
+	}
+	c := color.RGBAModel.Convert(col).(color.RGBA)
+	pix := r.canvas.img.Pix
+	for i := 0; i < len(pix); i += 4 {
+		pix[i], pix[i+1], pix[i+2], pix[i+3] = c.R, c.G, c.B, c.A
+	}
+}
+
+// pixelBounds returns the pixels a box of canvas coordinates touches, within the clip rectangle
+func (r *OSLwinRender) pixelBounds(minX, minY, maxX, maxY float64) (int, int, int, int) {
+	return max(int(math.Floor(minX)), r.clip.Min.X), max(int(math.Floor(minY)), r.clip.Min.Y),
+		min(int(math.Ceil(maxX)), r.clip.Max.X), min(int(math.Ceil(maxY)), r.clip.Max.Y)
+}
+
+// strokeLine draws a line with round ends, shading each pixel by its distance from the line
+func (r *OSLwinRender) strokeLine(x1, y1, x2, y2, thickness float64, col color.Color) {
+	m := r.pixelTransform()
+	ax, ay := m.apply(x1, y1)
+	bx, by := m.apply(x2, y2)
+	radius := thickness * m.scaleFactor() / 2
+	dx, dy := bx-ax, by-ay
+	lengthSq := dx*dx + dy*dy
+
+	minX, minY, maxX, maxY := r.pixelBounds(min(ax, bx)-radius-1, min(ay, by)-radius-1, max(ax, bx)+radius+1, max(ay, by)+radius+1)
+	for py := minY; py < maxY; py++ {
+		for px := minX; px < maxX; px++ {
+			cx, cy := float64(px)+0.5, float64(py)+0.5
+			t := 0.0
+			if lengthSq > 0 {
+				t = math.Max(0, math.Min(1, ((cx-ax)*dx+(cy-ay)*dy)/lengthSq))
+			}
+			dist := math.Hypot(cx-(ax+t*dx), cy-(ay+t*dy))
+			r.blend(px, py, col, radius-dist+0.5)
+		}
+	}
+}
+
+// fillRect fills a rectangle centred on a point, shading the pixels its edges cut through
+func (r *OSLwinRender) fillRect(x, y, width, height float64, col color.Color) {
+	m := r.pixelTransform()
+	corners := [][2]float64{}
+	for _, corner := range [][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+		px, py := m.apply(x+corner[0]*width/2, y+corner[1]*height/2)
+		corners = append(corners, [2]float64{px, py})
+	}
+	if m.rotated() {
+		r.fillPolygon(corners, col)
+		return
+	}
+
+	left, right := math.Min(corners[0][0], corners[2][0]), math.Max(corners[0][0], corners[2][0])
+	top, bottom := math.Min(corners[0][1], corners[2][1]), math.Max(corners[0][1], corners[2][1])
+	minX, minY, maxX, maxY := r.pixelBounds(left, top, right, bottom)
+	for py := minY; py < maxY; py++ {
+		coverY := math.Min(float64(py+1), bottom) - math.Max(float64(py), top)
+		for px := minX; px < maxX; px++ {
+			coverX := math.Min(float64(px+1), right) - math.Max(float64(px), left)
+			r.blend(px, py, col, coverX*coverY)
+		}
+	}
+}
+
+// fillPolygon fills a convex polygon given in canvas pixels, shading each pixel by
+// how far inside the nearest edge it is
+func (r *OSLwinRender) fillPolygon(points [][2]float64, col color.Color) {
+	area := 0.0
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		area += p[0]*q[1] - q[0]*p[1]
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	if area == 0 {
+		return
+	}
+	sign := math.Copysign(1, area)
+
+	x0, y0, x1, y1 := r.pixelBounds(minX, minY, maxX, maxY)
+	for py := y0; py < y1; py++ {
+		for px := x0; px < x1; px++ {
+			cx, cy := float64(px)+0.5, float64(py)+0.5
+			inside := math.Inf(1)
+			for i, p := range points {
+				q := points[(i+1)%len(points)]
+				ex, ey := q[0]-p[0], q[1]-p[1]
+				dist := sign * (ex*(cy-p[1]) - ey*(cx-p[0])) / math.Hypot(ex, ey)
+				inside = math.Min(inside, dist)
+			}
+			r.blend(px, py, col, inside+0.5)
+		}
+	}
+}
+
+// drawImage draws an image placed in drawing coordinates, picking the nearest image
+// pixel for each canvas pixel it covers so pixel art stays sharp
+func (r *OSLwinRender) drawImage(src *OSLwinCanvas, place OSLwinTransform, alpha float64, tint color.RGBA) {
+	img := src.img
+	width, height := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+	// image pixels, with y going down from the top left, to sprite coordinates
+	fromImage := OSLwinTransform{xx: 1, yy: -1, x0: -width / 2, y0: height / 2}
+	m := r.pixelTransform().then(place).then(fromImage)
+	inv := m.invert()
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{0, 0}, {width, 0}, {0, height}, {width, height}} {
+		px, py := m.apply(corner[0], corner[1])
+		minX, maxX = math.Min(minX, px), math.Max(maxX, px)
+		minY, maxY = math.Min(minY, py), math.Max(maxY, py)
+	}
+
+	saved := r.alpha
+	r.alpha *= alpha
+	defer func() { r.alpha = saved }()
+
+	x0, y0, x1, y1 := r.pixelBounds(minX, minY, maxX, maxY)
+	for py := y0; py < y1; py++ {
+		for px := x0; px < x1; px++ {
+			u, v := inv.apply(float64(px)+0.5, float64(py)+0.5)
+			if u < 0 || v < 0 || u >= width || v >= height {
+				continue
+			}
+			c := img.RGBAAt(img.Bounds().Min.X+int(u), img.Bounds().Min.Y+int(v))
+			if c.A == 0 {
+				continue
+			}
+			c.R = uint8(uint16(c.R) * uint16(tint.R) / 255)
+			c.G = uint8(uint16(c.G) * uint16(tint.G) / 255)
+			c.B = uint8(uint16(c.B) * uint16(tint.B) / 255)
+			r.blend(px, py, c, 1)
+		}
+	}
+}
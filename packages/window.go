@@ -1,37 +1,102 @@
 // name: window
-// description: PixelGL window wrapper
+// description: Window drawing, shown with pixelgl or rendered headless to png
 // author: Mist
-// requires: github.com/faiface/pixel, github.com/faiface/pixel/pixelgl, github.com/faiface/pixel/imdraw, image, image/color
+// requires: image, image/color, image/png as OSLpng, image/jpeg as _
 
 type OSLwinRender struct {
-	win        *pixelgl.Window
-	color      color.Color
-	rectSprite *pixel.Sprite
-	currentX   float64
-	currentY   float64
-	thickness  float64
-	direction  float64
-	window     *OSLWindow
+	screen    *OSLwinCanvas // the window
+	canvas    *OSLwinCanvas // where drawing goes, the screen or a layer
+	color     color.Color
+	currentX  float64
+	currentY  float64
+	thickness float64
+	direction float64
+	window    *OSLWindow
+
+	alpha     float64
+	transform OSLwinTransform
+	clip      image.Rectangle
+	stack     []OSLwinState
+	images    map[string]*OSLwinCanvas
+}
+
+// OSLwinTransform is an affine transform of drawing coordinates:
+//
+//	x' = xx*x + xy*y + x0
+//	y' = yx*x + yy*y + y0
+type OSLwinTransform struct {
+	xx, xy, yx, yy, x0, y0 float64
+}
+
+var OSLwinIdentity = OSLwinTransform{xx: 1, yy: 1}
+
+// OSLwinState is what window.push() saves and window.pop() restores
+type OSLwinState struct {
+	transform OSLwinTransform
+	clip      image.Rectangle
+	color     color.Color
+	alpha     float64
+	thickness float64
+}
+
+// OSLwinLayer is an image drawing can be sent to with window.target(), then drawn with window.sprite()
+type OSLwinLayer struct {
+	canvas *OSLwinCanvas
 }
 
 type OSLWindow struct {
-	win  *pixelgl.Window
-	cfg  pixelgl.WindowConfig
-	loop func(w *OSLWindow)
+	backend   *OSLwinBackend
+	renderer  *OSLwinRender
+	title     string
+	width     float64
+	height    float64
+	resizable bool
+	closed    bool
+	loop      func(w *OSLWindow)
+
+	keys            map[string]bool
+	keysPressed     map[string]bool
+	keysReleased    map[string]bool
+	buttons         map[string]bool
+	buttonsPressed  map[string]bool
+	buttonsReleased map[string]bool
+	gamepads        []OSLgamepad
+	handlers        map[string]any
+}
+
+// OSLwinInput is an input event read by a backend. Events are applied before the mainloop runs.
+type OSLwinInput struct {
+	kind string // keydown, keyup, mousemove, mousedown, mouseup, scroll, text, focus, blur or resize
+	name string // the key, mouse button or typed text
+	x    float64
+	y    float64
+}
+
+type OSLgamepad struct {
+	name    string
+	axes    []float64
+	buttons []bool
 }
 
+// OSLwinpkg is the window variable outside of the mainloop, it passes methods on to
+// the open window so functions can draw too
 type OSLwinpkg struct {
+	*OSLWindow
 	configWidth  float64
 	configHeight float64
 	width        float64
 	height       float64
-	renderer     *OSLwinRender
-	window       *OSLWindow
 }
 
 var mouse_x float64 = 0.0
 var mouse_y float64 = 0.0
 var mouse_down = false
+var mouse_pressed = false
+var mouse_released = false
+var scroll_x float64 = 0.0
+var scroll_y float64 = 0.0
+var typed_text = ""
+var window_focused = true
 var direction float64 = 0.0
 var x_position float64 = 0.0
 var y_position float64 = 0.0
@@ -46,155 +111,433 @@ var window = &OSLwinpkg{
 	height: 600,
 }
 
-func (pkg *OSLwinpkg) Create(setup func(w *OSLWindow)) {
-	pixelgl.Run(func() {
-		width := pkg.configWidth
-		height := pkg.configHeight
-		if width <= 0 {
-			width = 800
-		}
-		if height <= 0 {
-			height = 600
-		}
-
-		pkg.width = width
-		pkg.height = height
-
-		cfg := pixelgl.WindowConfig{
-			Title:     "Untitled",
-			Bounds:    pixel.R(0, 0, width, height),
-			VSync:     true,
-			Resizable: true,
-		}
+// open makes the window and the renderer that draws into it, before setup configures them
+func (pkg *OSLwinpkg) open() *OSLWindow {
+	width := pkg.configWidth
+	height := pkg.configHeight
+	if width <= 0 {
+		width = 800
+	}
+	if height <= 0 {
+		height = 600
+	}
 
-		w := &OSLWindow{cfg: cfg, loop: nil}
+	w := &OSLWindow{
+		title:           "Untitled",
+		width:           width,
+		height:          height,
+		resizable:       true,
+		keys:            map[string]bool{},
+		keysPressed:     map[string]bool{},
+		keysReleased:    map[string]bool{},
+		buttons:         map[string]bool{},
+		buttonsPressed:  map[string]bool{},
+		buttonsReleased: map[string]bool{},
+		handlers:        map[string]any{},
+	}
+	r := &OSLwinRender{
+		thickness: 1,
+		window:    w,
+		alpha:     1,
+		transform: OSLwinIdentity,
+		images:    map[string]*OSLwinCanvas{},
+	}
+	w.renderer = r
+
+	pkg.width = width
+	pkg.height = height
+	pkg.OSLWindow = w
+	OSLdrawctx = r
+	OSLgamepadSource = w.gamepadList
+	return w
+}
 
-		if setup != nil {
-			setup(w)
-		}
+// frame applies the input a backend read, then runs the mainloop once, drawing onto
+// a cleared canvas the size of the window
+func (pkg *OSLwinpkg) frame(w *OSLWindow, events []OSLwinInput, gamepads []OSLgamepad) {
+	OSLupdateTimer()
+	w.input(events, gamepads)
+
+	r := w.renderer
+	r.screen = w.backend.screen(int(w.width), int(w.height))
+	// transforms, clipping and layer targets last until the end of the frame
+	r.target(r.screen)
+	r.transform = OSLwinIdentity
+	r.stack = r.stack[:0]
+	r.Clear(color.RGBA{0, 0, 0, 255})
+
+	pkg.width = w.width
+	pkg.height = w.height
+	window_width = w.width
+	window_height = w.height
+
+	if w.loop != nil {
+		w.loop(w)
+	}
+}
 
-		win, err := pixelgl.NewWindow(w.cfg)
-		if err != nil {
-			panic(err)
-		}
-		w.win = win
-
-		r := &OSLwinRender{
-			win:       win,
-			currentX:  0,
-			currentY:  0,
-			thickness: 1,
-			direction: 0,
-			window:    w,
+// input updates the input variables from a frame's events, calling the handlers
+// window.on set as it goes. Mouse coordinates are relative to the centre of the
+// window, like drawing coordinates.
+func (w *OSLWindow) input(events []OSLwinInput, gamepads []OSLgamepad) {
+	clear(w.keysPressed)
+	clear(w.keysReleased)
+	clear(w.buttonsPressed)
+	clear(w.buttonsReleased)
+	scroll_x, scroll_y = 0, 0
+	typed_text = ""
+
+	for _, event := range events {
+		switch event.kind {
+		case "keydown":
+			if !w.keys[event.name] {
+				w.keysPressed[event.name] = true
+			}
+			w.keys[event.name] = true
+			w.emit(event.kind, event.name)
+		case "keyup":
+			delete(w.keys, event.name)
+			w.keysReleased[event.name] = true
+			w.emit(event.kind, event.name)
+		case "mousemove":
+			mouse_x, mouse_y = event.x, event.y
+			w.emit(event.kind, mouse_x, mouse_y)
+		case "mousedown":
+			if !w.buttons[event.name] {
+				w.buttonsPressed[event.name] = true
+			}
+			w.buttons[event.name] = true
+			w.emit(event.kind, mouse_x, mouse_y, event.name)
+		case "mouseup":
+			delete(w.buttons, event.name)
+			w.buttonsReleased[event.name] = true
+			w.emit(event.kind, mouse_x, mouse_y, event.name)
+		case "scroll":
+			scroll_x += event.x
+			scroll_y += event.y
+			w.emit(event.kind, event.x, event.y)
+		case "text":
+			typed_text += event.name
+			w.emit(event.kind, event.name)
+		case "focus", "blur":
+			window_focused = event.kind == "focus"
+			w.emit(event.kind)
+		case "resize":
+			w.width, w.height = event.x, event.y
+			w.emit(event.kind, event.x, event.y)
 		}
+	}
 
-		pkg.renderer = r
-
-		rectImg := image.NewRGBA(image.Rect(0, 0, 1, 1))
-		rectImg.Set(0, 0, color.White)
-		rectPic := pixel.PictureDataFromImage(rectImg)
-		r.rectSprite = pixel.NewSprite(rectPic, rectPic.Bounds())
-
-		OSLdrawctx = r
-
-		for !w.win.Closed() {
-			w.win.Clear(color.RGBA{0, 0, 0, 255})
-
-			mousePos := w.win.MousePosition()
-
-			winBounds := w.win.Bounds()
-			winWidth := winBounds.Max.X
-			winHeight := winBounds.Max.Y
-
-			pkg.width = winWidth
-			pkg.height = winHeight
-			window_width = winWidth
-			window_height = winHeight
+	mouse_down = w.buttons["left"]
+	mouse_pressed = w.buttonsPressed["left"]
+	mouse_released = w.buttonsReleased["left"]
 
-			mouse_x = mousePos.X - (winWidth / 2)
-			mouse_y = mousePos.Y - (winHeight / 2)
+	w.updateGamepads(gamepads)
+}
 
-			if w.loop != nil {
-				w.loop(w)
+// updateGamepads stores the gamepads a backend read, calling the gamepaddown and
+// gamepadup handlers for buttons that changed since the last frame
+func (w *OSLWindow) updateGamepads(gamepads []OSLgamepad) {
+	for i, pad := range gamepads {
+		for button, down := range pad.buttons {
+			was := false
+			if i < len(w.gamepads) && button < len(w.gamepads[i].buttons) {
+				was = w.gamepads[i].buttons[button]
+			}
+			switch {
+			case down && !was:
+				w.emit("gamepaddown", i+1, button+1)
+			case !down && was:
+				w.emit("gamepadup", i+1, button+1)
 			}
+		}
+	}
+	w.gamepads = gamepads
+}
 
-			w.win.Update()
+// gamepadList is what getGamepads() returns while a window is open
+func (w *OSLWindow) gamepadList() []any {
+	out := make([]any, len(w.gamepads))
+	for i, pad := range w.gamepads {
+		axes := make([]any, len(pad.axes))
+		for j, axis := range pad.axes {
+			axes[j] = axis
 		}
-	})
+		buttons := make([]any, len(pad.buttons))
+		for j, button := range pad.buttons {
+			buttons[j] = button
+		}
+		out[i] = map[string]any{"index": i + 1, "name": pad.name, "axes": axes, "buttons": buttons}
+	}
+	return out
 }
 
-func (w *OSLWindow) Run(loop func(w *OSLWindow)) {
-	w.loop = loop
+// on sets the function called for an input event, replacing the one set before, so
+// it can be called from the mainloop too
+func (w *OSLWindow) on(event any, fn any) {
+	name := strings.ToLower(OSLtoString(event))
+	if fn == nil {
+		delete(w.handlers, name)
+		return
+	}
+	w.handlers[name] = fn
 }
 
-func (w *OSLWindow) width() float64 {
-	return w.win.Bounds().Max.X
+func (w *OSLWindow) emit(event string, args ...any) {
+	fn, ok := w.handlers[event]
+	if !ok {
+		return
+	}
+	// handlers can take fewer arguments than the event has
+	if t := reflect.TypeOf(fn); t.Kind() == reflect.Func && !t.IsVariadic() && len(args) > t.NumIn() {
+		args = args[:t.NumIn()]
+	}
+	OSLcallFunc(fn, nil, args)
 }
 
-func (w *OSLWindow) height() float64 {
-	return w.win.Bounds().Max.Y
+func (w *OSLWindow) Run(loop func(w *OSLWindow)) {
+	w.loop = loop
 }
 
 func (w *OSLWindow) left() float64 {
-	return w.win.Bounds().Max.X / -2
+	return w.width / -2
 }
 
 func (w *OSLWindow) right() float64 {
-	return w.win.Bounds().Max.X / 2
+	return w.width / 2
 }
 
 func (w *OSLWindow) top() float64 {
-	return w.win.Bounds().Max.Y / 2
+	return w.height / 2
 }
 
 func (w *OSLWindow) bottom() float64 {
-	return w.win.Bounds().Max.Y / -2
+	return w.height / -2
 }
 
 func (w *OSLWindow) SetTitle(title string) {
-	w.cfg.Title = title
-	if w.win != nil {
-		w.win.SetTitle(title)
+	w.title = title
+	if w.backend != nil {
+		w.backend.setTitle(title)
 	}
 }
 
 func (w *OSLWindow) resize(width, height any) {
-	w.cfg.Bounds = pixel.R(0, 0, OSLcastNumber(width), OSLcastNumber(height))
-	if w.win != nil {
-		w.win.SetBounds(w.cfg.Bounds)
+	w.width = OSLcastNumber(width)
+	w.height = OSLcastNumber(height)
+	if w.backend != nil {
+		w.backend.setSize(w.width, w.height)
 	}
 }
 
 func (w *OSLWindow) setResizable(resizable bool) {
-	return
+	w.resizable = resizable
 }
 
 func (w *OSLWindow) Goto(x, y any) {
-	if w.win == nil {
-		return
-	}
 	x_position = OSLcastNumber(x)
 	y_position = OSLcastNumber(y)
-	w.win.SetPos(pixel.V(x_position, y_position))
+	if w.backend != nil {
+		w.backend.setPos(x_position, y_position)
+	}
 }
 
 func (w *OSLWindow) Clear(col color.Color) {
-	if w.win == nil {
+	w.renderer.Clear(col)
+}
+
+func (w *OSLWindow) close() {
+	w.closed = true
+}
+
+// OSLkeyAliases maps other names for a key to the name input events use
+var OSLkeyAliases = map[string]string{
+	"esc":     "escape",
+	"shift":   "leftshift",
+	"ctrl":    "leftctrl",
+	"control": "leftctrl",
+	"alt":     "leftalt",
+}
+
+func OSLkeyName(key any) string {
+	name := strings.ToLower(OSLtoString(key))
+	if alias, ok := OSLkeyAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func (w *OSLWindow) KeyPressed(key string) bool {
+	return w.keys[OSLkeyName(key)]
+}
+
+// KeyJustPressed reports whether a key went down this frame
+func (w *OSLWindow) KeyJustPressed(key string) bool {
+	return w.keysPressed[OSLkeyName(key)]
+}
+
+func (w *OSLWindow) keyReleased(key any) bool {
+	return w.keysReleased[OSLkeyName(key)]
+}
+
+func (w *OSLWindow) mouseDown(button any) bool {
+	return w.buttons[strings.ToLower(OSLtoString(button))]
+}
+
+func (w *OSLWindow) mousePressed(button any) bool {
+	return w.buttonsPressed[strings.ToLower(OSLtoString(button))]
+}
+
+func (w *OSLWindow) mouseReleased(button any) bool {
+	return w.buttonsReleased[strings.ToLower(OSLtoString(button))]
+}
+
+// pixel returns the colour drawn at a point as a hex string, for checking what a frame rendered
+func (w *OSLWindow) pixel(x, y any) string {
+	screen := w.renderer.screen
+	if screen == nil {
+		return "#000000"
+	}
+	px := float64(screen.bounds().Dx())/2 + OSLcastNumber(x)
+	py := float64(screen.bounds().Dy())/2 - OSLcastNumber(y)
+	c := screen.at(int(math.Floor(px)), int(math.Floor(py)))
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// save writes the window as it is now to a png file
+func (w *OSLWindow) save(path any) {
+	screen := w.renderer.screen
+	if screen == nil {
 		return
 	}
-	w.win.Clear(col)
+	file, err := os.Create(OSLtoString(path))
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	if err := OSLpng.Encode(file, screen.image()); err != nil {
+		panic(err)
+	}
 }
 
-func (w *OSLWindow) Update() {
-	if w.win != nil {
-		w.win.Update()
+// clear fills what is being drawn to with a colour, black if none is given
+func (w *OSLWindow) clear(col ...any) {
+	r := w.renderer
+	if len(col) == 0 {
+		r.Clear(color.RGBA{0, 0, 0, 255})
+		return
 	}
+	r.Clear(r.Hex(col[0]))
 }
 
-func (w *OSLWindow) close() {
-	if w.win != nil {
-		w.win.SetClosed(true)
+// push saves the transform, clip rectangle, colour, alpha and line width for pop to restore
+func (w *OSLWindow) push() {
+	r := w.renderer
+	r.stack = append(r.stack, OSLwinState{r.transform, r.clip, r.color, r.alpha, r.thickness})
+}
+
+func (w *OSLWindow) pop() {
+	r := w.renderer
+	if len(r.stack) == 0 {
+		panic("window.pop() called more times than window.push()")
 	}
+	state := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	r.transform, r.clip, r.color, r.alpha, r.thickness = state.transform, state.clip, state.color, state.alpha, state.thickness
+}
+
+func (w *OSLWindow) translate(x, y any) {
+	r := w.renderer
+	r.transform = r.transform.then(OSLwinTransform{xx: 1, yy: 1, x0: OSLcastNumber(x), y0: OSLcastNumber(y)})
+}
+
+// rotate turns what is drawn after it clockwise by a number of degrees, like direction
+func (w *OSLWindow) rotate(degrees any) {
+	r := w.renderer
+	r.transform = r.transform.then(OSLwinRotation(OSLcastNumber(degrees)))
+}
+
+func (w *OSLWindow) scale(x any, y ...any) {
+	r := w.renderer
+	sx := OSLcastNumber(x)
+	sy := sx
+	if len(y) > 0 {
+		sy = OSLcastNumber(y[0])
+	}
+	r.transform = r.transform.then(OSLwinTransform{xx: sx, yy: sy})
+}
+
+// alpha sets the opacity of everything drawn after it, from 0 to 1
+func (w *OSLWindow) alpha(alpha any) {
+	w.renderer.alpha = math.Max(0, math.Min(1, OSLcastNumber(alpha)))
+}
+
+// clip limits drawing to a rectangle centred on a point, within the clip already set.
+// Rotated clip rectangles clip to the box around them.
+func (w *OSLWindow) clip(x, y, width, height any) {
+	r := w.renderer
+	cx, cy := OSLcastNumber(x), OSLcastNumber(y)
+	hw, hh := OSLcastNumber(width)/2, OSLcastNumber(height)/2
+	m := r.pixelTransform()
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{cx - hw, cy - hh}, {cx + hw, cy - hh}, {cx - hw, cy + hh}, {cx + hw, cy + hh}} {
+		px, py := m.apply(corner[0], corner[1])
+		minX, maxX = math.Min(minX, px), math.Max(maxX, px)
+		minY, maxY = math.Min(minY, py), math.Max(maxY, py)
+	}
+	rect := image.Rect(int(math.Round(minX)), int(math.Round(minY)), int(math.Round(maxX)), int(math.Round(maxY)))
+	r.clip = r.clip.Intersect(rect)
+}
+
+func (w *OSLWindow) unclip() {
+	r := w.renderer
+	r.clip = r.canvas.bounds()
+}
+
+// layer makes an image to draw to with window.target() and draw with window.sprite()
+func (w *OSLWindow) layer(width, height any) *OSLwinLayer {
+	return &OSLwinLayer{canvas: OSLwinNewCanvas(OSLcastInt(width), OSLcastInt(height))}
+}
+
+// target sends drawing to a layer, or back to the window when given null
+func (w *OSLWindow) target(layer any) {
+	r := w.renderer
+	if l, ok := layer.(*OSLwinLayer); ok && l != nil {
+		r.target(l.canvas)
+		return
+	}
+	r.target(r.screen)
+}
+
+// sprite draws an image centred on the cursor. The image can come from osl/img, be a
+// layer or be the path of a png or jpeg file. Options are scale, rotation, alpha and tint.
+func (w *OSLWindow) sprite(src any, options ...any) {
+	r := w.renderer
+	if r.canvas == nil {
+		return
+	}
+	img := r.spriteImage(src)
+	scale, rotation, alpha := 1.0, 0.0, 1.0
+	tint := color.RGBA{255, 255, 255, 255}
+	if len(options) > 0 {
+		opts := OSLcastObject(options[0])
+		if v, ok := opts["scale"]; ok {
+			scale = OSLcastNumber(v)
+		}
+		if v, ok := opts["rotation"]; ok {
+			rotation = OSLcastNumber(v)
+		}
+		if v, ok := opts["alpha"]; ok {
+			alpha = OSLcastNumber(v)
+		}
+		if v, ok := opts["tint"]; ok {
+			tint = r.Hex(v)
+		}
+	}
+	// sprite coordinates are image pixels from its centre, with y going up
+	place := OSLwinTransform{xx: 1, yy: 1, x0: r.currentX, y0: r.currentY}.then(OSLwinRotation(rotation)).then(OSLwinTransform{xx: scale, yy: scale})
+	r.drawImage(img, place, alpha, tint)
 }
 
 func (r *OSLwinRender) Hex(hex any) color.RGBA {
@@ -222,6 +565,103 @@ func (r *OSLwinRender) Hex(hex any) color.RGBA {
 	return color.RGBA{R: rr, G: gg, B: bb, A: aa}
 }
 
+func (m OSLwinTransform) apply(x, y float64) (float64, float64) {
+	return m.xx*x + m.xy*y + m.x0, m.yx*x + m.yy*y + m.y0
+}
+
+// then returns the transform that applies n, then m
+func (m OSLwinTransform) then(n OSLwinTransform) OSLwinTransform {
+	return OSLwinTransform{
+		xx: m.xx*n.xx + m.xy*n.yx,
+		xy: m.xx*n.xy + m.xy*n.yy,
+		yx: m.yx*n.xx + m.yy*n.yx,
+		yy: m.yx*n.xy + m.yy*n.yy,
+		x0: m.xx*n.x0 + m.xy*n.y0 + m.x0,
+		y0: m.yx*n.x0 + m.yy*n.y0 + m.y0,
+	}
+}
+
+func (m OSLwinTransform) invert() OSLwinTransform {
+	det := m.xx*m.yy - m.xy*m.yx
+	if det == 0 {
+		return OSLwinTransform{}
+	}
+	inv := OSLwinTransform{xx: m.yy / det, xy: -m.xy / det, yx: -m.yx / det, yy: m.xx / det}
+	inv.x0 = -(inv.xx*m.x0 + inv.xy*m.y0)
+	inv.y0 = -(inv.yx*m.x0 + inv.yy*m.y0)
+	return inv
+}
+
+// scaleFactor is how much the transform grows lengths by, on average
+func (m OSLwinTransform) scaleFactor() float64 {
+	return math.Sqrt(math.Abs(m.xx*m.yy - m.xy*m.yx))
+}
+
+func (m OSLwinTransform) rotated() bool {
+	return m.xy != 0 || m.yx != 0
+}
+
+// OSLwinRotation turns clockwise in drawing coordinates, where y goes up
+func OSLwinRotation(degrees float64) OSLwinTransform {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return OSLwinTransform{xx: cos, xy: sin, yx: -sin, yy: cos}
+}
+
+// pixelTransform maps drawing coordinates, centred with y going up, through the
+// current transform to pixels of the canvas being drawn to
+func (r *OSLwinRender) pixelTransform() OSLwinTransform {
+	bounds := r.canvas.bounds()
+	toPixels := OSLwinTransform{xx: 1, yy: -1, x0: float64(bounds.Dx()) / 2, y0: float64(bounds.Dy()) / 2}
+	return toPixels.then(r.transform)
+}
+
+func (r *OSLwinRender) canvasPoint(x, y float64) (float64, float64) {
+	return r.pixelTransform().apply(x, y)
+}
+
+// target sends drawing to a canvas, which starts unclipped
+func (r *OSLwinRender) target(canvas *OSLwinCanvas) {
+	r.canvas = canvas
+	r.clip = canvas.bounds()
+}
+
+// spriteImage returns something window.sprite() can draw as a canvas. Images loaded
+// from files are kept, so drawing the same path every frame only reads it once.
+func (r *OSLwinRender) spriteImage(src any) *OSLwinCanvas {
+	switch src := src.(type) {
+	case *OSLwinLayer:
+		return src.canvas
+	case interface{ RGBA() *image.RGBA }:
+		if img := src.RGBA(); img != nil {
+			return OSLwinImage(img)
+		}
+		panic("Cannot draw a closed image as a sprite")
+	case string:
+		if img, ok := r.images[src]; ok {
+			return img
+		}
+		file, err := os.Open(src)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		decoded, _, err := image.Decode(file)
+		if err != nil {
+			panic(fmt.Sprintf("Cannot load image %v: %v", src, err))
+		}
+		bounds := decoded.Bounds()
+		img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				img.Set(x, y, decoded.At(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		}
+		r.images[src] = OSLwinImage(img)
+		return r.images[src]
+	}
+	panic(fmt.Sprintf("Cannot draw %v as a sprite, expected an image, a layer or a file path", OSLtypeof(src)))
+}
+
 // Render methods
 func (r *OSLwinRender) Color(col any) {
 	r.color = r.Hex(col)
@@ -242,19 +682,10 @@ func (w *OSLwinRender) Loc(a, b, c, d any) {
 }
 
 func (r *OSLwinRender) LineTo(endX, endY float64) {
-	if r.win == nil {
+	if r.canvas == nil {
 		return
 	}
 
-	winBounds := r.win.Bounds()
-	winWidth := winBounds.Max.X
-	winHeight := winBounds.Max.Y
-
-	startPixelX := (winWidth / 2) + r.currentX
-	startPixelY := (winHeight / 2) + r.currentY
-	endPixelX := (winWidth / 2) + endX
-	endPixelY := (winHeight / 2) + endY
-
 	col := r.color
 	if col == nil {
 		col = color.White
@@ -265,53 +696,36 @@ func (r *OSLwinRender) LineTo(endX, endY float64) {
 		thickness = 1
 	}
 
-	imd := imdraw.New(nil)
-	imd.Color = col
-	imd.EndShape = imdraw.RoundEndShape
-	imd.Push(pixel.V(startPixelX, startPixelY))
-	imd.Push(pixel.V(endPixelX, endPixelY))
-	imd.Line(thickness)
-	imd.Draw(r.win)
+	r.strokeLine(r.currentX, r.currentY, endX, endY, thickness, col)
 
 	r.currentX = endX
 	r.currentY = endY
 }
 
 func (r *OSLwinRender) Rect(args ...any) {
-	if r.win == nil || r.rectSprite == nil {
+	if r.canvas == nil {
 		return
 	}
 
 	width := OSLcastNumber(args[0])
 	height := OSLcastNumber(args[1])
-	rounding := OSLcastNumber(args[2])
+	rounding := 0.0
+	if len(args) > 2 {
+		rounding = OSLcastNumber(args[2])
+	}
 
 	col := r.color
 	if col == nil {
 		col = color.White
 	}
 
-	winBounds := r.win.Bounds()
-	winWidth := winBounds.Max.X
-	winHeight := winBounds.Max.Y
-
-	pixelX := (winWidth / 2) + r.currentX
-	pixelY := (winHeight / 2) + r.currentY
-
-	centerX := pixelX
-	centerY := pixelY
-
-	mat := pixel.IM.
-		ScaledXY(pixel.ZV, pixel.V(width, height)).
-		Moved(pixel.V(centerX, centerY))
-
-	r.rectSprite.DrawColorMask(r.win, mat, col)
+	r.fillRect(r.currentX, r.currentY, width, height, col)
 
 	if rounding > 0 {
 		prevThickness := r.thickness
 		r.thickness = rounding
-		centerX = r.currentX
-		centerY = r.currentY
+		centerX := r.currentX
+		centerY := r.currentY
 		r.Goto(centerX+width/2, centerY-height/2)
 		r.LineTo(centerX+width/2, centerY+height/2)
 		r.LineTo(centerX-width/2, centerY+height/2)
@@ -322,7 +736,7 @@ func (r *OSLwinRender) Rect(args ...any) {
 }
 
 func (r *OSLwinRender) Icon(icon any, size float64) {
-	if r.win == nil {
+	if r.canvas == nil {
 		return
 	}
 
@@ -398,7 +812,7 @@ func (r *OSLwinRender) Icon(icon any, size float64) {
 }
 
 func (r *OSLwinRender) Text(text string, size any) {
-	if r.win == nil {
+	if r.canvas == nil {
 		return
 	}
 
@@ -448,57 +862,3 @@ func (r *OSLwinRender) Change(offsetX, offsetY float64) {
 func (r *OSLwinRender) Direction(direction float64) {
 	r.direction = direction
 }
-
-var OSLkeyMap = map[string]pixelgl.Button{
-	// Letters
-	"a": pixelgl.KeyA, "b": pixelgl.KeyB, "c": pixelgl.KeyC, "d": pixelgl.KeyD,
-	"e": pixelgl.KeyE, "f": pixelgl.KeyF, "g": pixelgl.KeyG, "h": pixelgl.KeyH,
-	"i": pixelgl.KeyI, "j": pixelgl.KeyJ, "k": pixelgl.KeyK, "l": pixelgl.KeyL,
-	"m": pixelgl.KeyM, "n": pixelgl.KeyN, "o": pixelgl.KeyO, "p": pixelgl.KeyP,
-	"q": pixelgl.KeyQ, "r": pixelgl.KeyR, "s": pixelgl.KeyS, "t": pixelgl.KeyT,
-	"u": pixelgl.KeyU, "v": pixelgl.KeyV, "w": pixelgl.KeyW, "x": pixelgl.KeyX,
-	"y": pixelgl.KeyY, "z": pixelgl.KeyZ,
-
-	// Numbers
-	"0": pixelgl.Key0, "1": pixelgl.Key1, "2": pixelgl.Key2, "3": pixelgl.Key3,
-	"4": pixelgl.Key4, "5": pixelgl.Key5, "6": pixelgl.Key6, "7": pixelgl.Key7,
-	"8": pixelgl.Key8, "9": pixelgl.Key9,
-
-	// Special keys
-	"space":     pixelgl.KeySpace,
-	"enter":     pixelgl.KeyEnter,
-	"escape":    pixelgl.KeyEscape,
-	"esc":       pixelgl.KeyEscape,
-	"backspace": pixelgl.KeyBackspace,
-	"tab":       pixelgl.KeyTab,
-
-	// Modifiers
-	"shift":      pixelgl.KeyLeftShift,
-	"leftshift":  pixelgl.KeyLeftShift,
-	"rightshift": pixelgl.KeyRightShift,
-	"ctrl":       pixelgl.KeyLeftControl,
-	"control":    pixelgl.KeyLeftControl,
-	"leftctrl":   pixelgl.KeyLeftControl,
-	"rightctrl":  pixelgl.KeyRightControl,
-	"alt":        pixelgl.KeyLeftAlt,
-	"leftalt":    pixelgl.KeyLeftAlt,
-	"rightalt":   pixelgl.KeyRightAlt,
-
-	// Arrow keys
-	"up":    pixelgl.KeyUp,
-	"down":  pixelgl.KeyDown,
-	"left":  pixelgl.KeyLeft,
-	"right": pixelgl.KeyRight,
-}
-
-func (w *OSLWindow) KeyPressed(key string) bool {
-	if w.win == nil {
-		return false
-	}
-
-	if btn, ok := OSLkeyMap[strings.ToLower(key)]; ok {
-		return w.win.Pressed(btn)
-	}
-
-	return false
-}
\ No newline at end of file
@@ -1,7 +1,9 @@
 // name: xml
 // description: XML parsing and manipulation utilities
 // author: roturbot
-// requires: encoding/xml, encoding/base64, strings
+// requires: encoding/xml as goxml, encoding/base64, strings
+
+type XML struct{}
 
 type OSLXML struct {
 	document *xmlConfig
@@ -33,7 +35,7 @@ func (XML) Parse(source any) *OSLXML {
 		Children:  []XMLNode{},
 	}
 
-	decoder := xml.NewDecoder(strings.NewReader(sourceStr))
+	decoder := goxml.NewDecoder(strings.NewReader(sourceStr))
 
 	for {
 		token, err := decoder.Token()
@@ -42,7 +44,7 @@ func (XML) Parse(source any) *OSLXML {
 		}
 
 		switch se := token.(type) {
-		case xml.StartElement:
+		case goxml.StartElement:
 			attrs := make(map[string]string)
 			for _, attr := range se.Attr {
 				attrs[attr.Name.Local] = attr.Value
@@ -52,7 +54,7 @@ func (XML) Parse(source any) *OSLXML {
 				Attrs:    attrs,
 				Children: []XMLNode{},
 			})
-		case xml.CharData:
+		case goxml.CharData:
 			text := strings.TrimSpace(string(se))
 			if len(root.Children) > 0 && text != "" {
 				root.Children[len(root.Children)-1].InnerText = text
@@ -277,7 +279,8 @@ func (x *OSLXML) set(path any, tag any) {
 	tagNode := x.parseTag(tagStr)
 	parts := strings.Split(pathStr, ">")
 
-	x.replaceNode(root, parts, 0, tagNode)
+	x.replaceNode(&root, parts, 0, tagNode)
+	x.document.Root = root
 }
 
 func (x *OSLXML) parseTag(tagStr string) XMLNode {
@@ -465,6 +468,7 @@ func (x *OSLXML) remove(path any) {
 	parts := strings.Split(pathStr, ">")
 
 	x.removeNode(&root, parts, 0)
+	x.document.Root = root
 }
 
 func (x *OSLXML) removeNode(node *XMLNode, parts []string, index int) bool {
@@ -472,7 +476,6 @@ func (x *OSLXML) removeNode(node *XMLNode, parts []string, index int) bool {
 		return false
 	}
 
-	targetName := strings.TrimSpace(parts[index])
 	targetNextName := strings.TrimSpace(parts[index+1])
 
 	if index == len(parts)-2 {
@@ -1,15 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
-
-	"github.com/dlclark/regexp2"
+	"unicode"
 )
 
 // Token types
@@ -67,7 +64,9 @@ type Token struct {
 	Data             any      `json:"data"`
 	ReturnedType     string   `json:"returnedType,omitempty"`
 	Source           string   `json:"source,omitempty"`
+	Offset           int      `json:"offset,omitempty"` // bytes from the start of the file, with line endings normalized
 	Line             int      `json:"line,omitempty"`
+	Column           int      `json:"column,omitempty"`
 	Left             *Token   `json:"left,omitempty"`
 	Right            *Token   `json:"right,omitempty"`
 	Right2           *Token   `json:"right2,omitempty"`
@@ -80,7 +79,13 @@ type Token struct {
 	Cases            any      `json:"cases,omitempty"`
 	Final            *Token   `json:"final,omitempty"`
 	Local            bool     `json:"local,omitempty"`
+	Shared           bool     `json:"shared,omitempty"`
 	StaticAssignment bool     `json:"staticAssignment,omitempty"`
+	Optional         bool     `json:"optional,omitempty"` // reached with ?. so it is skipped when the value before it is null
+	NonNull          bool     `json:"nonNull,omitempty"`  // asserted not to be null with a trailing !
+	Exported         bool     `json:"exported,omitempty"` // an export def, compiled to a C function by osl compile --shared
+	Comments         []string `json:"comments,omitempty"` // comments on the lines before the statement
+	Comment          string   `json:"comment,omitempty"`  // comment after the statement, on its first line
 }
 
 // FunctionSignature represents a function's type signature
@@ -101,11 +106,17 @@ type OSLUtils struct {
 
 	// Regex patterns
 	regex              *regexp.Regexp
-	fullASTRegex       *regexp2.Regexp
-	lineTokeniserRegex *regexp2.Regexp
 	lineEndingRegex    *regexp.Regexp
 	macLineEndingRegex *regexp.Regexp
 
+	// lexemes are those of the main file, and lineStarts holds the first one of
+	// code on each line
+	lexemes    []Lexeme
+	lineStarts map[int]int
+	// comments holds the comments of the main file by the line of the statement they
+	// belong to, for osl transpile
+	comments map[int]lineComments
+
 	// Optimization settings
 	optimizationSettings map[string]any
 	variableUsage        map[string]int
@@ -123,6 +134,23 @@ type OSLUtils struct {
 	commonStrings     map[string]string
 }
 
+// position describes where a token starts for error messages, as "Line 3" or
+// "Line 3:5" once the column is known
+func (t *Token) position() string {
+	if t.Column > 0 {
+		return fmt.Sprintf("Line %d:%d", t.Line, t.Column)
+	}
+	return fmt.Sprintf("Line %d", t.Line)
+}
+
+// startAt gives t the position of from, for a token that starts where another does,
+// like a + b starting at a
+func (t *Token) startAt(from *Token) {
+	if from != nil && from.Line > 0 {
+		t.Offset, t.Line, t.Column = from.Offset, from.Line, from.Column
+	}
+}
+
 // GenerateError creates an error token
 func (utils *OSLUtils) GenerateError(ast *Token, error string) []*Token {
 	// Simplified error generation
@@ -177,25 +205,6 @@ func NewOSLUtils() *OSLUtils {
 	// Initialize regex patterns
 	utils.regex = regexp.MustCompile(`"[^"]+"|{[^}]+}|\[[^\]]+\]|[^."(]*\((?:(?:"[^"]+")*[^.]+)*|\d[\d.]+\d|[^." ]+`)
 
-	var err error
-
-	utils.fullASTRegex, err = regexp2.Compile(
-		`("(?:[^"\\]|\\.)*"|`+
-			"`"+`(?:[^`+"`"+`\\]|\\.)*`+"`"+`|'(?:[^'\\]|\\.)*')|\/\*[^*]+|[,{\[]\s*[\r\n]\s*[}\]]?|[\r\n]\s*[}\.\]]|;|(?<=[)"\]}a-zA-Z\d])\[(?=[^\]])|(?<=[)\]])\(|([\r\n]|^)\s*\/\/[^\r\n]+|[\r\n]`,
-		0,
-	)
-	if err != nil {
-		panic(fmt.Sprintf("bad fullASTRegex: %v", err))
-	}
-
-	utils.lineTokeniserRegex, err = regexp2.Compile(
-		`("(?:[^"\\]|\\.)*"|`+
-			"`"+`(?:[^`+"`"+`\\]|\\.)*`+"`"+`|'(?:[^'\\]|\\.)*')|(?<=[\]"}\w\)])(?:\+\+|\?\?|->|==|!=|<=|>=|[><?+*^%/\-|&])(?=\S)`,
-		0,
-	)
-	if err != nil {
-		panic(fmt.Sprintf("bad lineTokeniserRegex: %v", err))
-	}
 	utils.lineEndingRegex = regexp.MustCompile(`\r\n`)
 	utils.macLineEndingRegex = regexp.MustCompile(`\r`)
 
@@ -217,14 +226,6 @@ func contains(slice []string, item string) bool {
 	return slices.Contains(slice, item)
 }
 
-func JsonStringify(obj any) string {
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.SetEscapeHTML(false)
-	enc.Encode(obj)
-	return strings.TrimSpace(buf.String())
-}
-
 // NormalizeLineEndings normalizes line endings to Unix-style
 func (utils *OSLUtils) NormalizeLineEndings(text string) string {
 	text = utils.lineEndingRegex.ReplaceAllString(text, "\n")
@@ -470,143 +471,6 @@ func (utils *OSLUtils) getTokenTypeString(tokenType string) string {
 	}
 }
 
-// TokeniseLineOSL tokenizes a single line of OSL code
-func (utils *OSLUtils) TokeniseLineOSL(code string) []string {
-	// Apply regex replacement for operators
-	code, err := utils.lineTokeniserRegex.ReplaceFunc(code, func(m regexp2.Match) string {
-		v := m.String()
-		if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, `'`) || strings.HasPrefix(v, "`") {
-			return v
-		}
-		return " " + v + " "
-	}, -1, -1)
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	letter := 0
-	quotes := 0
-	squotes := 0
-	backticks := 0
-	mComm := 0
-	bDepth := 0
-	escaped := false
-	out := strings.Builder{}
-	var split []string
-	length := len(code)
-
-	for letter < length {
-		if letter >= length {
-			break
-		}
-
-		depth := code[letter]
-
-		if quotes == 0 && squotes == 0 && backticks == 0 && !escaped {
-			if depth == '[' || depth == '{' || depth == '(' {
-				bDepth++
-			}
-			if depth == ']' || depth == '}' || depth == ')' {
-				bDepth--
-			}
-			if bDepth < 0 {
-				bDepth = 0
-			}
-		}
-
-		if depth == '"' && !escaped && squotes == 0 && backticks == 0 {
-			quotes = 1 - quotes
-		} else if depth == '\'' && !escaped && quotes == 0 && backticks == 0 {
-			squotes = 1 - squotes
-		} else if depth == '`' && !escaped && quotes == 0 && squotes == 0 {
-			backticks = 1 - backticks
-		} else if depth == '/' && letter+1 < length && code[letter+1] == '*' && quotes == 0 && squotes == 0 && backticks == 0 {
-			mComm = 1
-		} else if depth == '*' && letter+1 < length && code[letter+1] == '/' && quotes == 0 && squotes == 0 && backticks == 0 && mComm == 1 {
-			mComm = 0
-		} else if depth == '\\' && !escaped {
-			escaped = !escaped
-		} else {
-			escaped = false
-		}
-
-		if mComm == 0 {
-			out.WriteByte(depth)
-		}
-		letter++
-
-		if quotes == 0 && squotes == 0 && backticks == 0 && bDepth == 0 && mComm == 0 && letter < length && (code[letter] == ' ' || code[letter] == ')') {
-			if code[letter] != ' ' && code[letter] != ')' {
-				split = append(split, string(depth))
-			} else {
-				split = append(split, out.String())
-			}
-			out.Reset()
-			letter++
-		}
-	}
-	split = append(split, out.String())
-	return split
-}
-
-// TokeniseLines tokenizes code into lines
-func (utils *OSLUtils) TokeniseLines(code string) []string {
-	// Normalize line endings first
-	code = utils.NormalizeLineEndings(code)
-
-	letter := 0
-	quotes := 0
-	backticks := 0
-	bDepth := 0
-	escaped := false
-	out := strings.Builder{}
-	var split []string
-	length := len(code)
-
-	for letter < length {
-		if letter >= length {
-			break
-		}
-
-		depth := code[letter]
-
-		if quotes == 0 && backticks == 0 && !escaped {
-			if depth == '[' || depth == '{' || depth == '(' {
-				bDepth++
-			}
-			if depth == ']' || depth == '}' || depth == ')' {
-				bDepth--
-			}
-			if bDepth < 0 {
-				bDepth = 0
-			}
-		}
-
-		if depth == '"' && !escaped && backticks == 0 {
-			quotes = 1 - quotes
-			out.WriteByte('"')
-		} else if depth == '`' && !escaped && quotes == 0 {
-			backticks = 1 - backticks
-			out.WriteByte('`')
-		} else if depth == '\\' && !escaped {
-			escaped = !escaped
-			out.WriteByte('\\')
-		} else {
-			out.WriteByte(depth)
-			escaped = false
-		}
-		letter++
-
-		if quotes == 0 && backticks == 0 && letter < length && (code[letter] == '\n' || code[letter] == ';') && bDepth == 0 {
-			split = append(split, out.String())
-			out.Reset()
-			letter++
-		}
-	}
-	split = append(split, out.String())
-	return split
-}
-
 // FindMatchingParentheses finds the matching closing parenthesis
 func (utils *OSLUtils) FindMatchingParentheses(code string, startIndex int) int {
 	depth := 1
@@ -732,8 +596,18 @@ func (utils *OSLUtils) StringToToken(cur string, param bool) *Token {
 		method := AutoTokenise(cur, ".")
 		if len(method) >= 2 {
 			var tokens []*Token
+			optional := false
 			for i, input := range method {
-				tokens = append(tokens, utils.StringToToken(input, i > 0))
+				// a?.b marks b as optional, a!.b asserts a is not null
+				nextOptional := false
+				if len(input) > 1 && strings.HasSuffix(input, "?") {
+					input = input[:len(input)-1]
+					nextOptional = true
+				}
+				tok := utils.StringToToken(input, i > 0)
+				tok.Optional = optional
+				tokens = append(tokens, tok)
+				optional = nextOptional
 			}
 			return &Token{Type: TKN_MTD, Data: tokens}
 		}
@@ -767,6 +641,9 @@ func (utils *OSLUtils) StringToToken(cur string, param bool) *Token {
 					}
 
 					ast := utils.GenerateAST(cur, 0, false)
+					if len(ast) == 0 {
+						panic(fmt.Sprintf("Invalid array item %q", cur))
+					}
 					parsedTokens = append(parsedTokens, ast[0])
 				}
 
@@ -836,9 +713,14 @@ func (utils *OSLUtils) StringToToken(cur string, param bool) *Token {
 		}
 	}
 
-	matched := regexp.MustCompile(`^(!+)?[a-zA-Z_][a-zA-Z0-9_]*:?$`).MatchString(cur)
+	if len(cur) > 1 && cur[len(cur)-1] == '!' && start != '!' {
+		if tok := utils.StringToToken(cur[:len(cur)-1], param); tok.Type == TKN_VAR || tok.Type == TKN_MTV || tok.Type == TKN_FNC {
+			tok.NonNull = true
+			return tok
+		}
+	}
 
-	if matched {
+	if isVariableName(cur) {
 		return &Token{Type: TKN_VAR, Data: cur}
 	}
 
@@ -1012,34 +894,39 @@ func (utils *OSLUtils) evalASTNode(node *Token) *Token {
 }
 
 func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
+	return utils.generateAST(code, start, main, nil)
+}
+
+// generateAST builds the tokens of a statement. When at is given, the statement is
+// one of the main file and each token takes its position from the lexeme it starts with.
+func (utils *OSLUtils) generateAST(code string, start int, main bool, at *lexCursor) []*Token {
 	code = utils.NormalizeLineEndings(code)
 	startLine := strings.SplitN(code, "\n", 2)[0]
 	handlingMods := false
 
 	var ast []*Token
-	tokens := utils.TokeniseLineOSL(code)
-	for i := range tokens {
-		cur := strings.TrimSpace(tokens[i])
-
-		if cur == "->" {
-			ast = append(ast, &Token{Type: TKN_INL, Data: "->"})
-			continue
-		}
-
-		if handlingMods {
-			token := &Token{Type: TKN_MOD, Data: cur, Source: cur}
+	for _, piece := range splitLine(code) {
+		cur := strings.TrimSpace(piece.text)
+
+		var curT *Token
+		switch {
+		case cur == "->":
+			curT = &Token{Type: TKN_INL, Data: "->"}
+		case handlingMods:
+			curT = &Token{Type: TKN_MOD, Data: cur, Source: cur}
 			pivot := strings.Index(cur, "#")
 			if pivot >= 0 {
-				token.Data = []any{cur[:pivot], utils.EvalToken(cur[pivot+1:], false)}
+				curT.Data = []any{cur[:pivot], utils.EvalToken(cur[pivot+1:], false)}
+			}
+		default:
+			curT = utils.EvalToken(cur, false)
+			if curT.Type == TKN_MOD_INDICATOR {
+				handlingMods = true
+				continue
 			}
-			ast = append(ast, token)
-			continue
 		}
-
-		curT := utils.EvalToken(cur, false)
-		if curT.Type == TKN_MOD_INDICATOR {
-			handlingMods = true
-			continue
+		if at != nil {
+			at.place(curT, piece.lexemes)
 		}
 		ast = append(ast, curT)
 	}
@@ -1076,6 +963,7 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 
 				if nodeType == TKN_QST {
 					cur.Left = prev
+					cur.startAt(prev)
 					cur.Right = next
 					cur.Right2 = next2
 					if i > 0 {
@@ -1104,6 +992,7 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 				if cur.Left == nil && prev != nil && next != nil {
 					cur.Left = prev
 					cur.Right = next
+					cur.startAt(prev)
 					source := ""
 					if prev.Source != "" {
 						source += prev.Source
@@ -1147,6 +1036,19 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 
 		local := first.Data == "local" && first.Type == TKN_VAR
 
+		// export def name(params) ( ... ) is a def that osl compile --shared exports to C
+		exported := first.Data == "export" && first.Type == TKN_VAR && second != nil && second.Data == "def"
+		if exported {
+			ast = ast[1:]
+			first, second = ast[0], nil
+			if len(ast) > 1 {
+				second = ast[1]
+			}
+			if second == nil || second.Type != TKN_FNC {
+				return utils.GenerateError(first, "Only def name(params) ( ... ) functions can be exported")
+			}
+		}
+
 		if first.Type == TKN_VAR || first.Type == TKN_CMD {
 			if firstData, ok := first.Data.(string); ok && firstData == "def" {
 				if second != nil && second.Type == TKN_FNC {
@@ -1216,11 +1118,12 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 
 					ast = []*Token{
 						{
-							Type:   TKN_ASI,
-							Data:   "=",
-							Source: startLine,
-							Left:   &Token{Type: TKN_VAR, Data: funcName, Source: funcName},
-							Right:  funcNode,
+							Type:     TKN_ASI,
+							Data:     "=",
+							Source:   startLine,
+							Left:     &Token{Type: TKN_VAR, Data: funcName, Source: funcName},
+							Right:    funcNode,
+							Exported: exported,
 						},
 					}
 
@@ -1305,6 +1208,11 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 					// Remove the local keyword without modifying the variable name
 					ast = append(ast[:0], ast[1:]...)
 					i--
+				} else if ok && data == "shared" && i > 1 {
+					// shared marks a global that is safe to use from goroutines
+					cur.Shared = true
+					ast = append(ast[:0], ast[1:]...)
+					i--
 				}
 			}
 
@@ -1314,6 +1222,15 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 					if data, ok := ast[i-2].Data.(string); ok {
 						typeData = data
 					}
+					// string null name = value declares a nullable string
+					if typeData == "null" && i > 2 && ast[i-3] != nil {
+						if base, ok := ast[i-3].Data.(string); ok && base != "" {
+							cur.SetType = base + " null"
+							ast = append(ast[:i-3], ast[i-1:]...)
+							i -= 2
+							typeData = ""
+						}
+					}
 					if typeData != "" {
 						cur.SetType = typeData
 						ast = append(ast[:i-2], ast[i-1:]...)
@@ -1333,6 +1250,7 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 
 				cur.Left = prev
 				cur.Right = next
+				cur.startAt(prev)
 
 				if i > 0 {
 					ast = append(ast[:i-1], ast[i:]...)
@@ -1408,66 +1326,34 @@ func (utils *OSLUtils) GenerateAST(code string, start int, main bool) []*Token {
 	return filtered
 }
 
+// defReturnArrowRegex matches the return type of def name(params) -> type (, which
+// means the same as def name(params) type (
+var defReturnArrowRegex = regexp.MustCompile(`^((?:export\s+)?def\s+[^(]*\([^)]*\))\s*->\s*([^\s(]+)\s*\(`)
+
+// isDefLine reports whether a line defines a function, exported or not
+func isDefLine(line string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(line, "export "), "def ")
+}
+
 func (utils *OSLUtils) GenerateFullAST(code string, main bool) [][]*Token {
 	if main {
 		utils.inlinableFunctions = make(map[string]any)
 	}
 
-	line := 0
-	code = utils.NormalizeLineEndings(strings.TrimSpace(code))
+	// space trimmed from the top still counts towards positions
+	code = utils.NormalizeLineEndings(code)
+	trimmed := strings.TrimLeftFunc(code, unicode.IsSpace)
+	lead := code[:len(code)-len(trimmed)]
+	origin := Lexeme{Offset: len(lead), Line: 1 + strings.Count(lead, "\n"), Column: len(lead) - strings.LastIndexByte(lead, '\n')}
+	code = strings.TrimRightFunc(trimmed, unicode.IsSpace)
 
-	// Add line markers for main context
 	if main {
-		line++
-		code = fmt.Sprintf("/@line %d\n", line) + code
-	}
-
-	// Apply regex transformations
-	re := regexp.MustCompile(`\n\s*\.`)
-	code, err := utils.fullASTRegex.ReplaceFunc(code, func(m regexp2.Match) string {
-		match := m.String()
-		if strings.HasPrefix(strings.TrimSpace(match), "//") {
-			return match
-		}
-		if match == "\n" {
-			if main {
-				line++
-				return fmt.Sprintf("\n/@line %d\n", line)
-			}
-			return "\n"
-		}
-		if match == ";" {
-			return "\n"
-		}
-		if match == "(" {
-			return ".call("
-		}
-		if match == "[" {
-			return ".["
-		}
-		if strings.Contains(",{}[]", string(match[0])) {
-			line++
-			return match
-		}
-		if strings.HasPrefix(match, "\n") {
-			line++
-			return re.ReplaceAllString(match, ".")
-		}
-		return match
-	}, -1, -1)
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	// Remove comment lines
-	comment_lines := strings.Split(code, "\n")
-	var comment_filteredLines []string
-	for _, line := range comment_lines {
-		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
-			comment_filteredLines = append(comment_filteredLines, line)
-		}
+		utils.lineStarts = make(map[int]int)
+		utils.comments = make(map[int]lineComments)
+		code = fmt.Sprintf("/@line %d\n", origin.Line) + utils.prepareSource(code, origin, main)
+	} else {
+		code = utils.prepareSource(code, origin, main)
 	}
-	code = strings.Join(comment_filteredLines, "\n")
 
 	// Handle def statements
 	codeLines := AutoTokenise(code, "\n")
@@ -1475,23 +1361,50 @@ func (utils *OSLUtils) GenerateFullAST(code string, main bool) [][]*Token {
 		line = strings.TrimSpace(line)
 		if line == "endef" {
 			codeLines[i] = ")"
-		} else if strings.HasPrefix(line, "def ") && !strings.HasSuffix(line, "(") && !strings.HasSuffix(line, ")") {
-			codeLines[i] = line + " ("
+		} else if isDefLine(line) && !strings.HasSuffix(line, "(") && !strings.HasSuffix(line, ")") {
+			codeLines[i] = defReturnArrowRegex.ReplaceAllString(line+" (", "$1 $2 (")
+		} else if isDefLine(line) {
+			codeLines[i] = defReturnArrowRegex.ReplaceAllString(line, "$1 $2 (")
 		} else {
 			codeLines[i] = line
 		}
 	}
 	code = strings.Join(codeLines, "\n")
 
-	// Generate AST for each line
-	lineTokens := utils.TokeniseLines(code)
+	// Generate AST for each line. A /@line marker says where the statements after it
+	// start in the main file, so their tokens can be placed.
 	var lines [][]*Token
+	var at *lexCursor
 
-	for _, line := range lineTokens {
-		ast := utils.GenerateAST(strings.TrimSpace(line), -1, true)
-		if len(ast) > 0 {
-			lines = append(lines, ast)
+	for _, line := range splitStatements(code) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if marked, ok := strings.CutPrefix(line, "/@line "); ok {
+			at = nil
+			if n, err := strconv.Atoi(marked); err == nil && utils.lexemes != nil {
+				if i, ok := utils.lineStarts[n]; ok {
+					at = &lexCursor{lexemes: utils.lexemes, i: i}
+				}
+			}
+			lines = append(lines, utils.generateAST(line, -1, true, nil))
+			continue
+		}
+		if at != nil {
+			at.first = -1
+		}
+		ast := utils.generateAST(line, -1, true, at)
+		if len(ast) == 0 {
+			continue
+		}
+		// a statement starts where its first token does, even when that token ends up
+		// inside another, like the x of x = 1
+		if at != nil && at.first >= 0 {
+			lx := utils.lexemes[at.first]
+			ast[0].Offset, ast[0].Line, ast[0].Column = lx.Offset, lx.Line, lx.Column
 		}
+		lines = append(lines, ast)
 	}
 
 	// Handle line markers
@@ -1502,7 +1415,13 @@ func (utils *OSLUtils) GenerateFullAST(code string, main bool) [][]*Token {
 					if lineNum, ok := lines[i][1].Data.(float64); ok {
 						// Set line number on next statement
 						if i+1 < len(lines) && len(lines[i+1]) > 0 {
-							lines[i+1][0].Line = int(lineNum)
+							if lines[i+1][0].Line == 0 {
+								lines[i+1][0].Line = int(lineNum)
+							}
+							if c, ok := utils.comments[int(lineNum)]; ok {
+								lines[i+1][0].Comments = c.leading
+								lines[i+1][0].Comment = strings.Join(c.trailing, " ")
+							}
 						}
 					}
 				}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// parserSeeds are where fuzzing the lexer and parser starts from, along with the examples
+var parserSeeds = []string{
+	"log \"hello\"\n",
+	"x = 1\nnumber y = x + 2 * 3\nlog y\n",
+	"def add(number a, number b) -> number (\n  return a + b\n)\nlog add(1, 2)\n",
+	"arr = [1, \"two\", [3]]\nobj = {a: 1, b: {c: arr}}\nlog obj.b.c[1]\n",
+	"if x > 1 (\n  log \"big\"\n) else if x == 1 (\n  log \"one\"\n) else (\n  log \"small\"\n)\n",
+	"for i 10 (\n  loop 3 (\n    each item arr (\n      log i item\n    )\n  )\n)\n",
+	"type Point (\n  number x = 0\n  number y = 0\n  len = def() -> (\n    return self.x + self.y\n  )\n)\n",
+	"s = `a${1 + 2}b`\nlog s.split(\"a\").join(\",\")\nlog s ?? \"none\"\n",
+	"fn = def(a) -> (return a * 2)\nlog [1, 2].map(fn)\nlog 1 to 5\n",
+	"switch x (\n  case 1\n    log \"one\"\n    break\n  default\n    log \"other\"\n)\n",
+	"#if os == \"linux\"\nlog \"linux\"\n#else\nlog \"other\"\n#end\n",
+	"mainloop:\ngoto 0 0\nsquare 10 10 : c#fff\n",
+	"a = 1; b = a+2 // sum\n  .toString()\nlog f()(1) x[0]\n",
+}
+
+// FuzzParser checks that the parser only fails with syntax errors, which it panics with
+// as strings, and that every token it places is at its own line and column. #if lines
+// are left to the parser, as preprocess runs their conditions.
+func FuzzParser(f *testing.F) {
+	for _, seed := range parserSeeds {
+		f.Add(seed)
+	}
+	examples, _ := filepath.Glob(filepath.Join("examples", "*", "*.osl"))
+	for _, path := range examples {
+		if data, err := os.ReadFile(path); err == nil {
+			f.Add(string(data))
+		}
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		var ast [][]*Token
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if _, syntax := r.(string); !syntax {
+						panic(r)
+					}
+				}
+			}()
+			ast = NewOSLUtils().GenerateFullAST(src, true)
+		}()
+
+		code := parser.NormalizeLineEndings(src)
+		var check func(tok *Token)
+		check = func(tok *Token) {
+			if tok == nil {
+				return
+			}
+			if tok.Column > 0 {
+				if tok.Offset < 0 || tok.Offset >= len(code) {
+					t.Fatalf("%s token %q is at offset %d, outside the source", tok.Type, tok.Source, tok.Offset)
+				}
+				if line, column := sourcePosition(code, tok.Offset); tok.Line != line || tok.Column != column {
+					t.Fatalf("%s token %q at offset %d is at %d:%d, want %d:%d", tok.Type, tok.Source, tok.Offset, tok.Line, tok.Column, line, column)
+				}
+			}
+			for _, child := range append([]*Token{tok.Left, tok.Right, tok.Right2, tok.Final}, append(tok.Parameters, tok.ObjPath...)...) {
+				check(child)
+			}
+			switch data := tok.Data.(type) {
+			case []*Token:
+				for _, child := range data {
+					check(child)
+				}
+			case [][]*Token:
+				for _, line := range data {
+					for _, child := range line {
+						check(child)
+					}
+				}
+			}
+		}
+		for _, line := range ast {
+			for _, tok := range line {
+				check(tok)
+			}
+		}
+	})
+}
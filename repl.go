@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const REPL_HELP = `Enter OSL lines or ( ... ) blocks. Expressions print their value and type.
+
+Every input runs again with everything entered before it, only its own output is
+shown. Earlier side effects, like writing files or sending requests, happen again.
+
+Commands:
+  :type <expr>               Show the type of an expression
+  :ast <code>                Show the AST for a line of code
+  :import <package>          Import a package, eg. :import osl/math
+  :program                   Print the accumulated program
+  :history                   List previous inputs (recall with !n or !!)
+  :reset                     Forget every variable, def and import
+  :help                      Show this help message
+  :quit                      Leave the repl`
+
+// replMarker separates the output of replayed inputs from the output of the newest one
+const replMarker = "\x1eosl-repl\x1e"
+
+// replResult is the variable expressions are stored in so their value and type can be logged
+const replResult = "__repl"
+
+// replSession holds the accumulated program. Every input is compiled together
+// with everything before it and only the output after replMarker is shown, so
+// the earlier inputs run again each time, side effects and all.
+type replSession struct {
+	entries     []string
+	vars        map[string]bool
+	packages    map[string]bool // imported osl packages, whose method calls are expressions
+	history     []string
+	historyPath string
+	tmpDir      string
+	workDir     string
+}
+
+func newReplSession() (*replSession, error) {
+	tmpDir, err := os.MkdirTemp("", "osl-repl-*")
+	if err != nil {
+		return nil, err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := copyGoModFiles(workDir, tmpDir); err != nil {
+		// not fatal
+		fmt.Println("Warning: failed to copy go.mod/go.sum:", err)
+	}
+
+	s := &replSession{
+		vars:     map[string]bool{},
+		packages: map[string]bool{},
+		tmpDir:   tmpDir,
+		workDir:  workDir,
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		s.historyPath = filepath.Join(home, ".osl_history")
+		s.loadHistory()
+	}
+	return s, nil
+}
+
+func (s *replSession) loadHistory() {
+	data, err := os.ReadFile(s.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, err := strconv.Unquote(line); err == nil {
+			s.history = append(s.history, entry)
+		}
+	}
+}
+
+func (s *replSession) addHistory(entry string) {
+	if len(s.history) > 0 && s.history[len(s.history)-1] == entry {
+		return
+	}
+	s.history = append(s.history, entry)
+	if s.historyPath == "" {
+		return
+	}
+	f, err := os.OpenFile(s.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strconv.Quote(entry))
+}
+
+// replDepth returns how many brackets are left open in code, ignoring strings and comments
+func replDepth(code string) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, line := range strings.Split(code, "\n") {
+		for i, c := range line {
+			if quote != 0 {
+				if escaped {
+					escaped = false
+				} else if c == '\\' {
+					escaped = true
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '/' && strings.HasPrefix(line[i:], "//") {
+				break
+			}
+			switch c {
+			case '"', '\'', '`':
+				quote = c
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth--
+			}
+		}
+		// only backtick strings span lines
+		if quote != '`' {
+			quote = 0
+		}
+	}
+	return depth
+}
+
+// program builds the source for the accumulated entries followed by extra
+func (s *replSession) program(extra ...string) string {
+	var sb strings.Builder
+	for _, entry := range s.entries {
+		sb.WriteString(entry)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("log \"" + replMarker + "\"\n")
+	for _, line := range extra {
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	// every input ends the program, so variables it assigns would never be used
+	// and Go refuses to build it
+	var names []string
+	for _, code := range append(slices.Clone(s.entries), extra...) {
+		names = append(names, assignedNames(code)...)
+	}
+	if len(names) > 0 {
+		sb.WriteString("if false (\n")
+		for _, name := range names {
+			sb.WriteString("log " + name + "\n")
+		}
+		sb.WriteString(")\n")
+	}
+	return sb.String()
+}
+
+// build compiles an OSL program into a binary inside the session's temp dir
+func (s *replSession) build(script string) (binaryPath string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	allFunctionTypes = map[string]FunctionSignature{}
+	source := scriptToGo(script)
+
+	goFile := filepath.Join(s.tmpDir, "main.go")
+	if err := os.WriteFile(goFile, []byte(source), 0644); err != nil {
+		return "", err
+	}
+	if err := copyEmbeddedFiles(s.workDir, s.tmpDir); err != nil {
+		return "", err
+	}
+
+	binName := "program"
+	if runtime.GOOS == "windows" {
+		binName = "program.exe"
+	}
+	binaryPath = filepath.Join(s.tmpDir, binName)
+
+	opts := buildOptions{}
+	buildCmd := exec.Command("go", opts.goBuildArgs(binaryPath, goFile)...)
+	buildCmd.Dir = s.tmpDir
+	output, err := buildCmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("build failed:\n%s", strings.TrimSpace(string(output)))
+	}
+	return binaryPath, nil
+}
+
+// exec runs a built program and returns the stdout lines written after replMarker
+func (s *replSession) exec(binaryPath string) ([]string, error) {
+	var stdout bytes.Buffer
+	runCmd := exec.Command(binaryPath)
+	runCmd.Dir = s.workDir
+	runCmd.Stdout = &stdout
+	runCmd.Stderr = os.Stderr
+
+	// ctrl+c stops the program, not the repl
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	if err := runCmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- runCmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-interrupt:
+		runCmd.Process.Kill()
+		<-done
+		err = fmt.Errorf("interrupted")
+	}
+
+	out := stdout.String()
+	if i := strings.Index(out, replMarker+"\n"); i >= 0 {
+		out = out[i+len(replMarker)+1:]
+	}
+	out = strings.TrimSuffix(out, "\n")
+	if out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\n"), err
+}
+
+// run compiles and runs the accumulated program with extra appended
+func (s *replSession) run(extra ...string) ([]string, error) {
+	binaryPath, err := s.build(s.program(extra...))
+	if err != nil {
+		return nil, err
+	}
+	return s.exec(binaryPath)
+}
+
+// replExpression assigns expr to replResult and runs then. Wrapping expr in an
+// array keeps the assignment out of the hoisted constants, so it runs in main
+// after every earlier statement.
+func replExpression(expr string, then ...string) string {
+	return replResult + " = [" + expr + "][1]\n" + strings.Join(then, "\n")
+}
+
+// isExpression reports whether a single line of input should have its value shown
+func (s *replSession) isExpression(input string) bool {
+	if strings.Contains(input, "\n") {
+		return false
+	}
+	ast := parser.GenerateFullAST(input, false)
+	if len(ast) != 1 || len(ast[0]) != 1 {
+		return false
+	}
+	token := ast[0][0]
+	switch token.Type {
+	case TKN_ASI:
+		// a method call on its own is parsed as an assignment back to its receiver,
+		// which is only a plain call for packages like math.abs(-4)
+		if token.Data == "=??" && token.Left != nil && token.Left.Type == TKN_VAR {
+			name, _ := token.Left.Data.(string)
+			return s.packages[name]
+		}
+		return false
+	case TKN_CMD:
+		// a lone name is parsed as a command call
+		name, _ := token.Data.(string)
+		return s.vars[name]
+	}
+	return true
+}
+
+// assignedNames returns the variables the top level lines of code assign to
+func assignedNames(code string) []string {
+	var names []string
+	for _, line := range parser.GenerateFullAST(code, false) {
+		// =?? is a method call on its own, not an assignment
+		if len(line) == 0 || line[0].Type != TKN_ASI || line[0].Data == "=??" || line[0].Left == nil || line[0].Left.Type != TKN_VAR {
+			continue
+		}
+		if name, ok := line[0].Left.Data.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// remember records the variables an accepted entry assigns to and the packages it imports
+func (s *replSession) remember(entry string) {
+	for _, line := range parser.GenerateFullAST(entry, false) {
+		if len(line) > 1 && line[0].Type == TKN_CMD && line[0].Data == "import" {
+			if path, ok := line[1].Data.(string); ok && strings.HasPrefix(path, "osl/") {
+				s.packages[strings.TrimPrefix(path, "osl/")] = true
+			}
+		}
+	}
+	for _, name := range assignedNames(entry) {
+		s.vars[name] = true
+	}
+}
+
+func (s *replSession) evaluate(input string) {
+	if s.isExpression(input) {
+		lines, err := s.run(replExpression(input, "log "+replResult, "log typeof("+replResult+")"))
+		if err == nil && len(lines) >= 2 {
+			for _, line := range lines[:len(lines)-2] {
+				fmt.Println(line)
+			}
+			fmt.Printf("%v : %v\n", lines[len(lines)-2], lines[len(lines)-1])
+			return
+		}
+		// expressions without a value, like calls to void functions, run as statements
+	}
+
+	lines, err := s.run(input)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	s.entries = append(s.entries, input)
+	s.remember(input)
+}
+
+func (s *replSession) showType(expr string) {
+	lines, err := s.run(replExpression(expr, "log typeof("+replResult+")"))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(lines) > 0 {
+		fmt.Println(lines[len(lines)-1])
+	}
+}
+
+func (s *replSession) showAst(code string) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Error:", r)
+		}
+	}()
+	ast := parser.GenerateFullAST(code, false)
+	if len(ast) == 1 && len(ast[0]) == 1 && ast[0][0].Type == TKN_UNK && !strings.Contains(code, "\n") {
+		// not a valid statement, show it as an expression instead
+		ast = [][]*Token{parser.GenerateAST(code, 0, false)}
+	}
+	data, err := json.MarshalIndent(ast, "", "  ")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// command handles a :command, returning false when the repl should exit
+func (s *replSession) command(input string) bool {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":quit", ":exit", ":q":
+		return false
+	case ":help":
+		fmt.Println(REPL_HELP)
+	case ":type":
+		if arg == "" {
+			fmt.Println("Usage: :type <expr>")
+			break
+		}
+		s.showType(arg)
+	case ":ast":
+		if arg == "" {
+			fmt.Println("Usage: :ast <code>")
+			break
+		}
+		s.showAst(arg)
+	case ":import":
+		if arg == "" {
+			fmt.Println("Usage: :import <package>")
+			break
+		}
+		s.evaluate("import " + strconv.Quote(strings.Trim(arg, "\"")))
+	case ":program":
+		for _, entry := range s.entries {
+			fmt.Println(entry)
+		}
+	case ":history":
+		for i, entry := range s.history {
+			fmt.Printf("%4d  %v\n", i+1, strings.ReplaceAll(entry, "\n", "\n      "))
+		}
+	case ":reset":
+		s.entries = nil
+		s.vars = map[string]bool{}
+		s.packages = map[string]bool{}
+		fmt.Println("Cleared all variables, defs and imports")
+	default:
+		fmt.Println("Unknown command:", name, "(try :help)")
+	}
+	return true
+}
+
+// recall expands !! and !n into an entry from the history
+func (s *replSession) recall(input string) (string, bool) {
+	if input == "!!" {
+		if len(s.history) == 0 {
+			fmt.Println("History is empty")
+			return "", false
+		}
+		return s.history[len(s.history)-1], true
+	}
+	n, err := strconv.Atoi(input[1:])
+	if err != nil || n < 1 || n > len(s.history) {
+		fmt.Println("No such history entry:", input)
+		return "", false
+	}
+	return s.history[n-1], true
+}
+
+func repl(args []string) {
+	s, err := newReplSession()
+	if err != nil {
+		fmt.Println("Failed to start repl:", err)
+		return
+	}
+	defer os.RemoveAll(s.tmpDir)
+
+	for _, arg := range args {
+		s.evaluate("import " + strconv.Quote(arg))
+	}
+
+	fmt.Printf("OSL v%v repl, type :help for commands\n", OSL_VERSION)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for {
+		fmt.Print("osl> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		input := scanner.Text()
+
+		// keep reading while a ( ... ) block is open
+		for replDepth(input) > 0 {
+			fmt.Print("...> ")
+			if !scanner.Scan() {
+				fmt.Println()
+				return
+			}
+			input += "\n" + scanner.Text()
+		}
+
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+
+		if input == "!!" || (len(input) > 1 && input[0] == '!' && strings.Trim(input[1:], "0123456789") == "") {
+			recalled, ok := s.recall(input)
+			if !ok {
+				continue
+			}
+			input = recalled
+			fmt.Println(input)
+		}
+		s.addHistory(input)
+
+		if strings.HasPrefix(input, ":") {
+			if !s.command(input) {
+				return
+			}
+			continue
+		}
+		s.evaluate(input)
+	}
+}
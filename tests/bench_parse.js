@@ -0,0 +1,62 @@
+// Times how long osl takes to parse a large .osl file, built by repeating the
+// code of every unit test until it reaches the wanted number of lines.
+//
+//   node bench_parse.js [--lines 50000] [--runs 5] [other-osl-binary...]
+//
+// Pass another osl binary, eg. one built from an older commit, to compare the two.
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+const { execFileSync } = require('child_process');
+
+const args = process.argv.slice(2);
+function option(name, fallback) {
+  const i = args.indexOf(name);
+  if (i === -1) return fallback;
+  const value = Number(args[i + 1]);
+  args.splice(i, 2);
+  return value;
+}
+const targetLines = option('--lines', 50000);
+const runs = option('--runs', 5);
+const binaries = [path.join(__dirname, '..', 'osl'), ...args];
+
+function buildSource() {
+  const chunks = [];
+  for (const file of fs.readdirSync(path.join(__dirname, 'unit')).sort()) {
+    if (!file.endsWith('.test.js')) continue;
+    const { tests } = require(path.join(__dirname, 'unit', file));
+    for (const test of tests || []) chunks.push(test.code);
+  }
+  const block = chunks.join('\n');
+  const blockLines = block.split('\n').length;
+  const copies = Math.max(1, Math.ceil(targetLines / blockLines));
+  return Array(copies).fill(block).join('\n');
+}
+
+const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'osl-bench-'));
+const file = path.join(dir, 'large.osl');
+const source = buildSource();
+fs.writeFileSync(file, source);
+const lines = source.split('\n').length;
+console.log(`Parsing ${file} (${lines} lines, ${(source.length / 1024).toFixed(0)} KiB), best of ${runs}`);
+
+const results = [];
+for (const binary of binaries) {
+  const times = [];
+  for (let i = 0; i < runs; i++) {
+    const start = process.hrtime.bigint();
+    execFileSync(binary, ['ast', file], { stdio: 'ignore' });
+    times.push(Number(process.hrtime.bigint() - start) / 1e6);
+  }
+  const best = Math.min(...times);
+  results.push(best);
+  console.log(`  ${binary}: ${best.toFixed(0)}ms (${Math.round(lines / best * 1000)} lines/s)`);
+}
+if (results.length > 1) {
+  for (let i = 1; i < results.length; i++) {
+    console.log(`  ${binaries[0]} is ${(results[i] / results[0]).toFixed(2)}x the speed of ${binaries[i]}`);
+  }
+}
+
+fs.rmSync(dir, { recursive: true, force: true });
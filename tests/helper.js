@@ -7,6 +7,11 @@ class OSLTestHelper {
       name,
       code,
       expect: options.expect ?? [],
+      // files written next to test.osl, by name
+      files: options.files ?? {},
+      // a shell command run in the test directory instead of osl run test.osl, with $OSL set
+      run: options.run,
+      stdin: options.stdin,
       _logs: []
     };
   }
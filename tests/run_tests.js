@@ -6,8 +6,11 @@ const TEST_ROOT = path.join(__dirname, 'unit');
 const tests = new Map(); // id -> test
 const completed = new Set(); // ids
 
+// --interp runs every test with the interpreter instead of compiling it
+const interp = process.argv.includes('--interp');
+
 // Parse command line arguments for specific test files
-const specificFiles = process.argv.slice(2).map(f => 
+const specificFiles = process.argv.slice(2).filter(f => f !== '--interp').map(f => 
   f.endsWith('.test.js') ? f : (f.endsWith('.js') ? f : f + '.test.js')
 );
 
@@ -63,14 +66,21 @@ for (const test of tests.values()) {
   const tempDir = fs.mkdtempSync('osl-test-');
   const testFile = path.join(tempDir, 'test.osl');
   fs.writeFileSync(testFile, test.code);
+  for (const [name, content] of Object.entries(test.files)) {
+    fs.mkdirSync(path.dirname(path.join(tempDir, name)), { recursive: true });
+    fs.writeFileSync(path.join(tempDir, name), content);
+  }
 
   try {
     // Run the test
-    const output = execSync(`"${oslPath}" run "${testFile}"`, {
-      cwd: __dirname,
+    const command = test.run ?? `"${oslPath}" run "${testFile}"${interp ? ' --interp' : ''}`;
+    const output = execSync(command, {
+      cwd: test.run ? path.resolve(tempDir) : __dirname,
+      env: { ...process.env, OSL: oslPath },
+      input: test.stdin,
       encoding: 'utf-8',
       stdio: ['pipe', 'pipe', 'pipe'],
-      timeout: 30000
+      timeout: test.run ? 120000 : 30000
     });
 
     // Normalize output
@@ -48,6 +48,17 @@ const tests = [
       log result
     `,
     { expect: [[1,2,3,4,5]] }
+  ),
+
+  helper.createTest(
+    'Join with and without a separator',
+    `
+      a = [1, 2]
+      log a.join()
+      log "abc".split("").join()
+      log a.join("-")
+    `,
+    { expect: [[1,2], ["a","b","c"], "1-2"] }
   )
 ];
 
@@ -0,0 +1,421 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'osl run rejects arguments it does not know',
+    `
+      log "ran"
+    `,
+    {
+      run: '"$OSL" run test.osl extra; "$OSL" run test.osl',
+      expect: [
+        "Usage: osl run <file.osl> [--interp] [--headless] [--pgo <file.pgo>] [--race] [--profile [dir]]",
+        "ran"
+      ]
+    }
+  ),
+  helper.createTest(
+    'osl run --interp calls def main after the top level code',
+    `
+      words = ["b", "a"]
+
+      def shout(s) -> string (
+        return s.toUpper()
+      )
+
+      def main() (
+        log words.join()
+        log words.join("-")
+        log words.map(w -> shout(w))
+        log words.reverse().concat(["c"])
+        log (1 to 2).fill(0)
+        log "banana".count("an") words.count("a")
+      )
+    `,
+    {
+      run: '"$OSL" run test.osl --interp; echo $?',
+      expect: [["b", "a"], "b-a", ["B", "A"], ["a", "b", "c"], [0, 0], 2, 1, 0]
+    }
+  ),
+  helper.createTest(
+    'osl compile --target wasm and wasip1 build modules that run',
+    `
+      log "hello" 1 + 2
+    `,
+    {
+      // node runs both, the browser target through go's loader and wasip1 through node:wasi
+      run: `"$OSL" compile test.osl --target wasm -o js.wasm > /dev/null && ls js.html && ` +
+        `node "$(go env GOROOT)/lib/wasm/wasm_exec_node.js" js.wasm && ` +
+        `"$OSL" compile test.osl --target wasip1 -o wasi.wasm > /dev/null && ` +
+        `node --no-warnings -e 'const { WASI } = require("node:wasi"); const wasi = new WASI({ version: "preview1" }); ` +
+        `WebAssembly.instantiate(require("fs").readFileSync("wasi.wasm"), wasi.getImportObject()).then(({ instance }) => wasi.start(instance))'`,
+      expect: ["js.html", "hello", 3, "hello", 3]
+    }
+  ),
+  helper.createTest(
+    'osl compile --target rejects packages the target cannot run',
+    `
+      import "osl/fs"
+      import "osl/window"
+      log "never built"
+    `,
+    {
+      run: `"$OSL" compile test.osl --target wasm; "$OSL" compile test.osl --target wasip1; ls *.wasm 2> /dev/null | wc -l`,
+      expect: [
+        "Error: line 2: osl/fs cannot be used with --target wasm, browsers have no file system",
+        "Error: line 3: osl/window cannot be used with --target wasip1, pixelgl needs OpenGL through cgo, which wasm does not support",
+        0
+      ]
+    }
+  ),
+  helper.createTest(
+    'osl transpile warns and leaves out text when the window font cannot be downloaded',
+    `
+      import "osl/window"
+      log "ran"
+    `,
+    {
+      // the proxy refuses connections, like being offline
+      run: 'HTTPS_PROXY=http://127.0.0.1:1 "$OSL" transpile test.osl > out.go 2> err.txt; echo $?; ' +
+        'cut -d: -f1,2 err.txt; grep -c "var OSLfont map" out.go',
+      expect: [0, "Warning: could not download the window font, text will not be drawn", 1]
+    }
+  ),
+  helper.createTest(
+    'osl run warns when a local package overrides a built in one',
+    `
+      import "osl/cmd"
+      log cmd.Run("ls")
+    `,
+    {
+      files: {
+        'osl_packages/cmd.go': [
+          '// name: cmd',
+          '// description: Command line utilities that never run anything',
+          '// author: test',
+          '',
+          'type Cmd struct{}',
+          '',
+          'func (Cmd) Run(cmd string, args ...string) string {',
+          '\treturn "not run"',
+          '}',
+          '',
+          'var cmd = Cmd{}',
+        ].join('\n')
+      },
+      run: 'HOME=. "$OSL" run test.osl 2>&1',
+      expect: [
+        "Warning: osl/cmd is loaded from osl_packages/cmd.go, which overrides the built in osl/cmd",
+        "not run"
+      ]
+    }
+  ),
+  helper.createTest(
+    'osl package validate passes for every built in package',
+    ``,
+    {
+      run: 'HOME=. "$OSL" package validate > out.txt; echo $?; grep -cv "^ok" out.txt; grep "osl/cache \\|osl/colors \\|osl/ftp " out.txt',
+      expect: [0, 0, "ok    osl/cache (built in)", "ok    osl/colors (built in)", "ok    osl/ftp (built in)"]
+    }
+  ),
+  helper.createTest(
+    'osl/cache builds, with map and createDefault working on a cache',
+    `
+      import "osl/cache"
+      def times10(key, value) (
+        return value * 10
+      )
+      c = cache.create(10, 0)
+      c.set("a", 1)
+      c.set("b", 2)
+      log c.map(times10)
+      log cache.createDefault().size()
+    `,
+    { expect: [{ a: 10, b: 20 }, 0] }
+  ),
+  helper.createTest(
+    'osl doc shows a package and one of its methods',
+    '',
+    {
+      files: {
+        'osl_packages/greet.go': [
+          '// name: greet',
+          '// description: Greetings',
+          '// author: test',
+          '',
+          'type Greet struct{}',
+          '',
+          '// hello greets name, times times',
+          'func (Greet) hello(name string, times int) string {',
+          '\treturn name',
+          '}',
+          '',
+          'var greet = Greet{}',
+        ].join('\n')
+      },
+      run: 'HOME=. "$OSL" doc greet; HOME=. "$OSL" doc greet hello',
+      expect: [
+        'import "osl/greet"',
+        '',
+        'Greetings',
+        'by test, from osl_packages/greet.go',
+        '',
+        '  greet.hello(string name, int times) string',
+        '      hello greets name, times times',
+        'greet.hello(string name, int times) string',
+        '',
+        '    hello greets name, times times'
+      ]
+    }
+  ),
+  helper.createTest(
+    'osl doc leaves packages the compiler imports out of its index',
+    '',
+    {
+      run: 'HOME=. "$OSL" doc > out.txt; grep -c "^  \\(bench\\|fuzz\\|mainloop\\|profile\\) " out.txt; grep "^  \\(cache\\|window\\) " out.txt; HOME=. "$OSL" doc mainloop | head -1',
+      expect: [0, "  cache            In-memory LRU cache utilities", "  window           Window drawing, shown with pixelgl or rendered headless to png", 'import "osl/mainloop"']
+    }
+  ),
+  helper.createTest(
+    'osl new makes every built in template into a project that builds and passes its tests',
+    '',
+    {
+      run: [
+        'for t in $(HOME=. "$OSL" new | awk \'$2 == "built" {print $1}\'); do',
+        '  HOME=. "$OSL" new $t p_$t > /dev/null',
+        // pixelgl needs a display, so the window template is built headless
+        '  flags=""; [ $t = window ] && flags=--headless',
+        '  if (cd p_$t && HOME=.. "$OSL" compile $flags main.osl -o app && HOME=.. "$OSL" run $flags main_test.osl) > out.txt 2>&1 && ! grep -q "^FAIL" out.txt; then echo "$t ok"; else echo "$t failed"; cat out.txt; fi',
+        'done',
+      ].join('\n'),
+      expect: ["cli ok", "lib ok", "tui ok", "web ok", "window ok", "ws ok"]
+    }
+  ),
+  helper.createTest(
+    'osl build ./... builds every entrypoint and sums up the ones that failed',
+    '',
+    {
+      files: {
+        'good/good.osl': 'def main() (\n  log "hi"\n)\n',
+        'bad/bad.osl': 'def main() (\n  nope()\n)\n',
+        'worse/worse.osl': 'def main() (\n#if true\n  log 1\n)\n',
+      },
+      run: `"$OSL" build -j 1 ./... > out.txt; echo $?; sed -n '/^Built/,$p' out.txt | sed -E 's/main.go:[0-9]+:[0-9]+/main.go/'`,
+      expect: [
+        1,
+        "Built 1 of 3 target(s), 2 failed:",
+        "",
+        "bad/bad.osl:",
+        "    # command-line-arguments",
+        "    ./main.go: undefined: nope",
+        "",
+        "worse/worse.osl:",
+        "    Line 2: #if without #end"
+      ]
+    }
+  ),
+  helper.createTest(
+    'osl debug builds a binary whose code maps back to the osl lines',
+    `def boom(x) (
+  log "in boom"
+  return x.nope
+)
+
+def main() (
+  log boom({})
+)
+`,
+    {
+      // the line table of the binary is what debuggers read
+      run: `"$OSL" debug test.osl --build-only > /dev/null && .osl-debug/test/test && ` +
+        `go tool objdump -s 'main\\.boom$' .osl-debug/test/test | awk '{print $1}' | grep -o '^test.osl:[0-9]*' | sort -u`,
+      expect: ["in boom", null, "test.osl:1", "test.osl:2", "test.osl:3"]
+    }
+  ),
+  helper.createTest(
+    'osl bench runs each bench block after the top level code and compares with saved results',
+    `
+      log "setup"
+      count = 0
+      text = ""
+      bench "add" (
+        count += 1
+      )
+      bench "concat" (
+        text = "a" ++ "b"
+      )
+    `,
+    {
+      run: `"$OSL" bench --time 20ms --save base.json > out.txt; grep -c setup out.txt; ` +
+        `awk '/ns.op/ {print $1, ($2 > 0), ($3 > 0), $4}' out.txt; grep -c '"ns_op"' base.json; ` +
+        `"$OSL" bench --run concat --time 20ms --compare base.json | grep -o '^concat .*(was [0-9.]* ns/op' | cut -d' ' -f1`,
+      expect: [1, "add 1 1 ns/op", "concat 1 1 ns/op", 2, "concat"]
+    }
+  ),
+  helper.createTest(
+    'osl fuzz --property checks each fuzz block and shrinks the input that fails',
+    `
+      import "osl/csv"
+      import "osl/regex"
+      import "osl/xml"
+
+      fuzz "csv keeps a field" (string s) (
+        // encoding/csv reads \\r\\n in a field as \\n
+        s = s.replace("\\r", "")
+        rows = csv.parse(csv.stringify({field: s}))
+        fuzz.check(rows.len == 1 and rows[1].field == s, "read back as", rows)
+      )
+
+      fuzz "regex escape matches itself" (string s) (
+        fuzz.check(regex.match("^" ++ regex.escape(s) ++ "$", s))
+      )
+
+      fuzz "xml parses anything" (string s) (
+        fuzz.check(xml.Parse("<a>" ++ s ++ "</a>") != null)
+      )
+
+      fuzz "small" (int n) (
+        fuzz.check(n < 10, n, "is too big")
+      )
+    `,
+    {
+      run: `"$OSL" fuzz test.osl --property --seed 1 --runs 300 > out.txt; echo $?; ` +
+        `sed -E 's/after [0-9]+ runs, shrunk [0-9]+ times/after some runs/; s/saved to .*testdata/saved to testdata/; s/ +$//' out.txt`,
+      expect: [
+        1,
+        "seed 1",
+        "ok    csv keeps a field           300 runs",
+        "ok    regex escape matches itself 300 runs",
+        "ok    xml parses anything         300 runs",
+        "FAIL  small                       after some runs: 10 is too big",
+        "        n = 10",
+        "        saved to testdata/fuzz/FuzzSmall/3d47a295d0f585b5",
+        "test.osl: exit status 1"
+      ]
+    }
+  ),
+  helper.createTest(
+    'osl fuzz reports a fuzz block that does not build as a build failure',
+    '',
+    {
+      files: {
+        'broken.osl': 'fuzz "broken" (string s) (\n  nope(s)\n)\n',
+        'fine.osl': 'fuzz "fine" (string s) (\n  fuzz.check(s.len >= 0)\n)\n',
+      },
+      run: `"$OSL" fuzz broken.osl --time 1s 2>&1 | tail -1; "$OSL" fuzz fine.osl --time 2s 2>&1 | grep -x PASS`,
+      expect: ["broken.osl: build failed, the errors are above", "PASS"]
+    }
+  ),
+  helper.createTest(
+    'osl transpile leaves out lines excluded by #if',
+    `
+      #if false
+      log "a"
+      #else
+      log "b"
+      #end
+    `,
+    {
+      run: `"$OSL" transpile test.osl | sed -n '/^func main/,/^}/p'`,
+      expect: ["func main() {", '\tOSLlogValues("b")', "}"]
+    }
+  ),
+  helper.createTest(
+    'osl transpile --eject writes a Go module that builds without osl',
+    `
+      embed "greeting.txt" as greeting
+
+      def greet(name) -> string (
+        return greeting.trim() + name
+      )
+
+      log greet("bob")
+    `,
+    {
+      files: { 'greeting.txt': 'hello\n' },
+      // the ejected module is built and run away from the osl source
+      run: `"$OSL" transpile test.osl --eject out > /dev/null; "$OSL" transpile test.osl --eject out | sed 's|/.*/out|out|'; ` +
+        `ls out; rm test.osl greeting.txt && (cd out && go build -o ../prog .) && ./prog`,
+      expect: ["Error: out is not empty", "go.mod", "greeting.txt", "main.go", "osl_runtime.go", "hello bob"]
+    }
+  ),
+  helper.createTest(
+    'osl compile --shared builds a library C can call, with errors returned per call',
+    `
+      export def add(int a, int b) -> int (
+        return a + b
+      )
+
+      export def greet(string name) -> string (
+        return "hi" + name
+      )
+
+      export def count(items) -> int (
+        return items.len
+      )
+    `,
+    {
+      files: {
+        'none.osl': 'def add(a, b) (\n  return a + b\n)\n',
+        'main.c': [
+          "#include <pthread.h>",
+          "#include <stdio.h>",
+          "#include \"libtest.h\"",
+          "",
+          "// threads with an even number pass bad JSON, each must only see its own error",
+          "static void *call(void *arg) {",
+          "\tlong n = (long)arg;",
+          "\tfor (int i = 0; i < 1000; i++) {",
+          "\t\tchar *err;",
+          "\t\tlong long got = count(n % 2 ? \"[1, 2]\" : \"[1,\", &err);",
+          "\t\tif ((err != NULL) != (n % 2 == 0) || got != (n % 2 ? 2 : 0)) {",
+          "\t\t\tprintf(\"thread %ld got %lld, error %s\\n\", n, got, err ? err : \"NULL\");",
+          "\t\t\treturn (void *)1;",
+          "\t\t}",
+          "\t\tosl_free(err);",
+          "\t}",
+          "\treturn NULL;",
+          "}",
+          "",
+          "int main(void) {",
+          "\tchar *err = \"unset\";",
+          "\tlong long sum = add(2, 3, &err);",
+          "\tprintf(\"%lld %s\\n\", sum, err ? err : \"NULL\");",
+          "\tchar *greeting = greet(\"bob\", NULL);",
+          "\tprintf(\"%s\\n\", greeting);",
+          "\tosl_free(greeting);",
+          "\tprintf(\"%lld\\n\", count(\"[1,\", &err));",
+          "\tprintf(\"%s\\n\", err);",
+          "\tosl_free(err);",
+          "",
+          "\tpthread_t threads[8];",
+          "\tfor (long n = 0; n < 8; n++) {",
+          "\t\tpthread_create(&threads[n], NULL, call, (void *)n);",
+          "\t}",
+          "\tint failed = 0;",
+          "\tfor (int n = 0; n < 8; n++) {",
+          "\t\tvoid *result;",
+          "\t\tpthread_join(threads[n], &result);",
+          "\t\tfailed |= result != NULL;",
+          "\t}",
+          "\tprintf(\"%s\\n\", failed ? \"threads mixed up their errors\" : \"threads kept their errors apart\");",
+          "\treturn 0;",
+          "}"
+        ].join('\n')
+      },
+      run: `"$OSL" compile none.osl --shared; ls | grep -c libnone; "$OSL" compile test.osl --shared > /dev/null && ` +
+        `gcc -Wall -Werror -o main main.c -L. -ltest -lpthread && LD_LIBRARY_PATH=. ./main`,
+      expect: [
+        "Error: --shared needs at least one export def, nothing would be callable from C",
+        0,
+        "5 NULL",
+        "hi bob",
+        0,
+        "invalid JSON argument: unexpected end of JSON input",
+        "threads kept their errors apart"
+      ]
+    }
+  ),
+];
+
+module.exports = { tests };
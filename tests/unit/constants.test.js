@@ -0,0 +1,51 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Constant used in function',
+    `
+      const RATE = 2
+
+      def double(number n) (
+        return n * RATE
+      )
+
+      log double(4)
+    `,
+    { expect: [8] }
+  ),
+
+  helper.createTest(
+    'Constant built from other constants',
+    `
+      const W = 3
+      const H = 4
+      const AREA = W * H
+      const LABEL = "area " ++ "size"
+
+      log AREA
+      log LABEL
+    `,
+    { expect: [12, 'area size'] }
+  ),
+
+  helper.createTest(
+    'Conditional compilation branches',
+    `
+      #if false
+      log "skipped"
+      #elif 1 == 1
+      log "elif"
+      #else
+      log "else"
+      #end
+      #if undefinedName
+      log "never"
+      #end
+      log "done"
+    `,
+    { expect: ['elif', 'done'] }
+  ),
+];
+
+module.exports = { tests };
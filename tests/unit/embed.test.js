@@ -0,0 +1,44 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Embedded files are read through osl/fs after the binary is moved',
+    `
+      import "osl/fs"
+      embed "assets"
+      embed "assets/hello.txt" as hello
+
+      log hello.trim()
+      log fs.ReadFile("assets/hello.txt").trim()
+      log fs.ReadDir("assets")
+      log fs.Exists("assets/index.html")
+    `,
+    {
+      files: { 'assets/hello.txt': 'hello\n', 'assets/index.html': '<p>page</p>\n' },
+      run: '"$OSL" compile test.osl -o app > /dev/null && rm -r assets && mkdir elsewhere && mv app elsewhere && cd elsewhere && ./app',
+      expect: ["hello", "hello", ["hello.txt", "index.html"], true]
+    }
+  ),
+
+  helper.createTest(
+    'router.Static serves embedded files after the binary is moved',
+    `
+      import "osl/serve"
+      embed "assets"
+
+      app = serve.New()
+      app.Static("/static", "assets")
+      app.Serve("127.0.0.1:18931")
+    `,
+    {
+      files: { 'assets/hello.txt': 'hello\n', 'assets/index.html': '<p>page</p>\n' },
+      run: '"$OSL" compile test.osl -o app > /dev/null && rm -r assets && mkdir elsewhere && mv app elsewhere && cd elsewhere && ' +
+        '{ ./app & pid=$!; ' +
+        'for i in $(seq 50); do curl -sf 127.0.0.1:18931/static/hello.txt && break; sleep 0.1; done; ' +
+        'curl -s 127.0.0.1:18931/static/; kill $pid; }',
+      expect: ["hello", "<p>page</p>"]
+    }
+  ),
+];
+
+module.exports = { tests };
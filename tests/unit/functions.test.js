@@ -168,6 +168,22 @@ const tests = [
       log nothing()
     `,
     { expect: [null] }
+  ),
+
+  helper.createTest(
+    'Typed returns with -> and export def outside a shared library',
+    `
+      export def add(int a, int b) -> int (
+        return a + b
+      )
+      def half(number n) -> number (
+        return n / 2
+      )
+
+      log add(2, 3)
+      log half(7)
+    `,
+    { expect: [5, 3.5] }
   )
 ];
 
@@ -0,0 +1,104 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Generator function with each',
+    `
+      def count(n) (
+        for i n (
+          yield i
+        )
+      )
+
+      each i v count(3) (
+        log i v
+      )
+    `,
+    { expect: [1, 1, 2, 2, 3, 3] }
+  ),
+
+  helper.createTest(
+    'Infinite generator stops on break',
+    `
+      def naturals() (
+        n = 0
+        while true (
+          n = n + 1
+          yield n
+        )
+      )
+
+      each n naturals() (
+        if n > 3 (
+          break
+        )
+        log n
+      )
+    `,
+    { expect: [1, 2, 3] }
+  ),
+
+  helper.createTest(
+    'Generator to array',
+    `
+      evens = def(limit) -> (
+        each n limit (
+          yield n + n
+        )
+      )
+
+      log evens([1, 2, 3]).toArray()
+      log typeof(evens([]))
+    `,
+    { expect: [[2, 4, 6], 'generator'] }
+  ),
+
+  helper.createTest(
+    'Each over ranges, strings and objects',
+    `
+      each x 3 to 1 (
+        log x
+      )
+      each c "hi" (
+        log c
+      )
+      each k {b: 2, a: 1} (
+        log k
+      )
+    `,
+    { expect: [3, 2, 1, 'h', 'i', 'a', 'b'] }
+  ),
+
+  helper.createTest(
+    'A range stored in a variable stays lazy',
+    `
+      r = 1 to 1000000000
+      log r.len
+      log r[5] r[1000000000] r[0]
+      log r.contains(999999999) r.contains(0)
+      seen = []
+      each n r (
+        seen.append(n)
+        if n == 4 (
+          break
+        )
+      )
+      log seen
+      log 1 to 5
+      log -2 to 2
+      log 3 to 1
+      down = 3 to 1
+      each i n down (
+        log i n
+      )
+      log typeof(down)
+      log (1 to 3).len
+    `,
+    {
+      // a billion numbers would run out of memory as an array
+      expect: [1000000000, 5, 1000000000, null, true, false, [1, 2, 3, 4], [1, 2, 3, 4, 5], [-2, -1, 0, 1, 2], [3, 2, 1], 1, 3, 2, 2, 3, 1, "array", 3]
+    }
+  ),
+];
+
+module.exports = { tests };
@@ -0,0 +1,45 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Headless window draws rectangles',
+    `
+      window.headless(200, 100)
+      mainloop:
+      c "#ff0000"
+      goto 0 0
+      square 40 20
+      log window.pixel(0, 0) window.pixel(15, 5) window.pixel(25, 0)
+    `,
+    { expect: ["#ff0000", "#ff0000", "#000000"] }
+  ),
+
+  helper.createTest(
+    'Headless window draws lines',
+    `
+      window.headless(100, 100)
+      mainloop:
+      c "#00ff00"
+      goto -30 10
+      change 0 0
+      icon "w 4 line 0 0 60 0" 1
+      log window.pixel(0, 10) window.pixel(0, 20)
+    `,
+    { expect: ["#00ff00", "#000000"] }
+  ),
+
+  helper.createTest(
+    'Headless window runs scripted input',
+    `
+      window.headless(100, 100, 3)
+      window.simulate("2 key space down\\n2 mouse 10 20 down\\n3 key space up")
+      frame = 0
+      mainloop:
+      frame += 1
+      log frame "space".isKeyDown() mouse_down mouse_x mouse_y
+    `,
+    { expect: [1, false, false, 0, 0, 2, true, true, 10, 20, 3, false, true, 10, 20] }
+  ),
+];
+
+module.exports = { tests };
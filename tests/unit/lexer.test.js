@@ -0,0 +1,58 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Compound operators and exponents without spaces',
+    `
+      number x = 1
+      x+=2
+      log x
+      log 1e-3 * 1000
+      log 2.5e+2
+      log "a"==="a"
+    `,
+    { expect: [3, 1, 250, true] }
+  ),
+
+  helper.createTest(
+    'Comments between statements and inside a line',
+    `
+      // leading comment
+      number y = 3 /* inline */ + 1
+
+      // a comment after a blank line
+      log y
+      /* a block
+         over lines */
+      log y * 2
+    `,
+    { expect: [4, 8] }
+  ),
+
+  helper.createTest(
+    'Comments at the end of a line',
+    `
+      x = 1 // trailing note
+      log x // after log
+      arr = [1, // one
+        2]
+      log arr.len
+      log "a // b" // not in strings
+    `,
+    { expect: [1, 2, "a // b"] }
+  ),
+
+  helper.createTest(
+    'Template strings with nested quotes and operators',
+    `
+      string name = "b"
+      log \`a\${name}-\${"c"}\`
+      log \`\${1+2}\`
+      arr = [1, 2, 3]
+      log arr[2]
+    `,
+    { expect: ["ab-c", 3, 2] }
+  ),
+];
+
+module.exports = { tests };
@@ -0,0 +1,63 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Mainloop without drawing ticks until stopped',
+    `
+      log "start"
+      ticks = 0
+      mainloop:
+      ticks += 1
+      log ticks
+      if ticks == 3 (
+        mainloop.stop()
+      )
+    `,
+    { expect: ["start", 1, 2, 3] }
+  ),
+
+  helper.createTest(
+    'Mainloop tick rate sets delta',
+    `
+      ticks = 0
+      start = timer
+      mainloop 50:
+      ticks += 1
+      if ticks == 5 (
+        log mainloop.delta timer >= 0 timestamp > 0
+        mainloop.stop()
+      )
+    `,
+    { expect: [0.02, true, true] }
+  ),
+
+  helper.createTest(
+    'Mainloop init runs before the first tick',
+    `
+      def setup() (
+        log "setup"
+      )
+      setup()
+      total = 10
+      log total
+      mainloop 1000:
+      total += 1
+      log total
+      mainloop.stop()
+    `,
+    { expect: ["setup", 10, 11] }
+  ),
+
+  helper.createTest(
+    'Mainloop leaves a variable called delta alone',
+    `
+      delta = "mine"
+      mainloop 1000:
+      log delta mainloop.delta
+      mainloop.stop()
+    `,
+    { expect: ["mine", 0.001] }
+  ),
+];
+
+module.exports = { tests };
@@ -0,0 +1,96 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Nullable variable narrows after assignment',
+    `
+      string null name = null
+      log name
+      log name?.toUpper()
+      name = "bob"
+      log name.toUpper()
+    `,
+    { expect: [null, null, "BOB"] }
+  ),
+
+  helper.createTest(
+    'Null check narrows a nullable parameter',
+    `
+      def greet(string null who) (
+        if who != null (
+          log "hi " ++ who.toUpper()
+        ) else (
+          log "nobody"
+        )
+      )
+
+      def shout(string null who) (
+        if who == null (
+          return
+        )
+        log who.toUpper()
+      )
+
+      greet("amy")
+      greet(null)
+      shout("x")
+      shout(null)
+    `,
+    { expect: ["hi AMY", "nobody", "X"] }
+  ),
+
+  helper.createTest(
+    'Optional chaining skips null values',
+    `
+      user = {address: {city: "paris"}}
+      log user?.address?.city
+      log user?.missing?.city
+      log user.address!.city
+    `,
+    { expect: ["paris", null, "paris"] }
+  ),
+
+  helper.createTest(
+    'Null checks on the left of and/or cover the right side',
+    `
+      def check(string null who) (
+        if who != null and who.len > 1 (
+          log "long"
+        )
+        if who == null or who.len == 0 (
+          log "empty"
+        )
+      )
+
+      check("bob")
+      check(null)
+    `,
+    {
+      // any warning would come before the output
+      run: '"$OSL" run test.osl 2>&1',
+      expect: ["long", "empty"]
+    }
+  ),
+
+  helper.createTest(
+    'Using a nullable value before checking it warns',
+    `
+      def unchecked(string null who) (
+        log who.len > 1 and who != null
+        log who?.len
+      )
+
+      unchecked("bob")
+    `,
+    {
+      run: '"$OSL" run test.osl 2>&1',
+      expect: [
+        "Warning: Line 3: 'who' may be null here. Check it with if who != null, use who?. or assert it with who!",
+        true,
+        3
+      ]
+    }
+  ),
+];
+
+module.exports = { tests };
@@ -0,0 +1,84 @@
+const helper = require('../helper.js');
+
+const VEC = `
+  type Vec (
+    number x = 0
+    number y = 0
+    init = def(number x, number y) -> (
+      self.x = x
+      self.y = y
+    )
+    __add = def(other) -> (
+      return Vec(self.x + other.x, self.y + other.y)
+    )
+    __eq = def(other) -> (
+      return self.x == other.x and self.y == other.y
+    )
+    __lt = def(other) -> (
+      return self.x + self.y < other.x + other.y
+    )
+    __str = def() -> (
+      return "Vec(" ++ self.x ++ ", " ++ self.y ++ ")"
+    )
+    __index = def(i) -> (
+      if i == 1 (
+        return self.x
+      )
+      return self.y
+    )
+  )
+`;
+
+const tests = [
+  helper.createTest(
+    'Type constructor and fields',
+    VEC + `
+      v = Vec(3, 4)
+      log v.x
+      log v.y
+    `,
+    { expect: [3, 4] }
+  ),
+
+  helper.createTest(
+    'Arithmetic and string hooks',
+    VEC + `
+      log Vec(1, 2) + Vec(3, 4)
+    `,
+    { expect: ['Vec(4, 6)'] }
+  ),
+
+  helper.createTest(
+    'Equality and ordering hooks',
+    VEC + `
+      a = Vec(1, 2)
+      b = Vec(3, 4)
+      log a == Vec(1, 2)
+      log a != b
+      log a < b
+      log a > b
+    `,
+    { expect: [true, true, true, false] }
+  ),
+
+  helper.createTest(
+    'Sort uses __lt',
+    VEC + `
+      arr = [Vec(3, 4), Vec(1, 2), Vec(0, 0)]
+      log arr.sort()
+    `,
+    { expect: [['Vec(0, 0)', 'Vec(1, 2)', 'Vec(3, 4)']] }
+  ),
+
+  helper.createTest(
+    'Index hook',
+    VEC + `
+      v = Vec(5, 6)
+      log v[1]
+      log v[2]
+    `,
+    { expect: [5, 6] }
+  ),
+];
+
+module.exports = { tests };
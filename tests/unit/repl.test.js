@@ -0,0 +1,56 @@
+const helper = require('../helper.js');
+
+// runs osl repl on the test's stdin without the banner and the prompts, and with
+// its history kept in the test directory
+const repl = (filter = '') =>
+  `HOME=. "$OSL" repl | tail -n +2 | sed -E 's/^((osl|\\.\\.\\.)> )+//'${filter}`;
+
+const tests = [
+  helper.createTest(
+    'repl keeps variables between inputs',
+    '',
+    {
+      run: repl(),
+      stdin: 'x = 5\nx * 2\ny = x * 3\ny\n',
+      expect: ["10 : number", "15 : number"]
+    }
+  ),
+  helper.createTest(
+    'repl :type shows the type of an expression',
+    '',
+    {
+      run: repl(),
+      stdin: 'name = "osl"\n:type name\n:type [1, 2]\n:type name.len\n',
+      expect: ["string", "array", "int"]
+    }
+  ),
+  helper.createTest(
+    'repl :ast shows the tokens of a line',
+    '',
+    {
+      run: repl(` | grep -o '"type": "[a-z]*"'`),
+      stdin: ':ast a = 1 + 2\n',
+      expect: ['"type": "asi"', '"type": "var"', '"type": "opr"', '"type": "num"', '"type": "num"']
+    }
+  ),
+  helper.createTest(
+    'repl reads a block over several lines',
+    '',
+    {
+      run: repl(),
+      stdin: 'def twice(n) (\n  return n * 2\n)\ntwice(4)\ntwice("ab")\n',
+      expect: ["8 : number", "abab : string"]
+    }
+  ),
+  helper.createTest(
+    'repl imports packages',
+    '',
+    {
+      run: repl(),
+      stdin: ':import osl/math\nmath.abs(-4)\nmath.avg([1, 2, 3])\n',
+      expect: ["4 : number", "2 : number"]
+    }
+  ),
+];
+
+module.exports = { tests };
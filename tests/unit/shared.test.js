@@ -0,0 +1,78 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Shared object read and write',
+    `
+      shared config = {}
+      def main() (
+        config.name = "osl"
+        log config.name
+        log config
+      )
+    `,
+    { expect: ['osl', { name: 'osl' }] }
+  ),
+
+  helper.createTest(
+    'Shared array append, pop and len',
+    `
+      shared items = []
+      items.append(1)
+      items.append(2)
+      items.append(3)
+      log items.pop()
+      log items.len
+      log items
+    `,
+    { expect: [3, 2, [1, 2]] }
+  ),
+
+  helper.createTest(
+    'Shared array iteration',
+    `
+      shared items = ["a", "b"]
+      each i v items (
+        log i v
+      )
+    `,
+    { expect: [1, 'a', 2, 'b'] }
+  ),
+
+  helper.createTest(
+    'Shared array written from go block',
+    `
+      shared items = []
+      go (
+        items.append("done")
+      )
+      wait 1
+      log items
+    `,
+    { expect: [['done']] }
+  ),
+
+  helper.createTest(
+    'Writing a global from a go block warns with a fix that fits its value',
+    `
+      count = 0
+      items = []
+      go (
+        count += 1
+        items = ["done"]
+      )
+      wait 1
+      log count
+    `,
+    {
+      run: '"$OSL" run test.osl 2>&1',
+      expect: [
+        "Warning: Line 5: global 'count' is written inside a go block without synchronisation. Wrap the writes and reads in lock.Lock(\"count\") and lock.Unlock(\"count\") from osl/lock",
+        "Warning: Line 6: global 'items' is written inside a go block without synchronisation. Declare it with 'shared' or compile with --safe-globals",
+        1
+      ]
+    }
+  ),
+];
+
+module.exports = { tests };
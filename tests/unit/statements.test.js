@@ -30,6 +30,32 @@ const tests = [
       )
     `,
     { expect: [1, 2, 3] }
+  ),
+
+  helper.createTest(
+    'Bench blocks only run under osl bench',
+    `
+      result = null
+      bench "skipped" (
+        log "bench"
+        result = 1
+      )
+      log result
+    `,
+    { expect: [null] }
+  ),
+
+  helper.createTest(
+    'Fuzz blocks only run under osl fuzz',
+    `
+      result = null
+      fuzz "skipped" (string s, int n) (
+        log s n
+        result = 1
+      )
+      log result
+    `,
+    { expect: [null] }
   )
 ];
 
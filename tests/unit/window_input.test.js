@@ -0,0 +1,53 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Window input variables',
+    `
+      window.headless(100, 100, 3)
+      window.simulate("2 mouse 5 -5 down\\n2 scroll 0 -2\\n2 type hi there\\n3 mouse up\\n3 blur")
+      mainloop:
+      log mouse_x mouse_pressed mouse_released scroll_y typed_text window_focused
+    `,
+    { expect: [0, false, false, 0, "", true, 5, true, false, -2, "hi there", true, 5, false, true, 0, "", false] }
+  ),
+
+  helper.createTest(
+    'Window input callbacks',
+    `
+      window.headless(100, 100, 3)
+      window.simulate("1 mouse down right\\n2 key esc down\\n3 key escape up\\n3 resize 50 40")
+      window.on("mousedown", def(x, y, button) -> (
+        log "mousedown" button
+      ))
+      window.on("keydown", def(key) -> (
+        log "keydown" key
+      ))
+      window.on("keyup", def(key) -> (
+        log "keyup" key
+      ))
+      window.on("resize", def(w, h) -> (
+        log "resize" w h
+      ))
+      mainloop:
+      log "esc".isKeyDown() "esc".onKeyDown()
+    `,
+    { expect: ["mousedown", "right", false, false, "keydown", "escape", true, true, "keyup", "escape", "resize", 50, 40, false, false] }
+  ),
+
+  helper.createTest(
+    'Window gamepads',
+    `
+      window.headless(100, 100, 3)
+      window.simulate("2 gamepad 1 button 2 down\\n2 gamepad 1 axis 1 -0.5\\n3 gamepad 1 button 2 up")
+      window.on("gamepaddown", def(pad, button) -> (
+        log "gamepaddown" pad button
+      ))
+      mainloop:
+      log getGamepads().len
+    `,
+    { expect: [0, "gamepaddown", 1, 2, 1, 1] }
+  ),
+];
+
+module.exports = { tests };
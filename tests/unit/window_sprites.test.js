@@ -0,0 +1,61 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Window transform stack',
+    `
+      window.headless(100, 100)
+      mainloop:
+      c "#ff0000"
+      window.push()
+      window.translate(20, 0)
+      window.rotate(45)
+      goto 0 0
+      square 4 30
+      window.pop()
+      goto -30 0
+      square 4 4
+      log window.pixel(30, 10) window.pixel(20, 0) window.pixel(-30, 0) window.pixel(-10, 0)
+    `,
+    { expect: ["#ff0000", "#ff0000", "#ff0000", "#000000"] }
+  ),
+
+  helper.createTest(
+    'Window clipping and alpha',
+    `
+      window.headless(100, 100)
+      mainloop:
+      c "#ffffff"
+      window.push()
+      window.clip(0, 0, 10, 10)
+      square 40 40
+      window.pop()
+      window.alpha(0.5)
+      goto 30 30
+      square 6 6
+      log window.pixel(0, 0) window.pixel(15, 0) window.pixel(30, 30)
+    `,
+    { expect: ["#ffffff", "#000000", "#7f7f7f"] }
+  ),
+
+  helper.createTest(
+    'Window draws layers as sprites',
+    `
+      window.headless(100, 100)
+      layer = window.layer(10, 10)
+      mainloop:
+      window.target(layer)
+      window.clear("#0000ff")
+      c "#ffffff"
+      goto -3 0
+      square 4 10
+      window.target(null)
+      goto 0 0
+      window.sprite(layer, {scale: 2, rotation: 90, tint: "#00ff00"})
+      log window.pixel(0, 6) window.pixel(0, -6) window.pixel(12, 0)
+    `,
+    { expect: ["#00ff00", "#000000", "#000000"] }
+  ),
+];
+
+module.exports = { tests };
@@ -0,0 +1,97 @@
+const helper = require('../helper.js');
+
+const tests = [
+  helper.createTest(
+    'Worker frames until it kills itself',
+    `
+      w = worker({
+        n: 0,
+        fps: 200,
+        onframe: def() -> (
+          self.n = self.n + 1
+          if self.n == 3 (
+            self.postMessage("three")
+            self.kill()
+          )
+        ),
+        onkill: def() -> (
+          return "stopped at " ++ self.n
+        )
+      })
+      log w.receive()
+      log w.join() w.alive w.n
+    `,
+    { expect: ["three", "stopped at 3", false, 3] }
+  ),
+
+  helper.createTest(
+    'Worker handles messages and returns its onkill result',
+    `
+      echo = worker({
+        onmessage: def(string msg) -> (
+          self.postMessage(msg.toUpper())
+        ),
+        onkill: def() -> (
+          return "done"
+        )
+      })
+      echo.postMessage("hi")
+      log echo.receive()
+      log echo.receive(10)
+      log echo.kill() echo.alive
+    `,
+    { expect: ["HI", null, "done", false] }
+  ),
+
+  helper.createTest(
+    'Worker pool shares a queue between workers',
+    `
+      pool = workerPool(3, {
+        onmessage: def(number n) -> (
+          self.postMessage(n * n)
+        ),
+        onkill: def() -> (
+          return self.id
+        )
+      })
+      for i 4 (
+        pool.postMessage(i)
+      )
+      total = 0
+      for i 4 (
+        total += pool.receive().toInt()
+      )
+      log pool.size() total pool.kill()
+    `,
+    { expect: [3, 30, [1, 2, 3]] }
+  ),
+
+  helper.createTest(
+    'Worker pool keeps working when one of its workers ends',
+    `
+      pool = workerPool(3, {
+        oncreate: def() -> (
+          if self.id == 1 (
+            self.kill()
+          )
+        ),
+        onmessage: def(number n) -> (
+          self.postMessage(n * 10)
+        )
+      })
+      for i 4 (
+        pool.postMessage(i)
+      )
+      total = 0
+      for i 4 (
+        total += pool.receive(1000).toInt()
+      )
+      log total
+      pool.kill()
+      log pool.postMessage(5) pool.receive()
+    `,
+    { expect: [100, false, null] }
+  ),
+];
+
+module.exports = { tests };
@@ -0,0 +1,100 @@
+// genstd copies packages/std.go into the compiler as std_gen.go so the
+// interpreter runs the same runtime helpers as compiled programs.
+//
+// Run it with go generate from the repository root.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+)
+
+// stdPackages maps the package names used in std.go to their import paths,
+// matching the default imports the compiler adds to every program
+var stdPackages = map[string]string{
+	"base64":  "encoding/base64",
+	"bufio":   "bufio",
+	"bytes":   "bytes",
+	"fmt":     "fmt",
+	"iter":    "iter",
+	"json":    "encoding/json",
+	"math":    "math",
+	"os":      "os",
+	"OSLio":   "io",
+	"OSLiofs": "io/fs",
+	"OSLrand": "math/rand",
+	"reflect": "reflect",
+	"runtime": "runtime",
+	"sort":    "sort",
+	"strconv": "strconv",
+	"strings": "strings",
+	"sync":    "sync",
+	"time":    "time",
+	"unsafe":  "unsafe",
+}
+
+func main() {
+	src, err := os.ReadFile("packages/std.go")
+	if err != nil {
+		fmt.Println("Failed to read std.go:", err)
+		os.Exit(1)
+	}
+	src = append([]byte("package main\n\n"), src...)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "std.go", src, parser.ParseComments)
+	if err != nil {
+		fmt.Println("Failed to parse std.go:", err)
+		os.Exit(1)
+	}
+
+	used := map[string]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+			if _, known := stdPackages[id.Name]; known {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return stdPackages[names[i]] < stdPackages[names[j]] })
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by tools/genstd from packages/std.go. DO NOT EDIT.\n\n")
+	out.WriteString("package main\n\nimport (\n")
+	for _, name := range names {
+		path := stdPackages[name]
+		if name == path || "encoding/"+name == path {
+			fmt.Fprintf(&out, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(&out, "\t%v %q\n", name, path)
+		}
+	}
+	out.WriteString(")\n\n")
+	out.Write(src[len("package main\n\n"):])
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		fmt.Println("Failed to format std_gen.go:", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile("std_gen.go", formatted, 0644); err != nil {
+		fmt.Println("Failed to write std_gen.go:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	goast "go/ast"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fileMarker starts the code for one file of a split transpile, followed by the file name
+const fileMarker = "//osl:file "
+
+// fileDirective starts the code that goes into the named file when the output is split
+func fileDirective(name string) string {
+	if !compileOptions.Split {
+		return ""
+	}
+	return "\n" + fileMarker + name + "\n"
+}
+
+// buildSuffixes are the file name suffixes go build reads as constraints
+var buildSuffixes = strings.Fields(`test aix android darwin dragonfly freebsd hurd illumos ios js
+	linux netbsd openbsd plan9 solaris wasip1 windows zos 386 amd64 arm arm64 loong64 mips mipsle
+	mips64 mips64le ppc64 ppc64le riscv64 s390x wasm`)
+
+// importFileName names the Go file an imported .osl or .go file is transpiled into
+func importFileName(importPath string) string {
+	name := strings.TrimPrefix(importPath, "./")
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".osl"), ".go")
+	name = strings.NewReplacer("/", "_", "\\", "_", ".", "_").Replace(name)
+	suffix := ""
+	if i := strings.LastIndex(name, "_"); i >= 0 {
+		suffix = name[i+1:]
+	}
+	if name == "main" || strings.HasPrefix(name, "_") || slices.Contains(buildSuffixes, suffix) {
+		name += "_osl"
+	}
+	return name + ".go"
+}
+
+// commented puts the comments written around an osl statement onto the Go code it
+// compiled to. The comments before it go above, the comment after it ends the first line.
+func commented(tok *Token, code string) string {
+	if !compileOptions.Comments || tok == nil || strings.TrimSpace(code) == "" {
+		return code
+	}
+	leading := tok.Comments
+	if tok.Comment != "" {
+		first, rest, multiline := strings.Cut(code, "\n")
+		if strings.Contains(first, "`") {
+			// the line might go on inside a raw string
+			leading = append(slices.Clip(leading), tok.Comment)
+		} else {
+			code = first + " " + tok.Comment
+			if multiline {
+				code += "\n" + rest
+			}
+		}
+	}
+	if len(leading) == 0 {
+		return code
+	}
+	return strings.Join(leading, "\n") + "\n" + code
+}
+
+// compileCommented compiles a line with its comments. Lines that declare a global
+// compile to nothing, so their comments go with the global instead.
+func compileCommented(line []*Token, ctx *VariableContext) string {
+	if !compileOptions.Comments || len(line) == 0 {
+		return CompileLine(line, ctx)
+	}
+	globals := ctx.GlobalVars.Len()
+	code := CompileLine(line, ctx)
+	if strings.TrimSpace(code) == "" && ctx.GlobalVars.Len() > globals {
+		all := ctx.GlobalVars.String()
+		ctx.GlobalVars.Reset()
+		ctx.GlobalVars.WriteString(all[:globals] + commented(line[0], all[globals:]))
+		return code
+	}
+	return commented(line[0], code)
+}
+
+var (
+	majorVersionRegex = regexp.MustCompile(`^v[0-9]+$`)
+	gopkgVersionRegex = regexp.MustCompile(`\.v[0-9]+$`)
+	moduleCharRegex   = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// goPackageName guesses the name a package is imported under from its path, the way
+// goimports does when it cannot load the package
+func goPackageName(importPath string) string {
+	name := path.Base(importPath)
+	if majorVersionRegex.MatchString(name) && path.Dir(importPath) != "." {
+		name = path.Base(path.Dir(importPath))
+	}
+	name = gopkgVersionRegex.ReplaceAllString(name, "")
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "go-"), "-go")
+	return strings.ReplaceAll(name, "-", "")
+}
+
+// goFile is one file of split transpile output
+type goFile struct {
+	name string
+	code strings.Builder
+}
+
+// splitGo splits transpiled Go code at its file markers into formatted files, each
+// importing only the packages it uses. Blank imports stay with main.go.
+func splitGo(code string) (map[string][]byte, error) {
+	header, body, ok := strings.Cut(code, "\n"+fileMarker)
+	if !ok {
+		return nil, fmt.Errorf("no file markers in the transpiled code")
+	}
+	fset := token.NewFileSet()
+	head, err := goparser.ParseFile(fset, "header.go", header, goparser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*goFile
+	byName := make(map[string]*goFile)
+	for _, segment := range strings.Split(fileMarker+body, "\n"+fileMarker) {
+		name, source, _ := strings.Cut(strings.TrimPrefix(segment, fileMarker), "\n")
+		file, ok := byName[name]
+		if !ok {
+			file = &goFile{name: name}
+			byName[name] = file
+			files = append(files, file)
+		}
+		file.code.WriteString(source + "\n")
+	}
+	if _, ok := byName["main.go"]; !ok {
+		return nil, fmt.Errorf("no main.go in the transpiled code")
+	}
+
+	out := make(map[string][]byte)
+	for _, file := range files {
+		source := "package main\n\n" + file.code.String()
+		parsed, err := goparser.ParseFile(fset, file.name, source, goparser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		used := make(map[string]bool)
+		goast.Inspect(parsed, func(n goast.Node) bool {
+			// a name declared in the file itself shadows the package
+			if sel, ok := n.(*goast.SelectorExpr); ok {
+				if id, ok := sel.X.(*goast.Ident); ok && id.Obj == nil {
+					used[id.Name] = true
+				}
+			}
+			return true
+		})
+
+		var imports []string
+		for _, spec := range head.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			name := goPackageName(importPath)
+			alias := ""
+			if spec.Name != nil {
+				name, alias = spec.Name.Name, spec.Name.Name+" "
+			}
+			if (name == "_" || name == ".") && file.name == "main.go" || used[name] {
+				imports = append(imports, "\t"+alias+spec.Path.Value+"\n")
+			}
+		}
+		if len(imports) > 0 {
+			source = "package main\n\nimport (\n" + strings.Join(imports, "") + ")\n\n" + file.code.String()
+		}
+		formatted, err := format.Source([]byte(source))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.name, err)
+		}
+		out[file.name] = formatted
+	}
+	return out, nil
+}
+
+// writeGoFiles writes split transpile output into dir
+func writeGoFiles(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moduleName makes a Go module path out of a directory name
+func moduleName(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	name := strings.Trim(moduleCharRegex.ReplaceAllString(filepath.Base(abs), "-"), "-.")
+	if name == "" {
+		return "app"
+	}
+	return strings.ToLower(name)
+}
+
+// goModVersion is the go version for a new go.mod, the one the installed toolchain speaks,
+// since osl packages use whatever the standard library offers
+func goModVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	major, rest, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(string(out)), "go"), ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	if err != nil || major == "" || minor == "" {
+		return "1.23"
+	}
+	return major + "." + minor
+}
+
+// eject writes a standalone Go module for the program into dir, with the go.mod of the
+// osl project when it has one, and the files the program embeds
+func eject(dir string, files map[string][]byte, scriptDir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	if err := writeGoFiles(dir, files); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(scriptDir, "go.mod")); err == nil {
+		if err := copyGoModFiles(scriptDir, dir); err != nil {
+			return err
+		}
+	} else {
+		mod := fmt.Sprintf("module %s\n\ngo %s\n", moduleName(dir), goModVersion())
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644); err != nil {
+			return err
+		}
+	}
+	return copyEmbeddedFiles(scriptDir, dir)
+}
+
+// transpileOptions are the flags of osl transpile on top of the build flags
+type transpileOptions struct {
+	ejectDir string
+	args     []string
+}
+
+func parseTranspileArgs(args []string) (transpileOptions, error) {
+	var opts transpileOptions
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--eject" {
+			opts.args = append(opts.args, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, fmt.Errorf("--eject flag requires a directory")
+		}
+		opts.ejectDir = args[i+1]
+		i++
+	}
+	return opts, nil
+}
+
+func transpile(args []string) {
+	topts, err := parseTranspileArgs(args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	opts, err := parseBuildArgs(topts.args, true)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if opts.inputFile == "" {
+		fmt.Println("Usage: osl transpile <file.osl> [-o <dir>] [--eject <dir>] [--safe-globals]")
+		return
+	}
+	if opts.output != "" && topts.ejectDir != "" {
+		fmt.Println("Error: -o and --eject cannot be used together")
+		return
+	}
+	compileOptions = opts.toCompileOptions()
+	compileOptions.Comments = true
+	compileOptions.Split = opts.output != "" || topts.ejectDir != ""
+
+	// output paths are relative to where osl was run, not the script
+	outDir := opts.output
+	if topts.ejectDir != "" {
+		outDir = topts.ejectDir
+	}
+	if outDir != "" {
+		if outDir, err = filepath.Abs(outDir); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	scriptPath := opts.inputFile
+
+	scriptDir := filepath.Dir(scriptPath)
+	originalDir, err := os.Getwd()
+	if err != nil {
+		fmt.Println("Failed to get current directory:", err)
+		return
+	}
+
+	if err := os.Chdir(scriptDir); err != nil {
+		fmt.Println("Failed to change to script directory:", err)
+		return
+	}
+	defer func() {
+		_ = os.Chdir(originalDir)
+	}()
+
+	script := openFile(filepath.Base(scriptPath))
+	if script == "" {
+		return
+	}
+	code := scriptToGo(script)
+
+	if !compileOptions.Split {
+		formatted, err := format.Source([]byte(code))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: could not format the Go code:", err)
+			fmt.Println(code)
+			return
+		}
+		fmt.Print(string(formatted))
+		return
+	}
+
+	files, err := splitGo(code)
+	if err != nil {
+		fmt.Println("Error: could not split the Go code into files:", err)
+		return
+	}
+	if topts.ejectDir == "" {
+		if err := writeGoFiles(outDir, files); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Printf("Wrote %d files to %s\n", len(files), outDir)
+		return
+	}
+
+	if err := eject(outDir, files, "."); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Ejected %s to the Go module in %s\n", scriptPath, outDir)
+	fmt.Println("The osl source is no longer needed, build it with:")
+	fmt.Printf("  cd %s\n", outDir)
+	if hasThirdPartyImports(files) {
+		fmt.Println("  go mod tidy")
+	}
+	fmt.Println("  go build")
+}
+
+// hasThirdPartyImports reports whether any file imports a package outside the standard library
+func hasThirdPartyImports(files map[string][]byte) bool {
+	fset := token.NewFileSet()
+	for name, data := range files {
+		parsed, err := goparser.ParseFile(fset, name, data, goparser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, spec := range parsed.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			if first, _, _ := strings.Cut(importPath, "/"); strings.Contains(first, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// wasmTargets maps each --target name to the GOOS it builds with
+var wasmTargets = map[string]string{
+	"wasm":   "js",
+	"wasip1": "wasip1",
+}
+
+// wasmUnsupported lists the packages that cannot work inside each target, with the reason why
+var wasmUnsupported = map[string]map[string]string{
+	"wasm": {
+		"osl/fs":      "browsers have no file system",
+		"osl/process": "browsers cannot start processes",
+		"osl/db":      "sqlite needs cgo, which wasm does not support",
+		"osl/window":  "pixelgl needs OpenGL through cgo, which wasm does not support",
+	},
+	"wasip1": {
+		"osl/process": "wasi cannot start processes",
+		"osl/db":      "sqlite needs cgo, which wasm does not support",
+		"osl/window":  "pixelgl needs OpenGL through cgo, which wasm does not support",
+	},
+}
+
+const WASM_LOADER = `<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>%[1]v</title>
+</head>
+<body>
+	<pre id="output"></pre>
+	<script src="wasm_exec.js"></script>
+	<script>
+		const output = document.getElementById("output");
+		const log = console.log;
+		console.log = (...args) => {
+			output.textContent += args.join(" ") + "\n";
+			log(...args);
+		};
+
+		const go = new Go();
+		WebAssembly.instantiateStreaming(fetch("%[2]v"), go.importObject)
+			.then((result) => go.run(result.instance))
+			.catch((err) => console.error(err));
+	</script>
+</body>
+</html>
+`
+
+// checkTargetImports returns an error for the first import the target cannot support
+func checkTargetImports(ast [][]*Token, target string) error {
+	unsupported := wasmUnsupported[target]
+	for _, line := range ast {
+		if len(line) > 1 && line[0].Type == TKN_CMD && line[0].Data == "import" {
+			importPath, _ := line[1].Data.(string)
+			if reason, ok := unsupported[importPath]; ok {
+				return fmt.Errorf("line %d: %v cannot be used with --target %v, %v", line[0].Line, importPath, target, reason)
+			}
+		}
+		for _, token := range line {
+			if token.Type != TKN_BLK {
+				continue
+			}
+			if block, ok := token.Data.([][]*Token); ok {
+				if err := checkTargetImports(block, target); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// wasmExecPath finds the wasm_exec.js glue that matches the installed Go toolchain
+func wasmExecPath() (string, error) {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find GOROOT: %w", err)
+	}
+	goroot := strings.TrimSpace(string(out))
+	for _, dir := range []string{"lib/wasm", "misc/wasm"} {
+		path := filepath.Join(goroot, dir, "wasm_exec.js")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("wasm_exec.js not found in %v", goroot)
+}
+
+// writeWasmLoader writes wasm_exec.js and an html page that runs the module next to it
+func writeWasmLoader(wasmPath string) (string, error) {
+	execPath, err := wasmExecPath()
+	if err != nil {
+		return "", err
+	}
+	glue, err := os.ReadFile(execPath)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(wasmPath)
+	if err := os.WriteFile(filepath.Join(dir, "wasm_exec.js"), glue, 0644); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(wasmPath)
+	htmlPath := filepath.Join(dir, strings.TrimSuffix(name, ".wasm")+".html")
+	page := fmt.Sprintf(WASM_LOADER, strings.TrimSuffix(name, ".wasm"), name)
+	if err := os.WriteFile(htmlPath, []byte(page), 0644); err != nil {
+		return "", err
+	}
+	return htmlPath, nil
+}